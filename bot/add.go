@@ -1,30 +1,192 @@
 package bot
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/denverquane/slickshift/shift"
 	"github.com/denverquane/slickshift/store"
-	"log"
 )
 
+// MaxAttachmentSize is the largest text file /add will read codes from
+const MaxAttachmentSize = 1 << 20
+
+// attachmentTimeout is how long /add waits to download a text file, so Discord's 3-second deadline for a response
+// isn't missed
+const attachmentTimeout = 2 * time.Second
+
+// discordMessageLimit is the most characters Discord allows in a message
+const discordMessageLimit = 2000
+
+type AddStatus string
+
+const (
+	CodeAdded     AddStatus = "added"
+	CodeDuplicate AddStatus = "duplicate"
+	CodeInvalid   AddStatus = "invalid"
+)
+
+// AddResult is what happened to one code, or to something that looked like one, when adding a batch of them
+type AddResult struct {
+	Code   string    `json:"code"`
+	Status AddStatus `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+}
+
 func (bot *Bot) addResponse(userID string, s *discordgo.Session, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
-	code := i.ApplicationCommandData().Options[0].StringValue()
-	if !shift.CodeRegex.MatchString(code) {
-		return privateMessageResponse("Hm, doesn't look like you provided a valid SHiFT code. It should look something like:\n\n" +
-			"`XXXX-XXXXX-XXXXX-XXXXX-XXXXX`")
+	data := i.ApplicationCommandData()
+	game := string(shift.DefaultGame)
+	if option := data.GetOption("game"); option != nil {
+		game = option.StringValue()
+	}
+	if !shift.ValidGame(game) {
+		return privateMessageResponse("Hm, I don't know the game `" + game + "`")
+	}
 
+	var texts []string
+	if option := data.GetOption("code"); option != nil {
+		texts = append(texts, option.StringValue())
 	}
-	if bot.storage.CodeExists(code) {
-		return privateMessageResponse("It looks like that code already exists!\nThanks anyways!")
+	if option := data.GetOption("file"); option != nil {
+		text, err := readAttachment(data, option)
+		if err != nil {
+			log.Println(err)
+			return privateMessageResponse("Hm, I couldn't read that file: " + err.Error())
+		}
+		texts = append(texts, text)
 	}
+	if len(texts) == 0 {
+		return privateMessageResponse("Please provide a SHiFT code, some text containing codes, or a text file of them!")
+	}
+
 	var src = store.DiscordSource
-	err := bot.storage.AddCode(code, string(shift.Borderlands4), &userID, &src)
+	results, err := bot.addCodes(texts, game, &userID, &src)
 	if err != nil {
 		log.Println(err)
 		return nil
 	}
-	// trigger reprocessing because a new code was added
-	bot.triggerRedemptionProcessing("")
+	return privateMessageResponse(addResultsText(results))
+}
+
+// readAttachment downloads the text file attached to the option
+func readAttachment(data discordgo.ApplicationCommandInteractionData, option *discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	id, _ := option.Value.(string)
+	if data.Resolved == nil || data.Resolved.Attachments[id] == nil {
+		return "", errors.New("the attachment is missing")
+	}
+	attachment := data.Resolved.Attachments[id]
+	if attachment.ContentType != "" && !strings.HasPrefix(attachment.ContentType, "text/") {
+		return "", errors.New("it isn't a text file")
+	}
+	if attachment.Size > MaxAttachmentSize {
+		return "", fmt.Errorf("it's larger than %dKB", MaxAttachmentSize/1024)
+	}
 
-	return privateMessageResponse("Nice, thanks for adding the code! It should be tested and validated soon!")
+	client := http.Client{Timeout: attachmentTimeout}
+	resp, err := client.Get(attachment.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading it failed with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxAttachmentSize))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// addCodes adds every code found in the texts, and reports what happened to each of them, along with anything that
+// looked like a code but wasn't. A text without any codes in it is reported as invalid. New codes are only redeemed for
+// canary accounts until one of them shows the code is valid. Redemption processing is triggered once if any codes were
+// added, rather than once per code
+func (bot *Bot) addCodes(texts []string, game string, userID *string, source *string) ([]AddResult, error) {
+	var results []AddResult
+	added := false
+	defer func() {
+		// trigger reprocessing because new codes were added
+		if added {
+			bot.triggerRedemptionProcessing("")
+		}
+	}()
+
+	for _, text := range texts {
+		codes, rejected := shift.ExtractCodes(text)
+		if len(codes) == 0 && len(rejected) == 0 {
+			results = append(results, AddResult{Code: shorten(strings.TrimSpace(text)), Status: CodeInvalid, Reason: "no SHiFT code found"})
+			continue
+		}
+		for _, code := range codes {
+			inserted, err := bot.storage.AddCodeForValidation(code, game, userID, source)
+			if err != nil {
+				return results, err
+			}
+			if !inserted {
+				results = append(results, AddResult{Code: code, Status: CodeDuplicate})
+				continue
+			}
+			added = true
+			results = append(results, AddResult{Code: code, Status: CodeAdded})
+		}
+		for _, r := range rejected {
+			results = append(results, AddResult{Code: r.Text, Status: CodeInvalid, Reason: r.Reason})
+		}
+	}
+	return results, nil
+}
+
+// shorten cuts text without any codes in it down to a length that's reasonable to echo back
+func shorten(text string) string {
+	const maxLength = 64
+	if runes := []rune(text); len(runes) > maxLength {
+		return string(runes[:maxLength]) + "…"
+	}
+	return text
+}
+
+// addResultsText summarizes what happened to the codes a user added, listing as many as fit in a message
+func addResultsText(results []AddResult) string {
+	counts := map[AddStatus]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+
+	var str string
+	switch {
+	case len(results) == 1 && counts[CodeAdded] == 1:
+		return "Nice, thanks for adding the code! It should be tested and validated soon!"
+	case len(results) == 1 && counts[CodeDuplicate] == 1:
+		return "It looks like that code already exists!\nThanks anyways!"
+	case counts[CodeAdded] == 0 && counts[CodeDuplicate] == 0:
+		str = "Hm, doesn't look like you provided a valid SHiFT code. It should look something like:\n\n" +
+			"`XXXXX-XXXXX-XXXXX-XXXXX-XXXXX`\n"
+	case counts[CodeAdded] > 0:
+		str = fmt.Sprintf("Nice, thanks for adding %d code(s)! They should be tested and validated soon!\n", counts[CodeAdded])
+	default:
+		str = "It looks like those codes already exist!\nThanks anyways!\n"
+	}
+
+	for n, result := range results {
+		line := "\n" + ThumbsUp + " `" + result.Code + "` added"
+		switch result.Status {
+		case CodeDuplicate:
+			line = "\n" + ThumbsUp + " `" + result.Code + "` already exists"
+		case CodeInvalid:
+			line = "\n" + X + " `" + result.Code + "`: " + result.Reason
+		}
+		more := fmt.Sprintf("\n...and %d more", len(results)-n)
+		if len(str)+len(line)+len(more) > discordMessageLimit {
+			return str + more
+		}
+		str += line
+	}
+	return str
 }
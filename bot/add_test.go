@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
+)
+
+func newTestBot(t *testing.T) *Bot {
+	return &Bot{
+		storage:  store.NewMemoryStore(),
+		triggers: NewCoalescer(time.Hour),
+	}
+}
+
+// sentDM is a DM the bot would have sent
+type sentDM struct {
+	userID  string
+	content string
+}
+
+// recordDMs makes the bot record the DMs it sends instead of sending them, and returns them
+func recordDMs(bot *Bot) *[]sentDM {
+	var dms []sentDM
+	bot.sendDM = func(userID, content string) error {
+		dms = append(dms, sentDM{userID, content})
+		return nil
+	}
+	return &dms
+}
+
+func TestAddCodes(t *testing.T) {
+	bot := newTestBot(t)
+	game := string(shift.DefaultGame)
+	if err := bot.storage.AddCode("KBW3B-R9HRT-36W5K-X35J3-5R63W", game, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := bot.addCodes([]string{
+		"new codes! J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW and kbw3b - r9hrt-36w5k-x35j3-5r63w",
+		"T9RJB-3HRB6-3XSZ3-HB3BT-3K6TC",
+		"t9rjb-3hrb6-3xsz3-hb3bt-3k6tc",
+		"nothing to see here",
+		"JOOOO-BBBBB-CCCCC-DDDDD-EEEEE",
+	}, game, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []AddResult{
+		{Code: "J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW", Status: CodeAdded},
+		{Code: "KBW3B-R9HRT-36W5K-X35J3-5R63W", Status: CodeDuplicate},
+		{Code: "T9RJB-3HRB6-3XSZ3-HB3BT-3K6TC", Status: CodeAdded},
+		{Code: "T9RJB-3HRB6-3XSZ3-HB3BT-3K6TC", Status: CodeDuplicate},
+		{Code: "nothing to see here", Status: CodeInvalid},
+		{Code: "JOOOO-BBBBB-CCCCC-DDDDD-EEEEE", Status: CodeInvalid},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d: %v", len(expected), len(results), results)
+	}
+	for i, result := range results {
+		if result.Code != expected[i].Code || result.Status != expected[i].Status {
+			t.Errorf("result %d: expected %s %s, got %s %s", i, expected[i].Code, expected[i].Status, result.Code, result.Status)
+		}
+		if result.Status == CodeInvalid && result.Reason == "" {
+			t.Errorf("result %d: expected a reason for the invalid code", i)
+		}
+	}
+	if !bot.storage.CodeExists("J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW") || !bot.storage.CodeExists("T9RJB-3HRB6-3XSZ3-HB3BT-3K6TC") {
+		t.Error("expected the added codes to be stored")
+	}
+
+	// the whole batch triggers redemption processing once
+	if received := bot.triggers.Stats().Received; received != 1 {
+		t.Errorf("expected redemption processing to be triggered once, got %d", received)
+	}
+}
+
+func TestAddCodes_NoneAdded(t *testing.T) {
+	bot := newTestBot(t)
+
+	results, err := bot.addCodes([]string{"no codes here"}, string(shift.DefaultGame), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != CodeInvalid {
+		t.Errorf("expected one invalid result, got %v", results)
+	}
+	if bot.triggers.Stats().Received != 0 {
+		t.Error("expected redemption processing not to be triggered when no codes were added")
+	}
+}
+
+func TestAddResultsText_Truncated(t *testing.T) {
+	var results []AddResult
+	for range 200 {
+		results = append(results, AddResult{Code: "J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW", Status: CodeAdded})
+	}
+
+	text := addResultsText(results)
+	if len(text) > discordMessageLimit {
+		t.Errorf("expected at most %d characters, got %d", discordMessageLimit, len(text))
+	}
+	if !strings.Contains(text, "more") {
+		t.Error("expected the text to say how many results were left out")
+	}
+}
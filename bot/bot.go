@@ -3,8 +3,12 @@ package bot
 import (
 	"log"
 	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/denverquane/slickshift/shift"
 	"github.com/denverquane/slickshift/store"
 
 	"github.com/bwmarrin/discordgo"
@@ -14,6 +18,8 @@ const (
 	PrivateResponse   = discordgo.MessageFlagsEphemeral
 	SetPlatformPrefix = "set_platform_"
 	SetDMPrefix       = "set_dm_value"
+	SetGamesPrefix    = "set_games"
+	SetCanaryPrefix   = "set_canary"
 	LogoutPrefix      = "logout_"
 	GithubLink        = "https://github.com/denverquane/slickshift"
 	SecurityLink      = GithubLink + "/blob/main/SECURITY.md"
@@ -27,11 +33,24 @@ const (
 )
 
 type Bot struct {
-	session           *discordgo.Session
-	storage           store.Store
-	redemptionTrigger chan string
-	version           string
-	commit            string
+	session      *discordgo.Session
+	storage      store.Store
+	triggers     *Coalescer
+	shiftOptions []shift.Option
+	shiftLimiter *shift.Limiter
+	breaker      *Breaker
+	// workerID identifies this process on the redemption jobs it claims, so processes sharing a database can tell
+	// whose jobs are whose
+	workerID string
+	// how many users the daemon processes at once, and the locks that keep each account to one at a time
+	workers  int
+	accounts accountLocks
+	// sendDM replaces sending DMs through Discord, so tests can see what would be sent
+	sendDM func(userID, content string) error
+	// the daemon doesn't redeem codes until this time, after SHiFT rate limited it. Only used by the daemon goroutine
+	pausedUntil time.Time
+	version     string
+	commit      string
 }
 
 func CreateNewBot(token string, storage store.Store, version, commit string) (*Bot, error) {
@@ -41,14 +60,48 @@ func CreateNewBot(token string, storage store.Store, version, commit string) (*B
 	}
 
 	return &Bot{
-		session:           discord,
-		storage:           storage,
-		redemptionTrigger: make(chan string, 10),
-		version:           version,
-		commit:            commit,
+		session:      discord,
+		storage:      storage,
+		triggers:     NewCoalescer(DefaultTriggerQuiet),
+		shiftLimiter: shift.NewLimiter(shift.DefaultGlobalRate, shift.DefaultAccountRate),
+		breaker:      NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		workerID:     newWorkerID(),
+		workers:      DefaultWorkers,
+		version:      version,
+		commit:       commit,
 	}, nil
 }
 
+// SetShiftOptions configures every SHiFT client the bot creates
+func (bot *Bot) SetShiftOptions(opts ...shift.Option) {
+	bot.shiftOptions = opts
+}
+
+// SetShiftLimiter shares a rate limiter between every SHiFT client the bot creates, so requests are budgeted globally
+// and per user
+func (bot *Bot) SetShiftLimiter(limiter *shift.Limiter) {
+	bot.shiftLimiter = limiter
+}
+
+// SetBreaker replaces the circuit breaker the daemon uses to stop redeeming codes while SHiFT is down
+func (bot *Bot) SetBreaker(breaker *Breaker) {
+	bot.breaker = breaker
+}
+
+// SetWorkers sets how many users the daemon processes at once. Every account is still processed one at a time
+func (bot *Bot) SetWorkers(workers int) {
+	bot.workers = max(workers, 1)
+}
+
+// newShiftClient creates a SHiFT client that spends its requests from the user's rate limit budget
+func (bot *Bot) newShiftClient(userID string, cookies []*http.Cookie) (*shift.Client, error) {
+	opts := bot.shiftOptions
+	if bot.shiftLimiter != nil {
+		opts = append(slices.Clip(opts), shift.WithLimiter(bot.shiftLimiter, userID))
+	}
+	return shift.NewClient(cookies, opts...)
+}
+
 func (bot *Bot) Start() error {
 	bot.session.AddHandler(bot.handleSlashCommand)
 
@@ -112,6 +165,8 @@ func (bot *Bot) getSlashResponse(userID string, s *discordgo.Session, i *discord
 			return bot.infoResponse(userID, s, i)
 		case REDEMPTIONS:
 			return bot.redemptionsResponse(userID, s, i)
+		case KEYS:
+			return bot.keysResponse(userID, s, i)
 		}
 	} else if i.Type == discordgo.InteractionMessageComponent {
 		exists := bot.storage.UserExists(userID)
@@ -122,26 +177,71 @@ func (bot *Bot) getSlashResponse(userID string, s *discordgo.Session, i *discord
 				return nil
 			}
 		}
-		oldPlatform, _, err := bot.storage.GetUserPlatformAndDM(userID)
+		oldPlatforms, _, err := bot.storage.GetUserPlatformsAndDM(userID)
 		if err != nil {
 			log.Println(err)
 			return nil
 		}
 		id := i.MessageComponentData().CustomID
 		if strings.HasPrefix(id, SetPlatformPrefix) {
-			platform := strings.TrimPrefix(id, SetPlatformPrefix)
+			values := i.MessageComponentData().Values
+			// messages sent before platforms were a multi-select have one button per platform
+			if len(values) == 0 && id != SetPlatformPrefix {
+				values = []string{strings.TrimPrefix(id, SetPlatformPrefix)}
+			}
+			var platforms, pretty []string
+			for _, p := range values {
+				if shift.ValidPlatform(p) {
+					platforms = append(platforms, p)
+					pretty = append(pretty, shift.ToPretty(shift.Platform(p)))
+				}
+			}
+			if len(platforms) == 0 {
+				return privateMessageResponse("Hm, I couldn't process that. If you're trying to set your platforms, try with `/" + SETTINGS + "`")
+			}
 
-			err := bot.storage.SetUserPlatform(userID, platform)
+			err := bot.storage.SetUserPlatforms(userID, platforms)
 			if err != nil {
 				log.Println(err)
 				return nil
 			}
 			// if they set the platform for the first time, or are a new user, then send a different response
-			if !exists || oldPlatform == "" {
+			if !exists || len(oldPlatforms) == 0 {
 				return registeredUserResponse()
 			}
-			return privateMessageResponse("Got it!\nSet the platform for future redemptions to: `" + strings.Title(platform) + "`")
+			return privateMessageResponse("Got it!\nSet the platforms for future redemptions to: `" + strings.Join(pretty, "`, `") + "`")
 
+		} else if strings.HasPrefix(id, SetGamesPrefix) {
+			var games []string
+			for _, g := range i.MessageComponentData().Values {
+				if shift.ValidGame(g) {
+					games = append(games, g)
+				}
+			}
+			if len(games) == 0 {
+				return privateMessageResponse("Hm, I couldn't process that. If you're trying to pick your games, try with `/" + SETTINGS + "`")
+			}
+			err = bot.storage.SetUserGames(userID, games)
+			if err != nil {
+				log.Println(err)
+				return privateMessageResponse("Hm, I got an error trying to set your games. Please try again later.")
+			}
+			return &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredMessageUpdate,
+			}
+		} else if strings.HasPrefix(id, SetCanaryPrefix) {
+			if len(i.MessageComponentData().Values) == 0 {
+				return privateMessageResponse("Hm, I couldn't process that. If you're trying to try new codes first, try with `/" + SETTINGS + "`")
+			}
+			canary := i.MessageComponentData().Values[0] == "true"
+			err = bot.storage.SetUserCanary(userID, canary)
+			if err != nil {
+				log.Println(err)
+				return privateMessageResponse("Hm, I got an error trying to set your preference. Please try again later.")
+			}
+			return &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredMessageUpdate,
+			}
 		} else if strings.HasPrefix(id, SetDMPrefix) {
 			if len(i.MessageComponentData().Values) == 0 {
 				return privateMessageResponse("Hm, I couldn't process that. If you're trying to set the DM preference, try with `/" + SETTINGS + "`")
@@ -207,11 +307,11 @@ func privateMessageResponse(content string) *discordgo.InteractionResponse {
 
 func unregisteredUserResponse() *discordgo.InteractionResponse {
 	msg := privateMessageResponse("Looks like this is your first time using SlickShift! Welcome!\n\n" +
-		"I'm here to help you automatically redeem SHiFT codes for Borderlands 4!\n\n*To get started:*\n" +
+		"I'm here to help you automatically redeem SHiFT codes for Borderlands games!\n\n*To get started:*\n" +
 		settingsSuffix)
 	msg.Data.Components = []discordgo.MessageComponent{
 		getDMComponents(false, false),
-		getPlatformComponents(false, ""),
+		getPlatformComponents(nil),
 	}
 	return msg
 }
@@ -227,6 +327,9 @@ func registeredUserResponse() *discordgo.InteractionResponse {
 }
 
 func (bot *Bot) DMUser(userID, content string) error {
+	if bot.sendDM != nil {
+		return bot.sendDM(userID, content)
+	}
 	channel, err := bot.session.UserChannelCreate(userID)
 	if err != nil {
 		return err
@@ -257,9 +360,10 @@ func (bot *Bot) DeleteCommands(guildID string, cmds []*discordgo.ApplicationComm
 	}
 }
 
-// trigger redemption processing for whatever userID was provided. If empty, triggers for all users
+// trigger redemption processing for whatever userID was provided. If empty, triggers for all users. Never blocks;
+// triggers are coalesced until the daemon gets to them
 func (bot *Bot) triggerRedemptionProcessing(userID string) {
-	bot.redemptionTrigger <- userID
+	bot.triggers.Trigger(userID)
 }
 
 func (bot *Bot) Stop() error {
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	// BreakerClosed is normal operation: SHiFT is up, and codes are redeemed
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen means SHiFT looks down, so redemptions are skipped until the cooldown passes
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen means the cooldown passed, and a probe request is checking whether SHiFT is back
+	BreakerHalfOpen BreakerState = "half-open"
+
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 5 * time.Minute
+)
+
+// BreakerStatus is a snapshot of the breaker, for reporting
+type BreakerStatus struct {
+	State     BreakerState `json:"state"`
+	Failures  int          `json:"failures"`
+	OpenedAt  *time.Time   `json:"opened_at,omitempty"`
+	LastError string       `json:"last_error,omitempty"`
+}
+
+// Breaker is a circuit breaker around requests to SHiFT. It opens after a number of consecutive failures that are
+// SHiFT's fault rather than the account's, so an outage doesn't get blamed on every user. It is safe for concurrent use
+type Breaker struct {
+	lock      sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	lastError string
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: max(threshold, 1),
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Allow reports whether requests to SHiFT should be made. Once the cooldown has passed, it moves an open breaker to
+// half-open and returns true, so the caller can make a single probe request and report how it went
+func (b *Breaker) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// a probe is already in flight
+		return false
+	}
+	return true
+}
+
+// Success records a request that reached SHiFT and got a sensible response, and closes the breaker
+func (b *Breaker) Success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.lastError = ""
+}
+
+// Cancel records a probe that was given up on before SHiFT answered, like when the bot is shutting down. That says
+// nothing about whether SHiFT is back, so the breaker goes back to open, and the next Allow makes another probe
+func (b *Breaker) Cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+// Failure records a request that failed because of SHiFT, and returns true if the breaker is now open. A failed probe
+// reopens the breaker straight away
+func (b *Breaker) Failure(err error) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+	return b.state == BreakerOpen
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	status := BreakerStatus{
+		State:     b.state,
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	breaker := NewBreaker(3, time.Hour)
+	err := errors.New("503")
+
+	for i := 0; i < 2; i++ {
+		if breaker.Failure(err) {
+			t.Fatal("Breaker shouldn't open before the threshold")
+		}
+	}
+	if !breaker.Allow() {
+		t.Fatal("Closed breaker should allow requests")
+	}
+	if !breaker.Failure(err) {
+		t.Fatal("Breaker should open at the threshold")
+	}
+	if breaker.Allow() {
+		t.Fatal("Open breaker shouldn't allow requests during the cooldown")
+	}
+	status := breaker.Status()
+	if status.State != BreakerOpen || status.Failures != 3 || status.LastError != "503" || status.OpenedAt == nil {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+}
+
+func TestBreaker_SuccessResetsFailures(t *testing.T) {
+	breaker := NewBreaker(2, time.Hour)
+	err := errors.New("timeout")
+
+	breaker.Failure(err)
+	breaker.Success()
+	if breaker.Failure(err) {
+		t.Fatal("Failures should only count when they're consecutive")
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	breaker := NewBreaker(1, 0)
+	err := errors.New("503")
+
+	breaker.Failure(err)
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow a probe after the cooldown")
+	}
+	if breaker.Status().State != BreakerHalfOpen {
+		t.Fatal("Expected half-open, got ", breaker.Status().State)
+	}
+	if breaker.Allow() {
+		t.Fatal("Only one probe should be allowed at a time")
+	}
+
+	// a failed probe reopens the breaker
+	if !breaker.Failure(err) {
+		t.Fatal("Failed probe should reopen the breaker")
+	}
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow another probe after the cooldown")
+	}
+	breaker.Success()
+	if status := breaker.Status(); status.State != BreakerClosed || status.Failures != 0 || status.OpenedAt != nil {
+		t.Fatalf("Successful probe should close the breaker, got %+v", status)
+	}
+}
+
+// a probe that was cancelled doesn't use up the probe slot
+func TestBreaker_CancelledProbe(t *testing.T) {
+	breaker := NewBreaker(1, 0)
+	breaker.Failure(errors.New("503"))
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow a probe after the cooldown")
+	}
+	breaker.Cancel()
+	if status := breaker.Status(); status.State != BreakerOpen || status.Failures != 1 {
+		t.Fatalf("Cancelled probe should leave the breaker open, got %+v", status)
+	}
+	if !breaker.Allow() {
+		t.Fatal("Breaker should allow another probe after a cancelled one")
+	}
+}
@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
+)
+
+const (
+	// CanaryAccounts is how many canary accounts a new code is tried on before giving up for the run
+	CanaryAccounts = 2
+	// CanaryTimeout is how long a new code waits on canary accounts that can't tell whether it's valid, before it's
+	// redeemed for everyone anyway
+	CanaryTimeout = time.Hour
+)
+
+// validateCodes tries each code that was added since the last run on canary accounts, so a typo is caught before it's
+// tried for everyone. Codes that are redeemed, or were already, are released to everyone; codes that are expired or
+// don't exist are marked invalid once canaries have found them that way as many times as it takes to stop redeeming a
+// code on a platform, or once CanaryTimeout passes without that. Returns how many codes were released to everyone, and stops early with shift.ErrRateLimited or
+// errBreakerOpen
+func (bot *Bot) validateCodes(ctx context.Context) (int, error) {
+	codes, err := bot.storage.GetPendingCodes()
+	if err != nil {
+		slog.Error("Error getting codes pending validation", "error", err.Error())
+		return 0, nil
+	}
+	if len(codes) == 0 {
+		return 0, nil
+	}
+	canaries, err := bot.storage.GetCanaryUsers()
+	if err != nil {
+		slog.Error("Error getting canary users", "error", err.Error())
+		return 0, nil
+	}
+
+	released := 0
+	for _, code := range codes {
+		if ctx.Err() != nil {
+			return released, nil
+		}
+		validation := store.ValidationValid
+		if len(canaries) == 0 {
+			slog.Info("No canary accounts to validate code on, releasing it to everyone", "code", code.Code)
+		} else {
+			validation, err = bot.validateCode(ctx, code, canaries)
+			if err != nil {
+				return released, err
+			}
+		}
+		if validation == store.ValidationPending && time.Since(time.Unix(code.CreatedUnix, 0)) > CanaryTimeout {
+			validation = bot.canaryTimedOut(code.Code)
+		}
+		if validation == store.ValidationPending {
+			continue
+		}
+
+		err = bot.storage.SetCodeValidation(code.Code, validation)
+		if err != nil {
+			slog.Error("Error setting code validation", "code", code.Code, "validation", validation, "error", err.Error())
+			continue
+		}
+		slog.Info("Validated code", "code", code.Code, "validation", validation)
+		if validation == store.ValidationValid {
+			released++
+		}
+		if code.UserID.Valid {
+			bot.dmValidated(code.UserID.String, code.Code, validation)
+		}
+	}
+	return released, nil
+}
+
+// canaryTimedOut decides a code canary accounts couldn't validate in time. It's released to everyone, unless a canary
+// found it expired or nonexistent without enough results to be sure, since nothing said it works
+func (bot *Bot) canaryTimedOut(code string) store.CodeValidation {
+	failed, err := bot.storage.CodeFailed(code)
+	if err != nil {
+		slog.Error("Error checking code failures", "code", code, "error", err.Error())
+		return store.ValidationPending
+	}
+	if failed {
+		slog.Warn("Canary accounts found code expired or nonexistent and couldn't confirm it in time, marking it invalid", "code", code)
+		return store.ValidationInvalid
+	}
+	slog.Warn("Canary accounts couldn't validate code in time, releasing it to everyone", "code", code)
+	return store.ValidationValid
+}
+
+// validateCode tries a code on up to CanaryAccounts of the canaries that haven't tried it yet, and returns what it
+// showed about the code. It's still pending if none of them could tell
+func (bot *Bot) validateCode(ctx context.Context, code store.PendingCode, canaries []string) (store.CodeValidation, error) {
+	tried := 0
+	for _, userID := range canaries {
+		if tried >= CanaryAccounts || ctx.Err() != nil {
+			break
+		}
+		platforms, dm, err := bot.storage.GetUserPlatformsAndDM(userID)
+		if err != nil {
+			continue
+		}
+		// a canary's result for the code was already counted, so only other canaries can add to it
+		platforms = slices.DeleteFunc(platforms, func(platform string) bool {
+			return bot.storage.RedemptionExists(userID, code.Code, platform)
+		})
+		if len(platforms) == 0 {
+			continue
+		}
+		session, err := bot.storage.GetUserSession(userID)
+		if err != nil || session.State == shift.SessionInvalid {
+			continue
+		}
+		backoff, err := bot.storage.GetUserBackoff(userID)
+		if err != nil || time.Now().Unix() < backoff.NextAttemptUnix {
+			continue
+		}
+		cookies, err := bot.storage.GetDecryptedUserCookies(userID)
+		if err != nil {
+			slog.Error("Failed to get cookies for canary", "user_id", userID, "error", err.Error())
+			continue
+		}
+		tried++
+
+		validation, err := bot.tryCanary(ctx, store.UserCookies{UserID: userID, Cookies: cookies}, code, platforms, dm)
+		if err != nil || validation != store.ValidationPending {
+			return validation, err
+		}
+	}
+	return store.ValidationPending, nil
+}
+
+// tryCanary redeems a code for a canary on each of their platforms, until SHiFT says something about whether the code
+// is valid
+func (bot *Bot) tryCanary(ctx context.Context, user store.UserCookies, code store.PendingCode, platforms []string, dm bool) (store.CodeValidation, error) {
+	unlock := bot.accounts.Lock(user.UserID)
+	defer unlock()
+
+	client, err := bot.newShiftClient(user.UserID, user.Cookies)
+	if err != nil {
+		slog.Error("Error creating shift client", "user_id", user.UserID, "error", err.Error())
+		return store.ValidationPending, nil
+	}
+	defer bot.saveRotatedCookies(user, client)
+
+	for _, platform := range platforms {
+		reward, result, err := bot.redeemCode(ctx, client, user, code.Code, shift.Game(code.Game), shift.Platform(platform))
+		if err != nil && ctx.Err() != nil {
+			return store.ValidationPending, nil
+		} else if errors.Is(err, shift.ErrRateLimited) {
+			return store.ValidationPending, err
+		} else if shift.IsUpstream(err) {
+			slog.Warn("SHiFT failed while validating code", "user_id", user.UserID, "code", code.Code, "platform", platform, "error", err.Error())
+			if bot.breaker.Failure(err) {
+				return store.ValidationPending, errBreakerOpen
+			}
+			return store.ValidationPending, nil
+		} else if err != nil {
+			slog.Error("Error validating code", "user_id", user.UserID, "code", code.Code, "platform", platform, "error", err.Error())
+			return store.ValidationPending, nil
+		}
+		bot.breaker.Success()
+
+		switch result.Type {
+		case shift.Success:
+			if reward != nil {
+				if _, err = bot.storage.SetCodeRewardAndSuccess(code.Code, reward.Title, true); err != nil {
+					slog.Error("Error setting code reward", "code", code.Code, "reward", reward.Title, "error", err.Error())
+				}
+			}
+			if dm {
+				bot.dmRedeemed(user.UserID, code.Code, shift.Platform(platform), reward)
+			}
+			return store.ValidationValid, nil
+		case shift.AlreadyRedeemed:
+			return store.ValidationValid, nil
+		case shift.Expired, shift.Invalid:
+			// a single result could be a fluke, so it takes as many as it would to stop redeeming the code on the
+			// platform. Until then, the code stays pending for the next canary to try
+			invalid, err := bot.storage.CodeInvalidOnPlatform(code.Code, platform)
+			if err != nil {
+				slog.Error("Error checking code validity", "code", code.Code, "platform", platform, "error", err.Error())
+				return store.ValidationPending, nil
+			}
+			if invalid {
+				return store.ValidationInvalid, nil
+			}
+		}
+		// anything else, like the code not being offered on this platform, doesn't say whether it's valid
+	}
+	return store.ValidationPending, nil
+}
+
+// dmValidated tells the user who added a code whether it turned out to be valid
+func (bot *Bot) dmValidated(userID, code string, validation store.CodeValidation) {
+	str := ThumbsUp + " The code `" + code + "` you added works! I'll start redeeming it for everyone now."
+	if validation == store.ValidationInvalid {
+		str = X + " The code `" + code + "` you added is expired or doesn't exist, so I won't be redeeming it. " +
+			"Double check it for typos, and feel free to add it again with `/" + ADD + "` if there was one!"
+	}
+	err := bot.DMUser(userID, str)
+	if err != nil {
+		slog.Error("Failed to DM user about code validation", "user_id", userID, "code", code, "error", err.Error())
+	} else {
+		slog.Info("DMed user about code validation", "user_id", userID, "code", code, "validation", validation)
+	}
+}
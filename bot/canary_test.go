@@ -0,0 +1,294 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/shift/shifttest"
+	"github.com/denverquane/slickshift/store"
+)
+
+const (
+	adderID        = "100"
+	canaryPassword = "hunter2"
+)
+
+// canaries are the canary accounts newCanaryTest creates, by user ID and email
+var canaries = []struct{ userID, email string }{
+	{"101", "first@example.com"},
+	{"102", "second@example.com"},
+}
+
+// newCanaryTest creates a bot with two canary accounts on a fake SHiFT server, and a pending code added by another user.
+// It returns the DMs the bot sends
+func newCanaryTest(t *testing.T, code string, outcome shifttest.Outcome) (*Bot, *shifttest.Server, *[]sentDM) {
+	server := shifttest.NewServer()
+	t.Cleanup(server.Close)
+	server.SetCode(code, shifttest.Code{Outcome: outcome, Game: shift.Borderlands4})
+
+	bot := newTestBot(t)
+	bot.breaker = NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	bot.SetShiftOptions(shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	dms := recordDMs(bot)
+
+	for _, canary := range canaries {
+		server.AddAccount(canary.email, canaryPassword)
+		bot.storage.AddUser(canary.userID)
+		bot.storage.SetUserPlatforms(canary.userID, []string{string(shift.Steam)})
+		bot.storage.SetUserDM(canary.userID, true)
+		bot.storage.SetUserCanary(canary.userID, true)
+		bot.storage.EncryptAndSetUserCookies(canary.userID, server.Cookies(canary.email))
+	}
+	bot.storage.AddUser(adderID)
+	bot.storage.SetUserPlatforms(adderID, []string{string(shift.Steam)})
+	adder := adderID
+	if _, err := bot.storage.AddCodeForValidation(code, string(shift.Borderlands4), &adder, nil); err != nil {
+		t.Fatal(err)
+	}
+	return bot, server, dms
+}
+
+// releasedTo returns whether the code is redeemed for the user, rather than only for canaries
+func releasedTo(t *testing.T, bot *Bot, userID, code string) bool {
+	codes, err := bot.storage.GetValidCodesNotRedeemedForUser(userID, string(shift.Steam), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return slices.ContainsFunc(codes, func(c store.ShiftCode) bool { return c.Code == code })
+}
+
+// pendingValidation returns whether the code is still waiting for a canary account to validate it
+func pendingValidation(t *testing.T, bot *Bot, code string) bool {
+	pending, err := bot.storage.GetPendingCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return slices.ContainsFunc(pending, func(c store.PendingCode) bool { return c.Code == code })
+}
+
+func TestValidateCodes_NoCanaries(t *testing.T) {
+	bot := newTestBot(t)
+	const code = "AAAAA-AAAAA-AAAAA-AAAAA-AAAAA"
+	if _, err := bot.storage.AddCodeForValidation(code, string(shift.Borderlands4), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 {
+		t.Fatal("Expected the code to be released to everyone without any canaries, got ", released)
+	}
+	pending, err := bot.storage.GetPendingCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatal("Expected no pending codes, got ", len(pending))
+	}
+}
+
+func TestValidateCodes_UnusableCanary(t *testing.T) {
+	bot := newTestBot(t)
+	const userID = "123"
+	const code = "AAAAA-AAAAA-AAAAA-AAAAA-AAAAA"
+	bot.storage.AddUser(userID)
+	bot.storage.EncryptAndSetUserCookies(userID, []*http.Cookie{{Name: "a", Value: "b"}})
+	bot.storage.SetUserCanary(userID, true)
+	if _, err := bot.storage.AddCodeForValidation(code, string(shift.Borderlands4), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a canary without any platforms can't try the code, so it waits for one that can
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 0 {
+		t.Fatal("Expected the code not to be released yet, got ", released)
+	}
+	pending, err := bot.storage.GetPendingCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Code != code {
+		t.Fatal("Expected the code to still be pending, got ", pending)
+	}
+	validation, err := bot.validateCode(context.Background(), pending[0], []string{userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validation != store.ValidationPending {
+		t.Fatal("Expected the code to still be pending, got ", validation)
+	}
+}
+
+func TestValidateCodes_Success(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, server, dms := newCanaryTest(t, code, shifttest.Success)
+	canary := canaries[0]
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 || pendingValidation(t, bot, code) || !releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be released to everyone, got ", released)
+	}
+	// one canary is enough to show the code works
+	if rewards := server.Rewards(canary.email, shift.Steam); len(rewards) != 1 {
+		t.Fatal("Expected the code to be redeemed for the first canary, got ", rewards)
+	}
+	if rewards := server.Rewards(canaries[1].email, shift.Steam); len(rewards) != 0 {
+		t.Fatal("Expected the code not to be redeemed for the second canary, got ", rewards)
+	}
+	redemptions, err := bot.storage.GetRecentRedemptionsForUser(canary.userID, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 1 || redemptions[0].Reward.String != shift.GoldenKey {
+		t.Fatalf("Expected the code's reward to be stored, got %+v", redemptions)
+	}
+
+	// the canary hears about the redemption, and the adder hears the code works
+	if len(*dms) != 2 {
+		t.Fatalf("Expected 2 DMs, got %+v", *dms)
+	}
+	if dm := (*dms)[0]; dm.userID != canary.userID || !strings.Contains(dm.content, "successfully redeemed `"+code+"`") {
+		t.Fatalf("Unexpected DM to the canary %+v", dm)
+	}
+	if dm := (*dms)[1]; dm.userID != adderID || !strings.Contains(dm.content, "`"+code+"` you added works!") {
+		t.Fatalf("Unexpected DM to the adder %+v", dm)
+	}
+}
+
+func TestValidateCodes_AlreadyRedeemed(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, _, dms := newCanaryTest(t, code, shifttest.AlreadyRedeemed)
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 || pendingValidation(t, bot, code) || !releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be released to everyone, got ", released)
+	}
+	if len(*dms) != 1 || (*dms)[0].userID != adderID || !strings.Contains((*dms)[0].content, "`"+code+"` you added works!") {
+		t.Fatalf("Expected only the adder to be DMed, got %+v", *dms)
+	}
+}
+
+// one canary finding a code expired could be a fluke, so it takes as many results as it would to stop redeeming the
+// code on the platform
+func TestValidateCodes_Expired(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, _, dms := newCanaryTest(t, code, shifttest.Expired)
+	bot.storage.SetUserCanary(canaries[1].userID, false)
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 0 || !pendingValidation(t, bot, code) || len(*dms) != 0 {
+		t.Fatal("Expected the code to still be pending after one expired result")
+	}
+	// the first canary's result was already counted, so trying it again doesn't change anything
+	_, err = bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pendingValidation(t, bot, code) || len(*dms) != 0 {
+		t.Fatal("Expected the same canary not to count twice")
+	}
+
+	bot.storage.SetUserCanary(canaries[1].userID, true)
+	released, err = bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 0 || pendingValidation(t, bot, code) || releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be invalid")
+	}
+	if len(*dms) != 1 || (*dms)[0].userID != adderID || !strings.Contains((*dms)[0].content, "`"+code+"` you added is expired or doesn't exist") {
+		t.Fatalf("Expected the adder to be DMed about the invalid code, got %+v", *dms)
+	}
+}
+
+// agedCodes makes the codes pending validation look like they were added a while ago
+type agedCodes struct {
+	store.Store
+	age time.Duration
+}
+
+func (s agedCodes) GetPendingCodes() ([]store.PendingCode, error) {
+	codes, err := s.Store.GetPendingCodes()
+	for i := range codes {
+		codes[i].CreatedUnix -= int64(s.age.Seconds())
+	}
+	return codes, err
+}
+
+// a code that only one canary could try isn't released to everyone after the timeout if that canary found it expired
+func TestValidateCodes_ExpiredTimeout(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, _, dms := newCanaryTest(t, code, shifttest.Expired)
+	bot.storage.SetUserCanary(canaries[1].userID, false)
+	bot.storage = agedCodes{bot.storage, CanaryTimeout + time.Minute}
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 0 || pendingValidation(t, bot, code) || releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be invalid")
+	}
+	if len(*dms) != 1 || (*dms)[0].userID != adderID || !strings.Contains((*dms)[0].content, "`"+code+"` you added is expired or doesn't exist") {
+		t.Fatalf("Expected the adder to be DMed about the invalid code, got %+v", *dms)
+	}
+}
+
+// without any canary results, a code is released to everyone after the timeout
+func TestValidateCodes_Timeout(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, _, dms := newCanaryTest(t, code, shifttest.Success)
+	for _, canary := range canaries {
+		bot.storage.SetUserCanary(canary.userID, false)
+	}
+	// the adder has no SHiFT session, so the only canary can't try the code
+	bot.storage.SetUserCanary(adderID, true)
+	bot.storage = agedCodes{bot.storage, CanaryTimeout + time.Minute}
+
+	released, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 || !releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be released to everyone")
+	}
+	if len(*dms) != 1 || !strings.Contains((*dms)[0].content, "works") {
+		t.Fatalf("Expected the adder to be DMed that the code works, got %+v", *dms)
+	}
+}
+
+func TestValidateCodes_NotExist(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	bot, _, dms := newCanaryTest(t, code, shifttest.NotExist)
+
+	// both canaries try the code in the same run
+	_, err := bot.validateCodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pendingValidation(t, bot, code) || releasedTo(t, bot, adderID, code) {
+		t.Fatal("Expected the code to be invalid")
+	}
+	if len(*dms) != 1 || (*dms)[0].userID != adderID || !strings.Contains((*dms)[0].content, "doesn't exist") {
+		t.Fatalf("Expected the adder to be DMed about the invalid code, got %+v", *dms)
+	}
+}
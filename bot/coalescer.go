@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultTriggerQuiet is how long it has to be since the last trigger for every user before they're all processed, so
+// a burst of new codes only runs the loop once
+const DefaultTriggerQuiet = 10 * time.Second
+
+// TriggerStats counts what happened to redemption triggers, for reporting
+type TriggerStats struct {
+	// Received is every trigger, for a single user or for everyone
+	Received int64 `json:"received"`
+	// Merged is triggers folded into a run that was already pending
+	Merged int64 `json:"merged"`
+	// Dropped is triggers that were due, but skipped because processing was paused
+	Dropped int64 `json:"dropped"`
+	// Runs is how many times pending triggers were taken to be processed
+	Runs int64 `json:"runs"`
+}
+
+// Coalescer collects redemption triggers so callers never block on them. Triggers for single users are merged into a
+// set and are ready straight away. Triggers for everyone collapse into one pending run, which is ready once no more
+// have arrived for the quiet window. It is safe for concurrent use
+type Coalescer struct {
+	lock  sync.Mutex
+	quiet time.Duration
+	users map[string]struct{}
+	// all is whether a run for everyone is pending, and allDue whether its quiet window has passed
+	all    bool
+	allDue bool
+	timer  *time.Timer
+	ready  chan struct{}
+	stats  TriggerStats
+}
+
+func NewCoalescer(quiet time.Duration) *Coalescer {
+	return &Coalescer{
+		quiet: quiet,
+		users: map[string]struct{}{},
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Trigger asks for redemption processing for the user, or for every user if userID is empty. It never blocks
+func (c *Coalescer) Trigger(userID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.stats.Received++
+	if userID == "" {
+		if c.all {
+			c.stats.Merged++
+			slog.Info("Merged redemption trigger into the pending run for all users")
+		}
+		c.all = true
+		if c.allDue {
+			return
+		}
+		// every trigger for everyone restarts the quiet window
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.quiet, c.allReady)
+		} else {
+			c.timer.Reset(c.quiet)
+		}
+		return
+	}
+
+	if _, ok := c.users[userID]; ok || c.allDue {
+		c.stats.Merged++
+		slog.Info("Merged redemption trigger into a pending run", "user_id", userID)
+		if c.allDue {
+			return
+		}
+	}
+	c.users[userID] = struct{}{}
+	c.signal()
+}
+
+// allReady marks the pending run for everyone as due, once its quiet window has passed
+func (c *Coalescer) allReady() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.all {
+		c.allDue = true
+		c.signal()
+	}
+}
+
+// signal wakes up whoever is waiting on Ready, if they haven't been already. Must be called with the lock held
+func (c *Coalescer) signal() {
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready receives a value when there are triggers to Take
+func (c *Coalescer) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Take returns and clears the triggers that are due: whether every user should be processed, and otherwise which
+// users should be. A run for everyone that's still in its quiet window is left pending
+func (c *Coalescer) Take() (all bool, userIDs []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.allDue {
+		all = true
+		c.all, c.allDue = false, false
+		// everyone is about to be processed anyway
+		clear(c.users)
+	}
+	for userID := range c.users {
+		userIDs = append(userIDs, userID)
+	}
+	clear(c.users)
+	if all || len(userIDs) > 0 {
+		c.stats.Runs++
+	}
+	return all, userIDs
+}
+
+// Drop records that triggers that were due were skipped, rather than processed
+func (c *Coalescer) Drop(all bool, userIDs []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	count := int64(len(userIDs))
+	if all {
+		count++
+	}
+	c.stats.Dropped += count
+	slog.Info("Dropped redemption triggers while processing is paused", "all", all, "user_ids", userIDs)
+}
+
+// Cancel clears any run for everyone that's pending, because everyone was just processed
+func (c *Coalescer) Cancel() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.all && c.timer != nil {
+		c.timer.Stop()
+	}
+	c.all, c.allDue = false, false
+}
+
+func (c *Coalescer) Stats() TriggerStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.stats
+}
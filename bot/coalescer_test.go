@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func waitReady(t *testing.T, c *Coalescer, timeout time.Duration) bool {
+	t.Helper()
+	select {
+	case <-c.Ready():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestCoalescer_MergesUsers(t *testing.T) {
+	c := NewCoalescer(time.Hour)
+
+	c.Trigger("1")
+	c.Trigger("2")
+	c.Trigger("1")
+	if !waitReady(t, c, time.Second) {
+		t.Fatal("User triggers should be ready straight away")
+	}
+	all, userIDs := c.Take()
+	slices.Sort(userIDs)
+	if all || !slices.Equal(userIDs, []string{"1", "2"}) {
+		t.Fatalf("Expected users 1 and 2, got all=%v users=%v", all, userIDs)
+	}
+	stats := c.Stats()
+	if stats.Received != 3 || stats.Merged != 1 || stats.Runs != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCoalescer_DebouncesAll(t *testing.T) {
+	c := NewCoalescer(50 * time.Millisecond)
+
+	for range 5 {
+		c.Trigger("")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if all, _ := c.Take(); all {
+		t.Fatal("A run for everyone shouldn't be due during the quiet window")
+	}
+	if !waitReady(t, c, time.Second) {
+		t.Fatal("A run for everyone should be ready after the quiet window")
+	}
+	c.Trigger("1")
+	all, userIDs := c.Take()
+	if !all || len(userIDs) != 0 {
+		t.Fatalf("Expected one run for everyone, got all=%v users=%v", all, userIDs)
+	}
+	if all, _ := c.Take(); all {
+		t.Fatal("A run for everyone should only be taken once")
+	}
+	stats := c.Stats()
+	if stats.Received != 6 || stats.Merged != 5 || stats.Runs != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCoalescer_TriggerNeverBlocks(t *testing.T) {
+	c := NewCoalescer(time.Hour)
+
+	done := make(chan struct{})
+	go func() {
+		for range 1000 {
+			c.Trigger("1")
+			c.Trigger("")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Triggers shouldn't block when nothing is taking them")
+	}
+}
+
+func TestCoalescer_CancelAndDrop(t *testing.T) {
+	c := NewCoalescer(10 * time.Millisecond)
+
+	c.Trigger("")
+	c.Cancel()
+	if waitReady(t, c, 50*time.Millisecond) {
+		t.Fatal("A cancelled run for everyone shouldn't become ready")
+	}
+
+	c.Trigger("1")
+	c.Trigger("")
+	if !waitReady(t, c, time.Second) {
+		t.Fatal("Expected triggers to be ready")
+	}
+	time.Sleep(50 * time.Millisecond)
+	all, userIDs := c.Take()
+	c.Drop(all, userIDs)
+	if stats := c.Stats(); stats.Dropped != 1 {
+		t.Fatalf("Expected the run for everyone to be dropped, covering user 1, got %+v", stats)
+	}
+}
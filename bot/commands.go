@@ -14,6 +14,7 @@ const (
 	ADD            = "add"
 	INFO           = "info"
 	REDEMPTIONS    = "redemptions"
+	KEYS           = "keys"
 )
 
 var one = float64(1)
@@ -68,10 +69,21 @@ var AllCommands = []*discordgo.ApplicationCommand{
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
 				Name:        "code",
-				Description: "SHiFT code",
-				Required:    true,
-				MinLength:   &shift.CodeLength,
-				MaxLength:   shift.CodeLength,
+				Description: "SHiFT code, or text containing any number of them",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "game",
+				Description: "Game the SHiFT code is for. Defaults to " + string(shift.DefaultGame),
+				Required:    false,
+				Choices:     gameChoices(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "file",
+				Description: "Text file containing SHiFT codes",
+				Required:    false,
 			},
 		},
 	},
@@ -93,46 +105,87 @@ var AllCommands = []*discordgo.ApplicationCommand{
 			},
 		},
 	},
+	{
+		Name:        KEYS,
+		Description: "View how many Golden Keys SlickShift has earned you",
+	},
 }
 
-func getPlatformComponents(hasValue bool, value string) discordgo.ActionsRow {
+var platformLabels = map[shift.Platform]string{
+	shift.Steam:    "Steam",
+	shift.Epic:     "Epic",
+	shift.XboxLive: "Xbox",
+	shift.PSN:      "Playstation",
+}
+
+// the platforms a user wants codes redeemed on. If any are already set, at least one must stay selected
+func getPlatformComponents(platforms []string) discordgo.ActionsRow {
+	var minVal = 0
+	if len(platforms) > 0 {
+		minVal = 1
+	}
+	selected := map[string]bool{}
+	for _, p := range platforms {
+		selected[p] = true
+	}
+	options := make([]discordgo.SelectMenuOption, len(shift.Platforms))
+	for i, p := range shift.Platforms {
+		options[i] = discordgo.SelectMenuOption{
+			Label:   platformLabels[p],
+			Value:   string(p),
+			Default: selected[string(p)],
+		}
+	}
 	return discordgo.ActionsRow{
 		Components: []discordgo.MessageComponent{
-			discordgo.Button{
-				Label:    "Steam",
-				Style:    discordgo.PrimaryButton,
-				Disabled: hasValue && value == string(shift.Steam),
-				//Emoji: &discordgo.ComponentEmoji{
-				//	Name: ,
-				//},
-				CustomID: SetPlatformPrefix + string(shift.Steam),
-			},
-			discordgo.Button{
-				Label:    "Epic",
-				Style:    discordgo.PrimaryButton,
-				Disabled: hasValue && value == string(shift.Epic),
-				//Emoji: &discordgo.ComponentEmoji{
-				//	Name: ,
-				//},
-				CustomID: SetPlatformPrefix + string(shift.Epic),
-			},
-			discordgo.Button{
-				Label:    "Xbox",
-				Style:    discordgo.PrimaryButton,
-				Disabled: hasValue && value == string(shift.XboxLive),
-				//Emoji: &discordgo.ComponentEmoji{
-				//	Name: ,
-				//},
-				CustomID: SetPlatformPrefix + string(shift.XboxLive),
+			discordgo.SelectMenu{
+				CustomID:    SetPlatformPrefix,
+				Placeholder: "Choose platforms...",
+				MinValues:   &minVal,
+				MaxValues:   len(options),
+				Options:     options,
 			},
-			discordgo.Button{
-				Label:    "Playstation",
-				Style:    discordgo.PrimaryButton,
-				Disabled: hasValue && value == string(shift.PSN),
-				//Emoji: &discordgo.ComponentEmoji{
-				//	Name: ,
-				//},
-				CustomID: SetPlatformPrefix + string(shift.PSN),
+		},
+	}
+}
+
+func gameChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, len(shift.Games))
+	for i, g := range shift.Games {
+		choices[i] = &discordgo.ApplicationCommandOptionChoice{
+			Name:  string(g.Game),
+			Value: string(g.Game),
+		}
+	}
+	return choices
+}
+
+// the games a user wants codes redeemed for. If they haven't picked any, the default game is shown as selected
+func getGameComponents(games []string) discordgo.ActionsRow {
+	var minVal = 1
+	selected := map[string]bool{}
+	for _, g := range games {
+		selected[g] = true
+	}
+	if len(games) == 0 {
+		selected[string(shift.DefaultGame)] = true
+	}
+	options := make([]discordgo.SelectMenuOption, len(shift.Games))
+	for i, g := range shift.Games {
+		options[i] = discordgo.SelectMenuOption{
+			Label:   string(g.Game),
+			Value:   string(g.Game),
+			Default: selected[string(g.Game)],
+		}
+	}
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    SetGamesPrefix,
+				Placeholder: "Choose games...",
+				MinValues:   &minVal,
+				MaxValues:   len(options),
+				Options:     options,
 			},
 		},
 	}
@@ -175,3 +228,35 @@ func getDMComponents(hasValue, value bool) discordgo.ActionsRow {
 		},
 	}
 }
+
+func getCanaryComponents(value bool) discordgo.ActionsRow {
+	var minVal = 1
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    SetCanaryPrefix,
+				Placeholder: "Choose one...",
+				MinValues:   &minVal,
+				MaxValues:   1,
+				Options: []discordgo.SelectMenuOption{
+					{
+						Label: "No, only redeem codes that are known to work",
+						Value: "false",
+						Emoji: &discordgo.ComponentEmoji{
+							Name: "❌",
+						},
+						Default: !value,
+					},
+					{
+						Label: "Yes, try new codes on my account first",
+						Value: "true",
+						Emoji: &discordgo.ComponentEmoji{
+							Name: "🐤",
+						},
+						Default: value,
+					},
+				},
+			},
+		},
+	}
+}
@@ -1,57 +1,198 @@
 package bot
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log/slog"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/denverquane/slickshift/shift"
 	"github.com/denverquane/slickshift/store"
 )
 
-func (bot *Bot) StartUserRedemptionProcessing(interval time.Duration, stop <-chan bool) {
+// errBreakerOpen is returned when redemptions stop because too many requests in a row failed with SHiFT being down
+var errBreakerOpen = errors.New("circuit breaker opened")
+
+// errUserBackoff is returned when a user's redemptions start backing off, because too many in a row failed
+var errUserBackoff = errors.New("user backing off")
+
+const (
+	// RateLimitPause is how long the daemon stops redeeming codes after SHiFT rate limits it, unless SHiFT asks for longer
+	RateLimitPause = 5 * time.Minute
+	// SessionCheckInterval is how often every user's stored session is checked
+	SessionCheckInterval = 6 * time.Hour
+	// JobPollInterval is how often jobs that are due to be retried are looked for
+	JobPollInterval = time.Minute
+	// JobRetryBackoff is how long after its first failure a job is retried. It doubles with every failure after that
+	JobRetryBackoff = 5 * time.Minute
+	// MaxJobAttempts is how many times a job fails before it's parked, and only retried when it's requeued
+	MaxJobAttempts = 5
+	// UserFailureThreshold is how many redemptions in a row fail for a user before their redemptions back off
+	UserFailureThreshold = 5
+	// UserRetryBackoff is how long a user's redemptions back off after reaching the failure threshold. It doubles with
+	// every failure after that, up to MaxUserRetryBackoff
+	UserRetryBackoff    = time.Hour
+	MaxUserRetryBackoff = 7 * 24 * time.Hour
+	// JobLease is how long a claimed job belongs to the process that claimed it. Running jobs are only reset once their
+	// lease expires, so a job another process is still redeeming is never redeemed twice
+	JobLease = 30 * time.Minute
+	// JobLeaseMargin is how much of its lease a job needs left to be attempted, so the redemption finishes before the job
+	// can be reset
+	JobLeaseMargin = 5 * time.Minute
+	// jobsPerPlatform is how many jobs are claimed for each of a user's platforms at a time
+	jobsPerPlatform = 10
+)
+
+// newWorkerID returns an ID for this process that's different from any other process sharing the database, even one on
+// another host that happens to have the same pid
+func newWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return hostname + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(suffix)
+}
+
+// StartUserRedemptionProcessing redeems codes for users on an interval (or when triggered), until the context is
+// cancelled. In-flight redemptions are cancelled along with it
+func (bot *Bot) StartUserRedemptionProcessing(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
+	sessionTicker := time.NewTicker(SessionCheckInterval)
+	jobTicker := time.NewTicker(JobPollInterval)
+
+	bot.resetJobs()
+	bot.dueJobsLoop(ctx)
 
 	for {
 		select {
-		case <-stop:
+		case <-ctx.Done():
 			ticker.Stop()
+			sessionTicker.Stop()
+			jobTicker.Stop()
 			slog.Info("User code redemption processing stopped")
 			return
 
-			// TODO add debouncing so we don't constantly trigger reprocessing if multiple codes come through close together
-		case userID := <-bot.redemptionTrigger:
-			// if we aren't triggering the code redemption processing for a specific user, then reset the top
-			// control flow's interval so we don't run it back-to-back for all users
-			if userID == "" {
+		case <-sessionTicker.C:
+			if bot.paused() {
+				continue
+			}
+			slog.Info("Started user session checks")
+			bot.sessionCheckLoop(ctx)
+
+		case <-jobTicker.C:
+			bot.resetJobs()
+			if bot.paused() {
+				continue
+			}
+			bot.dueJobsLoop(ctx)
+
+		case <-bot.triggers.Ready():
+			all, userIDs := bot.triggers.Take()
+			if !all && len(userIDs) == 0 {
+				continue
+			}
+			if bot.paused() {
+				bot.triggers.Drop(all, userIDs)
+				continue
+			}
+			if all {
+				// reset the top control flow's interval so we don't run it back-to-back for all users
 				ticker.Reset(interval)
+				slog.Info("Started user code redemption processing from external trigger")
+				bot.userRedemptionLoop(ctx, nil)
+				continue
 			}
-			slog.Info("Started user code redemption processing from external trigger")
-			bot.userRedemptionLoop(userID)
+			slog.Info("Started user code redemption processing from external trigger", "user_ids", userIDs)
+			bot.userRedemptionLoop(ctx, userIDs)
 
 		case <-ticker.C:
+			if bot.paused() {
+				continue
+			}
 			slog.Info("Started user code redemption processing")
-			bot.userRedemptionLoop("")
+			// any pending trigger for all users is covered by this run
+			bot.triggers.Cancel()
+			bot.userRedemptionLoop(ctx, nil)
+		}
+	}
+}
+
+// paused reports whether redemption processing is paused because SHiFT rate limited us
+func (bot *Bot) paused() bool {
+	if time.Now().Before(bot.pausedUntil) {
+		slog.Info("Skipping user code redemption processing while rate limited", "until", bot.pausedUntil)
+		return true
+	}
+	return false
+}
+
+// pause stops redemption processing for a while after SHiFT rate limited us, since every other user's requests would
+// be throttled as well
+func (bot *Bot) pause(err error) {
+	wait := RateLimitPause
+	var rateLimited *shift.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		wait = max(wait, rateLimited.RetryAfter)
+	}
+	bot.pausedUntil = time.Now().Add(wait)
+	slog.Warn("Rate limited by SHiFT, pausing user code redemption processing", "until", bot.pausedUntil, "error", err.Error())
+}
+
+// shiftUp checks the circuit breaker before redeeming any codes. While the breaker is open it returns false, and once
+// the cooldown has passed it probes SHiFT to decide whether to resume
+func (bot *Bot) shiftUp(ctx context.Context) bool {
+	if !bot.breaker.Allow() {
+		slog.Info("Skipping user code redemption processing while SHiFT is down", "breaker", bot.breaker.Status())
+		return false
+	}
+	if bot.breaker.Status().State != BreakerHalfOpen {
+		return true
+	}
+
+	client, err := bot.newShiftClient("", nil)
+	if err == nil {
+		err = client.Ping(ctx)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			bot.breaker.Cancel()
+			return false
 		}
+		bot.breaker.Failure(err)
+		slog.Warn("SHiFT is still down, skipping user code redemption processing", "error", err.Error())
+		return false
 	}
+	bot.breaker.Success()
+	slog.Info("SHiFT is back up, resuming user code redemption processing")
+	return true
 }
 
-func (bot *Bot) userRedemptionLoop(userID string) {
+// userRedemptionLoop redeems codes for the given users, or for every user if userIDs is empty. They share one
+// validation pass, and are processed by the worker pool
+func (bot *Bot) userRedemptionLoop(ctx context.Context, userIDs []string) {
+	if !bot.shiftUp(ctx) {
+		return
+	}
+
 	var userCookies []store.UserCookies
 	var err error
-	// if a userID was provided, only get the cookies for that user
-	if userID != "" {
-		cookies, err := bot.storage.GetDecryptedUserCookies(userID)
-		if err != nil {
-			slog.Error("Failed to get cookies for user", "user_id", userID, "error", err.Error())
-			return
-		}
-		userCookies = []store.UserCookies{
-			store.UserCookies{
-				UserID:  userID,
-				Cookies: cookies,
-			},
+	// if users were provided, only get the cookies for them
+	if len(userIDs) > 0 {
+		for _, userID := range userIDs {
+			cookies, err := bot.storage.GetDecryptedUserCookies(userID)
+			if err != nil {
+				slog.Error("Failed to get cookies for user", "user_id", userID, "error", err.Error())
+				continue
+			}
+			userCookies = append(userCookies, store.UserCookies{UserID: userID, Cookies: cookies})
 		}
-		slog.Info("Retrieved decrypted user cookies for specific user", "user_id", userID)
+		slog.Info("Retrieved decrypted user cookies for specific users", "count", len(userCookies))
 	} else {
 		userCookies, err = bot.storage.GetAllDecryptedUserCookiesSorted(-1)
 		if err != nil {
@@ -61,128 +202,490 @@ func (bot *Bot) userRedemptionLoop(userID string) {
 		slog.Info("Retrieved decrypted user cookies", "count", len(userCookies))
 	}
 
-	for _, user := range userCookies {
-		platform, dm, err := bot.storage.GetUserPlatformAndDM(user.UserID)
+	// new codes are tried on canary accounts before anyone else's
+	released, err := bot.validateCodes(ctx)
+	if err == nil {
+		err = bot.forEachUser(ctx, userCookies, bot.redeemForUser)
+	}
+	if released > 0 && len(userIDs) > 0 {
+		// codes released to everyone on a run for some users still need redeeming for everyone else
+		bot.triggerRedemptionProcessing("")
+	}
+	if errors.Is(err, shift.ErrRateLimited) {
+		bot.pause(err)
+	} else if err != nil {
+		slog.Warn("SHiFT seems to be down, stopped user code redemption processing", "breaker", bot.breaker.Status())
+	} else if ctx.Err() != nil {
+		slog.Info("User code redemption processing cancelled")
+	}
+}
+
+// redeemForUser enqueues jobs for the codes to redeem on each of the user's platforms, and works through the ones that
+// are due. It only returns an error if processing should stop for
+// every user: shift.ErrRateLimited if SHiFT rate limits us, or errBreakerOpen if SHiFT keeps failing
+func (bot *Bot) redeemForUser(ctx context.Context, user store.UserCookies) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	platforms, dm, err := bot.storage.GetUserPlatformsAndDM(user.UserID)
+	if err != nil {
+		slog.Error("Error getting platforms", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if len(platforms) == 0 {
+		slog.Debug("Skipping user with no platform set", "user_id", user.UserID)
+		return nil
+	}
+	session, err := bot.storage.GetUserSession(user.UserID)
+	if err != nil {
+		slog.Error("Error getting session", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if session.State == shift.SessionInvalid {
+		// the user was already told when the session check found it invalid
+		slog.Debug("Skipping user with an invalid session", "user_id", user.UserID)
+		return nil
+	}
+	backoff, err := bot.storage.GetUserBackoff(user.UserID)
+	if err != nil {
+		slog.Error("Error getting backoff", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if time.Now().Unix() < backoff.NextAttemptUnix {
+		slog.Debug("Skipping user backing off after failures", "user_id", user.UserID, "failures", backoff.ConsecutiveFailures, "until", time.Unix(backoff.NextAttemptUnix, 0))
+		return nil
+	}
+
+	client, err := bot.newShiftClient(user.UserID, user.Cookies)
+	if err != nil {
+		slog.Error("Error creating shift client", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+
+	for _, platform := range platforms {
+		added, err := bot.storage.EnqueueRedemptionJobs(user.UserID, platform)
 		if err != nil {
-			slog.Error("Error getting platform", "user_id", user.UserID, "error", err.Error())
+			slog.Error("Error enqueueing redemption jobs", "user_id", user.UserID, "platform", platform, "error", err.Error())
+		} else if added > 0 {
+			slog.Debug("Enqueued redemption jobs", "user_id", user.UserID, "platform", platform, "jobs", added)
+		}
+	}
+	jobs, err := bot.storage.ClaimRedemptionJobs(bot.workerID, user.UserID, jobsPerPlatform*len(platforms), JobLease)
+	if err != nil {
+		slog.Error("Error claiming redemption jobs", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	slog.Debug("Claimed redemption jobs", "user_id", user.UserID, "jobs", len(jobs))
+
+	for _, job := range jobs {
+		if ctx.Err() != nil || err != nil {
+			// processing stopped, so the rest of the jobs aren't attempted
+			bot.releaseJob(job)
 			continue
 		}
-		if platform == "" {
-			slog.Debug("Skipping user with no platform set", "user_id", user.UserID)
+		if time.Now().Add(JobLeaseMargin).Unix() > job.LeaseExpiresUnix {
+			// the job may be reset and claimed by another process before the redemption finishes. It isn't released,
+			// in case that already happened; resetJobs returns it to pending once the lease expires
+			slog.Warn("Skipping redemption job whose lease is about to expire", "user_id", user.UserID, "job_id", job.ID)
 			continue
 		}
-		errors, err := bot.storage.GetShiftErrors(user.UserID)
+		err = bot.redeemJob(ctx, client, user, job, dm)
+	}
+	bot.saveRotatedCookies(user, client)
+	if errors.Is(err, errUserBackoff) {
+		// only this user stops
+		return nil
+	}
+	return err
+}
+
+// resetJobs returns jobs whose lease expired to pending, so jobs claimed by a process that stopped without finishing
+// them (this one before a restart, or another one sharing the database) are picked up again
+func (bot *Bot) resetJobs() {
+	reset, err := bot.storage.ResetRedemptionJobs()
+	if err != nil {
+		slog.Error("Error resetting redemption jobs", "error", err.Error())
+	} else if reset > 0 {
+		slog.Info("Reset redemption jobs whose lease expired", "count", reset)
+	}
+}
+
+// dueJobsLoop processes the users with jobs that are due to be retried
+func (bot *Bot) dueJobsLoop(ctx context.Context) {
+	if bot.paused() {
+		return
+	}
+	userIDs, err := bot.storage.GetUsersWithDueRedemptionJobs()
+	if err != nil {
+		slog.Error("Error getting users with due redemption jobs", "error", err.Error())
+		return
+	}
+	if len(userIDs) == 0 || !bot.shiftUp(ctx) {
+		return
+	}
+	slog.Info("Started redemption job processing", "users", len(userIDs))
+
+	var userCookies []store.UserCookies
+	for _, userID := range userIDs {
+		cookies, err := bot.storage.GetDecryptedUserCookies(userID)
 		if err != nil {
-			slog.Error("Error getting shift errors", "user_id", user.UserID, "error", err.Error())
+			slog.Error("Failed to get cookies for user", "user_id", userID, "error", err.Error())
 			continue
 		}
-		if len(errors) > 4 {
-			if dm {
-				str := "It seems like the last 5 code attempts I tried for you returned errors...\n" +
-					"Your user credentials might be expired.\n\n" +
-					"Maybe try logging in again with `/login`, but if this continues, please reach out on the [Official Discord Server](" + ServerLink + ")"
-				err = bot.DMUser(user.UserID, str)
-				if err != nil {
-					slog.Error("Failed to DM user", "user_id", user.UserID, "error", err.Error())
-				} else {
-					slog.Info("DMed user for >4 sequential shift errors", "user_id", user.UserID)
-				}
+		userCookies = append(userCookies, store.UserCookies{UserID: userID, Cookies: cookies})
+	}
+	err = bot.forEachUser(ctx, userCookies, bot.redeemForUser)
+	if errors.Is(err, shift.ErrRateLimited) {
+		bot.pause(err)
+	} else if err != nil {
+		slog.Warn("SHiFT seems to be down, stopped redemption job processing", "breaker", bot.breaker.Status())
+	}
+}
+
+// saveRotatedCookies stores the cookies SHiFT rotated while the client was in use, so the stored session doesn't go
+// stale
+func (bot *Bot) saveRotatedCookies(user store.UserCookies, client *shift.Client) {
+	cookies, rotated := shift.RotatedCookies(user.Cookies, client.DumpCookies())
+	if !rotated {
+		return
+	}
+	err := bot.storage.EncryptAndSetUserCookies(user.UserID, cookies)
+	if err != nil {
+		slog.Error("Error storing rotated cookies", "user_id", user.UserID, "error", err.Error())
+		return
+	}
+	err = bot.storage.SetUserCookiesRefreshed(user.UserID)
+	if err != nil {
+		slog.Error("Error setting cookies refreshed", "user_id", user.UserID, "error", err.Error())
+		return
+	}
+	slog.Info("Stored rotated cookies", "user_id", user.UserID)
+}
+
+// sessionCheckLoop checks every user's stored session, so they hear that it's expiring before redemptions start failing
+func (bot *Bot) sessionCheckLoop(ctx context.Context) {
+	if !bot.shiftUp(ctx) {
+		return
+	}
+	userCookies, err := bot.storage.GetAllDecryptedUserCookiesSorted(-1)
+	if err != nil {
+		slog.Error("Error getting cookies", "error", err.Error())
+		return
+	}
+
+	err = bot.forEachUser(ctx, userCookies, bot.checkSession)
+	if errors.Is(err, shift.ErrRateLimited) {
+		bot.pause(err)
+	} else if err != nil {
+		slog.Warn("Stopped user session checks", "error", err.Error())
+	}
+}
+
+// checkSession checks and records the health of a user's session, and DMs them the first time it's found to be expiring
+// or invalid. It only returns an error if the rest of the checks should stop
+func (bot *Bot) checkSession(ctx context.Context, user store.UserCookies) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	previous, err := bot.storage.GetUserSession(user.UserID)
+	if err != nil {
+		slog.Error("Error getting session", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	client, err := bot.newShiftClient(user.UserID, user.Cookies)
+	if err != nil {
+		slog.Error("Error creating shift client", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	session, err := client.SessionStatus(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		} else if errors.Is(err, shift.ErrRateLimited) {
+			return err
+		} else if shift.IsUpstream(err) {
+			if bot.breaker.Failure(err) {
+				return errBreakerOpen
 			}
-			continue
+			return nil
 		}
-		codes, err := bot.storage.GetValidCodesNotRedeemedForUser(user.UserID, platform, 10)
-		if err != nil {
-			slog.Error("Error getting codes", "error", err.Error())
-			continue
+		slog.Error("Error checking session", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	bot.breaker.Success()
+	// storing rotated cookies forgets the session state, so it has to happen first
+	bot.saveRotatedCookies(user, client)
+
+	err = bot.storage.SetUserSession(user.UserID, session)
+	if err != nil {
+		slog.Error("Error setting session", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if session.Rewards != nil {
+		bot.syncRewards(user.UserID, session.Rewards)
+	}
+	slog.Debug("Checked session", "user_id", user.UserID, "state", session.State, "expires", session.Expires)
+
+	_, dm, err := bot.storage.GetUserPlatformsAndDM(user.UserID)
+	if err != nil {
+		slog.Error("Error getting DM setting", "user_id", user.UserID, "error", err.Error())
+		return nil
+	}
+	if !dm {
+		return nil
+	}
+	switch {
+	case session.State == shift.SessionExpiringSoon && !previous.Warned:
+		str := "Heads up! Your SHiFT session expires <t:" + strconv.FormatInt(session.Expires.Unix(), 10) + ":R>, " +
+			"after which I won't be able to redeem codes for you.\n\n" +
+			"Use `/login` again before then to keep things running."
+		err = bot.DMUser(user.UserID, str)
+		if err == nil {
+			err = bot.storage.SetUserSessionWarned(user.UserID)
+		}
+	case session.State == shift.SessionInvalid && previous.State != shift.SessionInvalid:
+		str := "It looks like SHiFT no longer accepts your session, so I've stopped trying to redeem codes for you.\n\n" +
+			"Use `/login` again to pick up where we left off."
+		err = bot.DMUser(user.UserID, str)
+	default:
+		return nil
+	}
+	if err != nil {
+		slog.Error("Failed to DM user about their session", "user_id", user.UserID, "state", session.State, "error", err.Error())
+	} else {
+		slog.Info("DMed user about their session", "user_id", user.UserID, "state", session.State)
+	}
+	return nil
+}
+
+// attributeReward picks the reward a redemption unlocked out of the rewards that appeared while it ran
+func attributeReward(added []shift.RewardEntry, platform shift.Platform, game shift.Game) *shift.RewardEntry {
+	for _, entry := range added {
+		if entry.Platform == platform && (game == "" || entry.Game == game) {
+			return &entry
+		}
+	}
+	return nil
+}
+
+// syncRewards stores any rewards on the user's rewards page that weren't stored yet, like ones they had before joining
+func (bot *Bot) syncRewards(userID string, snapshot *shift.RewardsSnapshot) {
+	added, err := bot.storage.SyncUserRewards(userID, snapshot)
+	if err != nil {
+		slog.Error("Error syncing user rewards", "user_id", userID, "error", err.Error())
+	} else if added > 0 {
+		slog.Info("Stored user rewards", "user_id", userID, "count", added)
+	}
+}
+
+// redeemJob redeems the code of a claimed job, then finishes the job, or schedules it to be retried. It returns
+// shift.ErrRateLimited if SHiFT rate limits us, or errBreakerOpen if SHiFT keeps failing
+func (bot *Bot) redeemJob(ctx context.Context, client *shift.Client, user store.UserCookies, job store.RedemptionJob, dm bool) error {
+	code, platform := job.Code, job.Platform
+	reward, result, err := bot.redeemCode(ctx, client, user, code, shift.Game(job.Game), shift.Platform(platform))
+	success := result.Type == shift.Success
+	if err != nil && ctx.Err() != nil {
+		// errors from shutting down don't say anything about the user's credentials, or the job
+		slog.Info("Code redemption cancelled", "user_id", user.UserID, "code", code, "platform", platform)
+		bot.releaseJob(job)
+		return nil
+	} else if errors.Is(err, shift.ErrRateLimited) {
+		// being throttled doesn't say anything about the user's credentials either
+		bot.releaseJob(job)
+		return err
+	} else if shift.IsUpstream(err) {
+		// neither does SHiFT being down, so it counts against the breaker instead of the user
+		slog.Warn("SHiFT failed while redeeming code", "user_id", user.UserID, "code", code, "platform", platform, "error", err.Error())
+		bot.retryJob(job, err.Error())
+		if bot.breaker.Failure(err) {
+			return errBreakerOpen
+		}
+		return nil
+	} else if err != nil {
+		slog.Error("Error redeeming code", "user_id", user.UserID, "code", code, "platform", platform, "error", err.Error())
+		err2 := bot.storage.AddShiftError(user.UserID, code, platform, err.Error())
+		if err2 != nil {
+			slog.Error("Error adding shift error to db", "user_id", user.UserID, "code", code, "platform", platform, "error", err2.Error())
 		}
-		slog.Debug("Retrieved unredeemed codes", "user_id", user.UserID, "codes", len(codes))
+		bot.retryJob(job, err.Error())
+		if bot.userFailed(user.UserID, dm) {
+			return errUserBackoff
+		}
+		return nil
+	}
 
-		client, err := shift.NewClient(user.Cookies)
+	if reward != nil {
+		set, err := bot.storage.SetCodeRewardAndSuccess(code, reward.Title, success)
 		if err != nil {
-			slog.Error("Error creating shift client", "user_id", user.UserID, "error", err.Error())
-			continue
+			slog.Error("Error setting code reward", "code", code, "reward", reward.Title, "error", err.Error())
+		} else if set {
+			slog.Info("Set reward", "code", code, "reward", reward.Title)
 		}
+	}
+	if !result.Final() {
+		// a result SHiFT never finished or that we don't recognize doesn't say whether SHiFT or the user's credentials
+		// are working, so it's only retried
+		bot.retryJob(job, "no final result: "+result.Message)
+		return nil
+	}
 
-		for _, code := range codes {
-			reward, status, err := bot.redeemCode(client, user, code, shift.Platform(platform))
-			success := status == shift.SUCCESS
-			if err != nil {
-				slog.Error("Error redeeming code", "user_id", user.UserID, "code", code, "platform", platform, "error", err.Error())
-				err2 := bot.storage.AddShiftError(user.UserID, code, platform, err.Error())
-				if err2 != nil {
-					slog.Error("Error adding shift error to db", "user_id", user.UserID, "code", code, "platform", platform, "error", err2.Error())
-				}
-			} else {
-				// if no error was reported, then clear errors for this user
-				// (for now, we treat them as only important if they're sequential)
-				err = bot.storage.ClearShiftErrors(user.UserID)
-				if err != nil {
-					slog.Error("Error clearing shift errors from db", "user_id", user.UserID, "error", err.Error())
-				}
-				if reward != nil {
-					set, err := bot.storage.SetCodeRewardAndSuccess(code, reward.Title, success)
-					if err != nil {
-						slog.Error("Error setting code reward", "code", code, "reward", reward.Title, "error", err.Error())
-					} else if set {
-						slog.Info("Set reward", "code", code, "reward", reward.Title)
-					}
-				}
-			}
-			if success && dm {
-				str := Cheer + " I successfully redeemed `" + code + "` for you! " + Cheer + "\n\n"
-				if reward != nil {
-					str += "Looks like the prize was: `" + reward.Title + "`\n"
-				}
-				err = bot.DMUser(user.UserID, str)
-				if err != nil {
-					slog.Error("Error DMing user", "user_id", user.UserID, "error", err.Error())
-				} else {
-					slog.Info("DMed user", "user_id", user.UserID)
-				}
-			}
+	if success || result.Type == shift.AlreadyRedeemed {
+		bot.breaker.Success()
+		// the code was accepted, so clear errors for this user
+		// (for now, we treat them as only important if they're sequential)
+		err = bot.storage.ClearShiftErrors(user.UserID)
+		if err != nil {
+			slog.Error("Error clearing shift errors from db", "user_id", user.UserID, "error", err.Error())
 		}
+		err = bot.storage.ResetUserBackoff(user.UserID)
+		if err != nil {
+			slog.Error("Error resetting backoff", "user_id", user.UserID, "error", err.Error())
+		}
+	}
+	err = bot.storage.FinishRedemptionJob(job.ID)
+	if err != nil {
+		slog.Error("Error finishing redemption job", "user_id", user.UserID, "job_id", job.ID, "error", err.Error())
+	}
+
+	if success && dm {
+		bot.dmRedeemed(user.UserID, code, shift.Platform(platform), reward)
 	}
+	return nil
 }
 
-// redeemCode redeems a code for a user, and attempts to determine what "reward" was indicated by the redemption
-func (bot *Bot) redeemCode(client *shift.Client, user store.UserCookies, code string, platform shift.Platform) (reward *shift.Reward, status string, err error) {
-	rewards, err := client.CheckRewards(platform, shift.Borderlands4, -1)
+// dmRedeemed tells a user about a code that was redeemed for them, and what it unlocked if that's known
+func (bot *Bot) dmRedeemed(userID, code string, platform shift.Platform, reward *shift.Reward) {
+	str := Cheer + " I successfully redeemed `" + code + "` for you on " + shift.ToPretty(platform) + "! " + Cheer + "\n\n"
+	if reward != nil {
+		str += "Looks like the prize was: `" + reward.Title + "`\n"
+	}
+	err := bot.DMUser(userID, str)
 	if err != nil {
-		return nil, "", err
+		slog.Error("Error DMing user", "user_id", userID, "error", err.Error())
+	} else {
+		slog.Info("DMed user", "user_id", userID)
+	}
+}
+
+// userBackoff is how long a user's redemptions back off after failing a number of times in a row
+func userBackoff(failures int) time.Duration {
+	if failures < UserFailureThreshold {
+		return 0
 	}
+	return min(UserRetryBackoff<<min(failures-UserFailureThreshold, 16), MaxUserRetryBackoff)
+}
 
-	status, err = client.RedeemCode(code, platform)
+// userFailed records a redemption that failed in a way that's likely the user's problem, like expired credentials, and
+// returns true if their redemptions are now backing off. The user is DMed once, when the failures start backing off
+func (bot *Bot) userFailed(userID string, dm bool) bool {
+	backoff, err := bot.storage.GetUserBackoff(userID)
 	if err != nil {
-		newRewards, err2 := client.CheckRewards(platform, shift.Borderlands4, -1)
-		if err2 != nil {
-			return nil, status, err2
+		slog.Error("Error getting backoff", "user_id", userID, "error", err.Error())
+		return false
+	}
+	backoff.ConsecutiveFailures++
+	wait := userBackoff(backoff.ConsecutiveFailures)
+	if wait > 0 {
+		backoff.NextAttemptUnix = time.Now().Add(wait).Unix()
+		slog.Warn("Backing off user after failures", "user_id", userID, "failures", backoff.ConsecutiveFailures, "until", time.Unix(backoff.NextAttemptUnix, 0))
+	}
+	// users who turned DMs off haven't been warned, so they still are if they turn them back on
+	if wait > 0 && !backoff.Warned && dm {
+		str := "It seems like the last " + strconv.Itoa(backoff.ConsecutiveFailures) + " code attempts I tried for you returned errors...\n" +
+			"Your user credentials might be expired, so I'll only try again every so often.\n\n" +
+			"Maybe try logging in again with `/login`, but if this continues, please reach out on the [Official Discord Server](" + ServerLink + ")"
+		err = bot.DMUser(userID, str)
+		if err != nil {
+			// not warned, so it's tried again on the next failure
+			slog.Error("Failed to DM user", "user_id", userID, "error", err.Error())
+		} else {
+			backoff.Warned = true
+			slog.Info("DMed user about sequential shift errors", "user_id", userID, "failures", backoff.ConsecutiveFailures)
 		}
+	}
+	err = bot.storage.SetUserBackoff(userID, backoff)
+	if err != nil {
+		slog.Error("Error setting backoff", "user_id", userID, "error", err.Error())
+	}
+	return wait > 0
+}
+
+// jobBackoff is how long to wait before retrying a job that has failed a number of times
+func jobBackoff(attempts int) time.Duration {
+	return JobRetryBackoff << min(max(attempts-1, 0), 16)
+}
+
+// retryJob schedules a failed job to be retried after backing off, or parks it once it has failed too many times
+func (bot *Bot) retryJob(job store.RedemptionJob, lastError string) {
+	attempts := job.Attempts + 1
+	var err error
+	if attempts >= MaxJobAttempts {
+		slog.Warn("Parking redemption job after too many failures", "user_id", job.UserID, "job_id", job.ID, "code", job.Code, "platform", job.Platform, "attempts", attempts, "error", lastError)
+		err = bot.storage.ParkRedemptionJob(job.ID, lastError)
+	} else {
+		err = bot.storage.RetryRedemptionJob(job.ID, time.Now().Add(jobBackoff(attempts)), lastError)
+	}
+	if err != nil {
+		slog.Error("Error rescheduling redemption job", "user_id", job.UserID, "job_id", job.ID, "error", err.Error())
+	}
+}
 
-		// NOTE: if code redemption starts being performed in parallel for a given user, then this would be unsafe...
+// releaseJob returns a claimed job that wasn't really attempted, without counting it as a failure
+func (bot *Bot) releaseJob(job store.RedemptionJob) {
+	if err := bot.storage.ReleaseRedemptionJob(job.ID); err != nil {
+		slog.Error("Error releasing redemption job", "user_id", job.UserID, "job_id", job.ID, "error", err.Error())
+	}
+}
+
+// redeemCode redeems a code for a user, and works out which reward it unlocked by comparing the rewards page from just
+// before the redemption with the page afterward
+func (bot *Bot) redeemCode(ctx context.Context, client *shift.Client, user store.UserCookies, code string, game shift.Game, platform shift.Platform) (reward *shift.Reward, result shift.RedeemResult, err error) {
+	result, err = client.RedeemCode(ctx, code, game, platform)
+	if errors.Is(err, shift.ErrRateLimited) {
+		// checking the rewards again would only be throttled too
+		return nil, result, err
+	}
 
-		if len(newRewards) > len(rewards) {
-			slog.Info("Code redemption returned error, but reward length increased, so presumably it was successful", "error", err.Error())
-			reward = &newRewards[0]
-			// fallthrough to below, where we add the redemption if it seems successful
+	// a reward can only have been unlocked by a success, or by an error after the rewards page was loaded
+	if result.Rewards != nil && (err != nil || result.Type == shift.Success) {
+		after, err2 := client.Rewards(ctx)
+		if err2 != nil && err != nil {
+			return nil, result, err
+		} else if err2 != nil {
+			slog.Error("Error loading rewards after redeeming code", "user_id", user.UserID, "code", code, "platform", platform, "error", err2.Error())
 		} else {
-			return nil, status, err
+			if entry := attributeReward(after.Diff(result.Rewards), platform, game); entry != nil {
+				reward = &entry.Reward
+				err2 = bot.storage.AddUserReward(user.UserID, code, *entry)
+				if err2 != nil {
+					slog.Error("Error adding user reward", "user_id", user.UserID, "code", code, "reward", entry.Title, "error", err2.Error())
+				}
+			}
+			bot.syncRewards(user.UserID, after)
 		}
 	}
-
-	// only check the reward if we successfully redeemed. Code above handles if we got an error response, but the rewards increased
-	if status == shift.SUCCESS {
-		newRewards, err2 := client.CheckRewards(platform, shift.Borderlands4, 1)
-		if err2 != nil {
-			return nil, status, err2
+	if err != nil {
+		if reward == nil {
+			return nil, result, err
 		}
-		reward = &newRewards[0]
+		slog.Info("Code redemption returned error, but a new reward appeared, so presumably it was successful", "reward", reward.Title, "error", err.Error())
+		result.Type = shift.Success
+	}
+
+	// pending or unrecognized responses are retried next time instead of recorded
+	if !result.Final() {
+		slog.Warn("Code redemption didn't reach a final result", "user_id", user.UserID, "code", code, "platform", platform, "pending", result.Type == shift.Pending, "message", result.Message, "retryable", result.Retryable)
+		return reward, result, nil
 	}
 
-	err = bot.storage.AddRedemption(user.UserID, code, string(platform), status)
+	err = bot.storage.AddRedemption(user.UserID, code, result)
 	if err != nil {
-		slog.Error("Error adding redemption", "user_id", user.UserID, "code", code, "platform", platform, "status", status, "error", err.Error())
+		slog.Error("Error adding redemption", "user_id", user.UserID, "code", code, "platform", platform, "status", result.Message, "error", err.Error())
 	} else {
-		slog.Info("processed code", "user_id", user.UserID, "code", code, "status", status)
+		slog.Info("processed code", "user_id", user.UserID, "code", code, "status", result.Type.Status())
 	}
-	return reward, status, nil
+	return reward, result, nil
 }
@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/shift/shifttest"
+	"github.com/denverquane/slickshift/store"
+)
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, JobRetryBackoff},
+		{2, 2 * JobRetryBackoff},
+		{3, 4 * JobRetryBackoff},
+		{4, 8 * JobRetryBackoff},
+	}
+	for _, test := range tests {
+		if backoff := jobBackoff(test.attempts); backoff != test.expected {
+			t.Errorf("Expected %v backoff after %d attempts, got %v", test.expected, test.attempts, backoff)
+		}
+	}
+}
+
+func TestRetryJob_ParksAfterMaxAttempts(t *testing.T) {
+	bot := newTestBot(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	bot.storage.AddUser(userID)
+	bot.storage.SetUserPlatforms(userID, []string{platform})
+	bot.storage.AddCode("J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW", string(shift.DefaultGame), nil, nil)
+	bot.storage.EnqueueRedemptionJobs(userID, platform)
+
+	for attempt := 1; attempt <= MaxJobAttempts; attempt++ {
+		jobs, err := bot.storage.GetRedemptionJobs(store.JobPending, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) != 1 {
+			t.Fatalf("Expected the job to be pending before attempt %d, got %d jobs", attempt, len(jobs))
+		}
+		bot.retryJob(jobs[0], "failed")
+	}
+
+	parked, err := bot.storage.GetRedemptionJobs(store.JobParked, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parked) != 1 || parked[0].Attempts != MaxJobAttempts || parked[0].LastError != "failed" {
+		t.Fatalf("Expected the job to be parked after %d attempts, got %+v", MaxJobAttempts, parked)
+	}
+}
+
+// a redemption SHiFT never finishes doesn't show the user's credentials or SHiFT are working again
+func TestRedeemJob_PendingOnlyRetries(t *testing.T) {
+	const code = "J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW"
+	const userID = "123"
+	const email = "vault@hunter.com"
+	const platform = string(shift.Steam)
+	server := shifttest.NewServer()
+	t.Cleanup(server.Close)
+	server.SetCode(code, shifttest.Code{Outcome: shifttest.InProgress, Game: shift.Borderlands4})
+	server.AddAccount(email, "hunter2")
+
+	bot := newTestBot(t)
+	bot.breaker = NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	bot.breaker.Failure(errors.New("503"))
+	bot.SetShiftOptions(shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 20*time.Millisecond))
+	bot.storage.AddUser(userID)
+	bot.storage.SetUserPlatforms(userID, []string{platform})
+	bot.storage.AddCode(code, string(shift.Borderlands4), nil, nil)
+	bot.storage.EnqueueRedemptionJobs(userID, platform)
+	failing := store.UserBackoff{ConsecutiveFailures: UserFailureThreshold - 1}
+	bot.storage.SetUserBackoff(userID, failing)
+
+	jobs, err := bot.storage.ClaimRedemptionJobs(bot.workerID, userID, 1, JobLease)
+	if err != nil || len(jobs) != 1 {
+		t.Fatal("Expected to claim the job, got ", jobs, err)
+	}
+	user := store.UserCookies{UserID: userID, Cookies: server.Cookies(email)}
+	client, err := bot.newShiftClient(userID, user.Cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.redeemJob(context.Background(), client, user, jobs[0], false); err != nil {
+		t.Fatal(err)
+	}
+
+	backoff, err := bot.storage.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff.ConsecutiveFailures != failing.ConsecutiveFailures {
+		t.Fatalf("Expected the user's failures not to be reset, got %+v", backoff)
+	}
+	if status := bot.breaker.Status(); status.Failures != 1 {
+		t.Fatalf("Expected the breaker's failures not to be reset, got %+v", status)
+	}
+	pending, err := bot.storage.GetRedemptionJobs(store.JobPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("Expected the job to be retried, got %+v", pending)
+	}
+}
+
+func TestShiftUp_CancelledProbe(t *testing.T) {
+	bot := newTestBot(t)
+	bot.breaker = NewBreaker(1, 0)
+	bot.breaker.Failure(errors.New("503"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if bot.shiftUp(ctx) {
+		t.Fatal("Expected a cancelled probe not to resume redemptions")
+	}
+	if status := bot.breaker.Status(); status.State != BreakerOpen || status.Failures != 1 {
+		t.Fatalf("Expected the cancelled probe not to count, got %+v", status)
+	}
+	if !bot.breaker.Allow() {
+		t.Fatal("Expected the breaker to allow another probe")
+	}
+}
+
+func TestUserRedemptionLoop_TriggeredUsers(t *testing.T) {
+	const code = "J9RBJ-CKWT3-6F6XB-5B3TT-WTSZW"
+	const platform = string(shift.Steam)
+	server := shifttest.NewServer()
+	t.Cleanup(server.Close)
+	server.SetCode(code, shifttest.Code{Outcome: shifttest.Success, Game: shift.Borderlands4})
+
+	bot := newTestBot(t)
+	bot.workers = 2
+	bot.breaker = NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	bot.SetShiftOptions(shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	bot.storage.AddCode(code, string(shift.Borderlands4), nil, nil)
+	users := []struct{ userID, email string }{
+		{"1", "first@example.com"},
+		{"2", "second@example.com"},
+		{"3", "third@example.com"},
+	}
+	for _, user := range users {
+		server.AddAccount(user.email, "hunter2")
+		bot.storage.AddUser(user.userID)
+		bot.storage.SetUserPlatforms(user.userID, []string{platform})
+		bot.storage.EncryptAndSetUserCookies(user.userID, server.Cookies(user.email))
+	}
+
+	// the triggered users are processed together, and only them
+	bot.userRedemptionLoop(context.Background(), []string{"1", "2"})
+	for _, user := range users {
+		codes, err := bot.storage.GetValidCodesNotRedeemedForUser(user.userID, platform, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		triggered := user.userID != "3"
+		if redeemed := len(codes) == 0; redeemed != triggered {
+			t.Fatalf("Expected redeemed to be %t for user %s, got %t", triggered, user.userID, redeemed)
+		}
+	}
+}
+
+func TestUserBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{UserFailureThreshold - 1, 0},
+		{UserFailureThreshold, UserRetryBackoff},
+		{UserFailureThreshold + 1, 2 * UserRetryBackoff},
+		{UserFailureThreshold + 2, 4 * UserRetryBackoff},
+		{UserFailureThreshold + 20, MaxUserRetryBackoff},
+	}
+	for _, test := range tests {
+		if backoff := userBackoff(test.failures); backoff != test.expected {
+			t.Errorf("Expected %v backoff after %d failures, got %v", test.expected, test.failures, backoff)
+		}
+	}
+}
+
+func TestUserFailed_BacksOff(t *testing.T) {
+	bot := newTestBot(t)
+	dms := recordDMs(bot)
+	const userID = "123"
+	bot.storage.AddUser(userID)
+
+	for failure := 1; failure < UserFailureThreshold; failure++ {
+		if bot.userFailed(userID, false) {
+			t.Fatalf("User shouldn't back off after %d failures", failure)
+		}
+	}
+	if !bot.userFailed(userID, false) {
+		t.Fatal("User should back off at the failure threshold")
+	}
+	backoff, err := bot.storage.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the user has DMs off, so they haven't been warned
+	if backoff.Warned || backoff.NextAttemptUnix <= time.Now().Unix() || len(*dms) != 0 {
+		t.Fatalf("Expected the user to be backing off without a warning, got %+v", backoff)
+	}
+	first := backoff.NextAttemptUnix
+
+	// failing again backs off further
+	bot.userFailed(userID, false)
+	backoff, err = bot.storage.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff.ConsecutiveFailures != UserFailureThreshold+1 || backoff.NextAttemptUnix <= first {
+		t.Fatalf("Unexpected backoff %+v", backoff)
+	}
+}
+
+func TestUserFailed_WarnsOnce(t *testing.T) {
+	bot := newTestBot(t)
+	dms := recordDMs(bot)
+	const userID = "123"
+	bot.storage.AddUser(userID)
+
+	for range UserFailureThreshold + 1 {
+		bot.userFailed(userID, true)
+	}
+	if len(*dms) != 1 || (*dms)[0].userID != userID || !strings.Contains((*dms)[0].content, "/login") {
+		t.Fatalf("Expected the user to be DMed once, got %+v", *dms)
+	}
+	backoff, err := bot.storage.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backoff.Warned {
+		t.Fatalf("Expected the user to be warned, got %+v", backoff)
+	}
+}
+
+func TestUserFailed_DMFails(t *testing.T) {
+	bot := newTestBot(t)
+	bot.sendDM = func(userID, content string) error {
+		return errors.New("cannot send messages to this user")
+	}
+	const userID = "123"
+	bot.storage.AddUser(userID)
+
+	for range UserFailureThreshold {
+		bot.userFailed(userID, true)
+	}
+	backoff, err := bot.storage.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff.Warned || backoff.NextAttemptUnix <= time.Now().Unix() {
+		t.Fatalf("Expected the user to be backing off without a warning, got %+v", backoff)
+	}
+}
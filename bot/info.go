@@ -59,6 +59,16 @@ func toSortedEmbeds(m map[string]int64) []*discordgo.MessageEmbedField {
 	return embeds
 }
 
+func breakerStatusText(status BreakerStatus) string {
+	switch status.State {
+	case BreakerOpen:
+		return fmt.Sprintf("Down since <t:%d:R>, code redemption is paused", status.OpenedAt.Unix())
+	case BreakerHalfOpen:
+		return "Checking whether SHiFT is back up"
+	}
+	return "Up"
+}
+
 func (bot *Bot) infoResponse(userID string, s *discordgo.Session, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
 	stats, err := bot.storage.GetStatistics(userID)
 	if err != nil {
@@ -83,6 +93,10 @@ func (bot *Bot) infoResponse(userID string, s *discordgo.Session, i *discordgo.I
 		&discordgo.MessageEmbed{
 			Title: "Slickshift",
 			Fields: []*discordgo.MessageEmbedField{
+				&discordgo.MessageEmbedField{
+					Name:  "SHiFT Status",
+					Value: breakerStatusText(bot.breaker.Status()),
+				},
 				&discordgo.MessageEmbedField{
 					Name:  "Official Server",
 					Value: "[Join Server](" + ServerLink + ")",
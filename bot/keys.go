@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
+)
+
+// how many months of keys are listed for each platform
+const keysMonths = 6
+
+func (bot *Bot) keysResponse(userID string, s *discordgo.Session, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
+	keys, err := bot.storage.GetUserGoldenKeys(userID)
+	if err != nil {
+		slog.Error("Error fetching golden keys", "user_id", userID, "error", err.Error())
+		return privateMessageResponse("Yikes, I got an error fetching your Golden Keys. Please try again later.")
+	}
+	if len(keys) == 0 {
+		return privateMessageResponse("Looks like I haven't seen any Golden Keys on your SHiFT account yet!")
+	}
+
+	earned, total := countKeys(keys)
+	embeds := []*discordgo.MessageEmbed{
+		{
+			Title: "Golden Keys",
+			Color: Yellow,
+			Fields: []*discordgo.MessageEmbedField{
+				{
+					Name:   "Earned by SlickShift",
+					Value:  fmt.Sprintf("%d", earned),
+					Inline: true,
+				},
+				{
+					Name:   "Total on SHiFT",
+					Value:  fmt.Sprintf("%d", total),
+					Inline: true,
+				},
+			},
+		},
+	}
+	for _, platform := range shift.Platforms {
+		if field := keysField(keys, platform); field != nil {
+			embeds[0].Fields = append(embeds[0].Fields, field)
+		}
+	}
+	msg := privateMessageResponse("")
+	msg.Data.Embeds = embeds
+	return msg
+}
+
+// countKeys adds up the keys SlickShift earned, and the keys on SHiFT overall, across every platform and month
+func countKeys(keys []store.GoldenKeys) (earned, total int64) {
+	for _, k := range keys {
+		earned += k.Earned
+		total += k.Total
+	}
+	return earned, total
+}
+
+// keysField lists the keys earned on a platform by month, newest first, or returns nil if there weren't any
+func keysField(keys []store.GoldenKeys, platform shift.Platform) *discordgo.MessageEmbedField {
+	var lines []string
+	for _, k := range keys {
+		if k.Platform != string(platform) {
+			continue
+		}
+		if len(lines) == keysMonths {
+			lines = append(lines, "...")
+			break
+		}
+		month := k.Month
+		if t, err := time.Parse("2006-01", k.Month); err == nil {
+			month = t.Format("Jan 2006")
+		}
+		lines = append(lines, fmt.Sprintf("%s: **%d** (%d total)", month, k.Earned, k.Total))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  platformLabels[platform],
+		Value: strings.Join(lines, "\n"),
+	}
+}
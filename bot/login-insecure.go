@@ -1,24 +1,24 @@
 package bot
 
 import (
+	"context"
 	"log"
 	"log/slog"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/denverquane/slickshift/shift"
 )
 
 func (bot *Bot) loginResponse(userID string, s *discordgo.Session, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
 	email := i.ApplicationCommandData().Options[0].StringValue()
 	password := i.ApplicationCommandData().Options[1].StringValue()
 
-	client, err := shift.NewClient(nil)
+	client, err := bot.newShiftClient(userID, nil)
 	if err != nil {
 		log.Println(err)
 		return privateMessageResponse("I encountered an error creating an HTTP client for login. Please try again later.")
 	}
 
-	err = client.Login(email, password)
+	err = client.Login(context.Background(), email, password)
 	if err != nil {
 		log.Println(err)
 		return privateMessageResponse("I wasn't able to log you in to SHiFT. Are you sure you provided the right credentials?")
@@ -33,6 +33,10 @@ func (bot *Bot) loginResponse(userID string, s *discordgo.Session, i *discordgo.
 	if err != nil {
 		slog.Error("Couldn't clear shift_errors for user", "user_id", userID, "error", err.Error())
 	}
+	err = bot.storage.ResetUserBackoff(userID)
+	if err != nil {
+		slog.Error("Couldn't reset backoff for user", "user_id", userID, "error", err.Error())
+	}
 	bot.triggerRedemptionProcessing(userID)
 	return privateMessageResponse(Cheer + " Success! " + Cheer + "\n\nI've securely stored your session cookies (and purged your email/password) for automatic SHiFT code redemption!")
 }
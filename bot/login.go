@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"log"
 	"log/slog"
 	"strings"
@@ -20,12 +21,12 @@ func (bot *Bot) loginCookieResponse(userID string, s *discordgo.Session, i *disc
 		return privateMessageResponse("Hm, doesn't look like you provided the right Cookie information...\n\n" +
 			"Call `" + LOGIN + "` again without any values to see how to obtain the proper SHiFT cookies.")
 	}
-	client, err := shift.NewClient(newCookies)
+	client, err := bot.newShiftClient(userID, newCookies)
 	if err != nil {
 		log.Println(err)
 		return privateMessageResponse("I encountered an error creating an HTTP client for login. Please try again later.")
 	}
-	_, err = client.CheckRewards(shift.Steam, shift.Borderlands4, 0)
+	rewards, err := client.Rewards(context.Background())
 	if err != nil {
 		log.Println(err)
 		return privateMessageResponse("I encountered an error fetching the SHiFT rewards website with your Cookie. Are you sure you copy/pasted it correctly?")
@@ -39,6 +40,12 @@ func (bot *Bot) loginCookieResponse(userID string, s *discordgo.Session, i *disc
 	if err != nil {
 		slog.Error("Couldn't clear shift_errors for user", "user_id", userID, "error", err.Error())
 	}
+	err = bot.storage.ResetUserBackoff(userID)
+	if err != nil {
+		slog.Error("Couldn't reset backoff for user", "user_id", userID, "error", err.Error())
+	}
+	// the rewards page was loaded anyway, so remember what the user unlocked before joining
+	bot.syncRewards(userID, rewards)
 	bot.triggerRedemptionProcessing(userID)
 	return privateMessageResponse(Cheer + " Success! " + Cheer + "\n\nI've securely stored your session cookies for automatic SHiFT code redemption!")
 }
@@ -0,0 +1,99 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	"github.com/denverquane/slickshift/store"
+)
+
+// DefaultWorkers is how many users are processed at once. Requests to SHiFT are still budgeted by the shared limiter,
+// so more workers only helps while users are waiting on their own per-account budget
+const DefaultWorkers = 4
+
+// accountLocks serializes everything done with a SHiFT account, since working out which reward a redemption unlocked
+// relies on nothing else changing the account's rewards page in the meantime
+type accountLocks struct {
+	lock  sync.Mutex
+	users map[string]*accountLock
+}
+
+type accountLock struct {
+	sync.Mutex
+	// how many callers hold or are waiting on the lock, so it can be forgotten when there are none
+	refs int
+}
+
+// Lock blocks until nothing else is using the user's account, and returns the function that releases it
+func (l *accountLocks) Lock(userID string) (unlock func()) {
+	l.lock.Lock()
+	if l.users == nil {
+		l.users = map[string]*accountLock{}
+	}
+	user, ok := l.users[userID]
+	if !ok {
+		user = &accountLock{}
+		l.users[userID] = user
+	}
+	user.refs++
+	l.lock.Unlock()
+
+	user.Lock()
+	return func() {
+		user.Unlock()
+		l.lock.Lock()
+		user.refs--
+		if user.refs == 0 {
+			delete(l.users, userID)
+		}
+		l.lock.Unlock()
+	}
+}
+
+// forEachUser calls process for the users on a pool of workers, one user per worker at a time and never two calls for
+// the same account at once. The first error process returns stops any more users being started, and is returned once
+// the users already started have finished. Cancelling the context does the same, without an error
+func (bot *Bot) forEachUser(ctx context.Context, users []store.UserCookies, process func(context.Context, store.UserCookies) error) error {
+	jobs := make(chan store.UserCookies)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for range max(bot.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				unlock := bot.accounts.Lock(user.UserID)
+				err := process(ctx, user)
+				unlock()
+				if err != nil {
+					stopOnce.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, user := range users {
+		// checked first, since select picks at random when a worker is free as well
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- user:
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	// let in-flight users finish, so their results and rotated cookies are stored
+	wg.Wait()
+	return firstErr
+}
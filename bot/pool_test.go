@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/store"
+)
+
+func TestForEachUser_Concurrent(t *testing.T) {
+	bot := &Bot{workers: 4}
+	users := []store.UserCookies{{UserID: "1"}, {UserID: "2"}, {UserID: "3"}, {UserID: "4"}}
+
+	var running, most atomic.Int32
+	err := bot.forEachUser(context.Background(), users, func(ctx context.Context, user store.UserCookies) error {
+		now := running.Add(1)
+		for {
+			prev := most.Load()
+			if now <= prev || most.CompareAndSwap(prev, now) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most.Load() < 2 {
+		t.Fatalf("Expected users to be processed in parallel, at most %d were", most.Load())
+	}
+}
+
+func TestForEachUser_SerializesAccounts(t *testing.T) {
+	bot := &Bot{workers: 4}
+	users := []store.UserCookies{{UserID: "1"}, {UserID: "1"}, {UserID: "1"}, {UserID: "2"}}
+
+	var lock sync.Mutex
+	inFlight := map[string]bool{}
+	err := bot.forEachUser(context.Background(), users, func(ctx context.Context, user store.UserCookies) error {
+		lock.Lock()
+		if inFlight[user.UserID] {
+			t.Errorf("User %s was processed twice at once", user.UserID)
+		}
+		inFlight[user.UserID] = true
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		inFlight[user.UserID] = false
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bot.accounts.users) != 0 {
+		t.Errorf("Expected account locks to be released, %d are left", len(bot.accounts.users))
+	}
+}
+
+func TestForEachUser_StopsOnError(t *testing.T) {
+	bot := &Bot{workers: 1}
+	var users []store.UserCookies
+	for range 10 {
+		users = append(users, store.UserCookies{UserID: "1"})
+	}
+	stop := errors.New("stop")
+
+	var processed atomic.Int32
+	err := bot.forEachUser(context.Background(), users, func(ctx context.Context, user store.UserCookies) error {
+		if processed.Add(1) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("Expected the first error to be returned, got %v", err)
+	}
+	if processed.Load() > 3 {
+		t.Fatalf("Expected processing to stop after the error, but %d users were processed", processed.Load())
+	}
+}
+
+func TestForEachUser_DrainsOnCancel(t *testing.T) {
+	bot := &Bot{workers: 2}
+	users := []store.UserCookies{{UserID: "1"}, {UserID: "2"}, {UserID: "3"}, {UserID: "4"}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var started, finished atomic.Int32
+	err := bot.forEachUser(ctx, users, func(ctx context.Context, user store.UserCookies) error {
+		started.Add(1)
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		finished.Add(1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Cancelling shouldn't be an error, got %v", err)
+	}
+	if started.Load() != finished.Load() {
+		t.Fatalf("Expected in-flight users to finish, %d started and %d finished", started.Load(), finished.Load())
+	}
+	if started.Load() == int32(len(users)) {
+		t.Fatal("Expected no more users to be started after cancelling")
+	}
+}
@@ -64,6 +64,8 @@ func (bot *Bot) redemptionsResponse(userID string, session *discordgo.Session, i
 			color = DarkOrange
 		case shift.LINK2K:
 			color = Yellow
+		case shift.NOT_AVAILABLE:
+			color = Grey
 		case shift.NOT_EXIST:
 		case shift.EXPIRED:
 			color = Red
@@ -75,10 +77,10 @@ func (bot *Bot) redemptionsResponse(userID string, session *discordgo.Session, i
 					Name:  "Code",
 					Value: redem.Code,
 				},
-				//&discordgo.MessageEmbedField{
-				//	Name:  "Game",
-				//	Value: red.Game,
-				//},
+				{
+					Name:  "Game",
+					Value: redem.Game,
+				},
 			},
 			Color:       color,
 			Description: redem.Status,
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,39 +15,86 @@ func (bot *Bot) StartAPIServer(port string) {
 
 	codes := r.Group("/codes")
 	{
-		codes.POST("/:code", func(c *gin.Context) {
-			code := c.Param("code")
-
-			game := c.DefaultQuery("game", string(shift.Borderlands4))
+		// add a batch of codes, as a JSON array of codes or text containing them
+		codes.POST("", func(c *gin.Context) {
+			game := c.DefaultQuery("game", string(shift.DefaultGame))
 			if !shift.ValidGame(game) {
 				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid game"})
 				return
 			}
+			var texts []string
+			if err := c.ShouldBindJSON(&texts); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "expected a JSON array of codes"})
+				return
+			}
 			source := c.DefaultQuery("source", "")
-			if !shift.CodeRegex.MatchString(code) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code"})
+			var sourceAddr *string
+			if source != "" {
+				sourceAddr = &source
+			}
+
+			results, err := bot.addCodes(texts, game, nil, sourceAddr)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error(), "results": results})
 				return
 			}
-			if bot.storage.CodeExists(code) {
-				c.JSON(http.StatusConflict, gin.H{"message": "code already exists"})
+			c.JSON(http.StatusOK, gin.H{"game": game, "source": source, "results": results})
+		})
+		codes.POST("/:code", func(c *gin.Context) {
+			game := c.DefaultQuery("game", string(shift.DefaultGame))
+			if !shift.ValidGame(game) {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid game"})
 				return
 			}
+			source := c.DefaultQuery("source", "")
+			codes, rejected := shift.ExtractCodes(c.Param("code"))
+			if len(codes) != 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid code", "rejected": rejected})
+				return
+			}
+			code := codes[0]
 
 			var sourceAddr *string
 			if source != "" {
 				sourceAddr = &source
 			}
 
-			err := bot.storage.AddCode(code, game, nil, sourceAddr)
+			inserted, err := bot.storage.AddCodeForValidation(code, game, nil, sourceAddr)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 				return
 			}
+			if !inserted {
+				c.JSON(http.StatusConflict, gin.H{"message": "code already exists"})
+				return
+			}
 			// trigger reprocessing because we got a new code
 			bot.triggerRedemptionProcessing("")
 
 			c.JSON(http.StatusCreated, gin.H{"code": code, "game": game, "source": source})
 		})
+		// restore a code that was marked as expired or not existing, optionally for a single platform
+		codes.POST("/:code/restore", func(c *gin.Context) {
+			code := c.Param("code")
+			platform := c.DefaultQuery("platform", "")
+			if platform != "" && !shift.ValidPlatform(platform) {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid platform"})
+				return
+			}
+			if !bot.storage.CodeExists(code) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "code not found"})
+				return
+			}
+			restored, err := bot.storage.RestoreCode(code, platform)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			if restored {
+				bot.triggerRedemptionProcessing("")
+			}
+			c.JSON(http.StatusOK, gin.H{"code": code, "platform": platform, "restored": restored})
+		})
 	}
 	redemptions := r.Group("/redemptions")
 	{
@@ -77,6 +125,97 @@ func (bot *Bot) StartAPIServer(port string) {
 			c.JSON(http.StatusOK, gin.H{"redemptions": redems})
 		})
 	}
+	rewards := r.Group("/rewards")
+	{
+		// every reward on the user's SHiFT rewards page, and when it was unlocked
+		rewards.GET("/:user_id", func(c *gin.Context) {
+			userID := c.Param("user_id")
+			_, err := strconv.ParseUint(userID, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "user_id invalid"})
+				return
+			}
+			quantity := c.DefaultQuery("quantity", "0")
+			quantityNum, err := strconv.ParseUint(quantity, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid quantity"})
+				return
+			}
+
+			userRewards, err := bot.storage.GetUserRewards(userID, int(quantityNum))
+			if err != nil {
+				slog.Error("Error fetching rewards", "user_id", userID, "error", err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"rewards": userRewards})
+		})
+	}
+	users := r.Group("/users")
+	{
+		// golden keys on the user's SHiFT account by platform and month, and how many SlickShift earned them
+		users.GET("/:user_id/keys", func(c *gin.Context) {
+			userID := c.Param("user_id")
+			_, err := strconv.ParseUint(userID, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "user_id invalid"})
+				return
+			}
+
+			keys, err := bot.storage.GetUserGoldenKeys(userID)
+			if err != nil {
+				slog.Error("Error fetching golden keys", "user_id", userID, "error", err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			earned, total := countKeys(keys)
+			c.JSON(http.StatusOK, gin.H{"earned": earned, "total": total, "keys": keys})
+		})
+	}
+	jobs := r.Group("/jobs")
+	{
+		// redemption jobs in a state, parked ones by default, most recently updated first
+		jobs.GET("", func(c *gin.Context) {
+			state := store.RedemptionJobState(c.DefaultQuery("state", string(store.JobParked)))
+			if state != store.JobPending && state != store.JobRunning && state != store.JobParked {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid state"})
+				return
+			}
+			quantity := c.DefaultQuery("quantity", "50")
+			quantityNum, err := strconv.ParseUint(quantity, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid quantity"})
+				return
+			}
+
+			redemptionJobs, err := bot.storage.GetRedemptionJobs(state, int(quantityNum))
+			if err != nil {
+				slog.Error("Error fetching redemption jobs", "state", state, "error", err.Error())
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"jobs": redemptionJobs})
+		})
+		// requeue a parked job, so it's retried straight away
+		jobs.POST("/:id/requeue", func(c *gin.Context) {
+			id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "id invalid"})
+				return
+			}
+			requeued, err := bot.storage.RequeueRedemptionJob(id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			if !requeued {
+				c.JSON(http.StatusNotFound, gin.H{"message": "parked job not found"})
+				return
+			}
+			bot.triggerRedemptionProcessing("")
+			c.JSON(http.StatusOK, gin.H{"id": id, "requeued": requeued})
+		})
+	}
 	info := r.Group("/info")
 	{
 		info.GET("", func(c *gin.Context) {
@@ -85,7 +224,11 @@ func (bot *Bot) StartAPIServer(port string) {
 				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, stats)
+			c.JSON(http.StatusOK, struct {
+				store.Statistics
+				Shift    BreakerStatus `json:"shift"`
+				Triggers TriggerStats  `json:"triggers"`
+			}{stats, bot.breaker.Status(), bot.triggers.Stats()})
 		})
 	}
 
@@ -6,18 +6,34 @@ import (
 )
 
 const settingsSuffix = "* Do you want me to message you when I redeem codes for you, or when your login details expire?\n" +
-	"* Also, can you tell me what platform you'd like to auto-redeem SHiFT codes for?\n"
+	"* Also, can you tell me what platforms you'd like to auto-redeem SHiFT codes for?\n"
+
+const gamesSuffix = "* Which games would you like me to redeem SHiFT codes for?\n"
+
+const canarySuffix = "* Should newly added codes be tried on your account first, to check they work before I redeem them for everyone?\n"
 
 func (bot *Bot) settingsResponse(userID string, s *discordgo.Session, i *discordgo.InteractionCreate) *discordgo.InteractionResponse {
-	platform, shouldDM, err := bot.storage.GetUserPlatformAndDM(userID)
+	platforms, shouldDM, err := bot.storage.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		log.Println(err)
+		return privateMessageResponse("Hm, I got an error fetching your platforms. Please try again later.")
+	}
+	games, err := bot.storage.GetUserGames(userID)
+	if err != nil {
+		log.Println(err)
+		return privateMessageResponse("Hm, I got an error fetching your games. Please try again later.")
+	}
+	canary, err := bot.storage.GetUserCanary(userID)
 	if err != nil {
 		log.Println(err)
-		return privateMessageResponse("Hm, I got an error fetching your platform. Please try again later.")
+		return privateMessageResponse("Hm, I got an error fetching your settings. Please try again later.")
 	}
-	msg := privateMessageResponse(settingsSuffix)
+	msg := privateMessageResponse(settingsSuffix + gamesSuffix + canarySuffix)
 	msg.Data.Components = []discordgo.MessageComponent{
 		getDMComponents(true, shouldDM),
-		getPlatformComponents(platform != "", platform),
+		getPlatformComponents(platforms),
+		getGameComponents(games),
+		getCanaryComponents(canary),
 	}
 	return msg
 }
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"log"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -30,6 +32,12 @@ func main() {
 	guildID := os.Getenv("DISCORD_GUILD_ID")
 	redeemInterval := os.Getenv("REDEEM_INTERVAL")
 	apiServerPort := os.Getenv("API_SERVER_PORT")
+	invalidThreshold := os.Getenv("CODE_INVALID_THRESHOLD")
+	requestTimeout := os.Getenv("SHIFT_REQUEST_TIMEOUT")
+	globalRate := os.Getenv("SHIFT_GLOBAL_RATE")
+	accountRate := os.Getenv("SHIFT_ACCOUNT_RATE")
+	breakerThreshold := os.Getenv("SHIFT_BREAKER_THRESHOLD")
+	redeemWorkers := os.Getenv("REDEEM_WORKERS")
 
 	if apiServerPort == "" {
 		apiServerPort = "8080"
@@ -50,10 +58,69 @@ func main() {
 	} else if redeemIntervalInt < 1 {
 		log.Fatalf("REDEEM_INTERVAL cannot be less than 1")
 	}
+	if redeemWorkers == "" {
+		redeemWorkers = strconv.Itoa(bot.DefaultWorkers)
+		slog.Info("No REDEEM_WORKERS set, defaulting to " + redeemWorkers)
+	}
+	redeemWorkersInt, err := strconv.Atoi(redeemWorkers)
+	if err != nil {
+		log.Fatalf("Error parsing REDEEM_WORKERS: %s", err.Error())
+	} else if redeemWorkersInt < 1 {
+		log.Fatalf("REDEEM_WORKERS cannot be less than 1")
+	}
+	if invalidThreshold == "" {
+		invalidThreshold = strconv.Itoa(store.DefaultInvalidCodeThreshold)
+		slog.Info("No CODE_INVALID_THRESHOLD set, defaulting to " + invalidThreshold)
+	}
+	invalidThresholdInt, err := strconv.Atoi(invalidThreshold)
+	if err != nil {
+		log.Fatalf("Error parsing CODE_INVALID_THRESHOLD: %s", err.Error())
+	} else if invalidThresholdInt < 1 {
+		log.Fatalf("CODE_INVALID_THRESHOLD cannot be less than 1")
+	}
+	if requestTimeout == "" {
+		requestTimeout = strconv.Itoa(int(shift.DefaultRequestTimeout.Seconds()))
+		slog.Info("No SHIFT_REQUEST_TIMEOUT set, defaulting to " + requestTimeout + " (seconds)")
+	}
+	requestTimeoutInt, err := strconv.Atoi(requestTimeout)
+	if err != nil {
+		log.Fatalf("Error parsing SHIFT_REQUEST_TIMEOUT: %s", err.Error())
+	} else if requestTimeoutInt < 1 {
+		log.Fatalf("SHIFT_REQUEST_TIMEOUT cannot be less than 1")
+	}
+	globalRateFloat := shift.DefaultGlobalRate.PerSecond
+	if globalRate == "" {
+		slog.Info("No SHIFT_GLOBAL_RATE set, defaulting to " + strconv.FormatFloat(globalRateFloat, 'f', -1, 64) + " (requests per second)")
+	} else if globalRateFloat, err = strconv.ParseFloat(globalRate, 64); err != nil {
+		log.Fatalf("Error parsing SHIFT_GLOBAL_RATE: %s", err.Error())
+	} else if globalRateFloat <= 0 {
+		log.Fatalf("SHIFT_GLOBAL_RATE must be greater than 0")
+	}
+	accountRateFloat := shift.DefaultAccountRate.PerSecond
+	if accountRate == "" {
+		slog.Info("No SHIFT_ACCOUNT_RATE set, defaulting to " + strconv.FormatFloat(accountRateFloat, 'f', -1, 64) + " (requests per second)")
+	} else if accountRateFloat, err = strconv.ParseFloat(accountRate, 64); err != nil {
+		log.Fatalf("Error parsing SHIFT_ACCOUNT_RATE: %s", err.Error())
+	} else if accountRateFloat <= 0 {
+		log.Fatalf("SHIFT_ACCOUNT_RATE must be greater than 0")
+	}
+	if breakerThreshold == "" {
+		breakerThreshold = strconv.Itoa(bot.DefaultBreakerThreshold)
+		slog.Info("No SHIFT_BREAKER_THRESHOLD set, defaulting to " + breakerThreshold)
+	}
+	breakerThresholdInt, err := strconv.Atoi(breakerThreshold)
+	if err != nil {
+		log.Fatalf("Error parsing SHIFT_BREAKER_THRESHOLD: %s", err.Error())
+	} else if breakerThresholdInt < 1 {
+		log.Fatalf("SHIFT_BREAKER_THRESHOLD cannot be less than 1")
+	}
+	databaseURL := os.Getenv("DATABASE_URL")
 	dbFilePath := os.Getenv("DATABASE_FILE_PATH")
-	if dbFilePath == "" {
+	if databaseURL == "" && dbFilePath == "" {
 		dbFilePath = "./sqlite.db"
 		slog.Info("Database file path not set, defaulting to " + dbFilePath)
+	} else if databaseURL != "" && dbFilePath != "" {
+		log.Fatal("Only one of DATABASE_URL and DATABASE_FILE_PATH can be set")
 	}
 
 	if secretKey == "" {
@@ -72,9 +139,16 @@ func main() {
 		"Version", Version,
 		"Commit", Commit,
 		"DATABASE_FILE_PATH", dbFilePath,
+		"DATABASE_URL", redactURL(databaseURL),
 		"REDEEM_INTERVAL", redeemIntervalInt,
+		"REDEEM_WORKERS", redeemWorkersInt,
 		"DISCORD_GUILD_ID", guildID,
 		"API_SERVER_PORT", apiServerPort,
+		"CODE_INVALID_THRESHOLD", invalidThresholdInt,
+		"SHIFT_REQUEST_TIMEOUT", requestTimeoutInt,
+		"SHIFT_GLOBAL_RATE", globalRateFloat,
+		"SHIFT_ACCOUNT_RATE", accountRateFloat,
+		"SHIFT_BREAKER_THRESHOLD", breakerThresholdInt,
 		"DISCORD_BOT_TOKEN", "<redacted>",
 		"ENCRYPTION_KEY_B64", "<redacted>",
 	)
@@ -84,7 +158,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	storage, err := store.NewSqliteStore(dbFilePath, encryptor)
+	var storage store.Store
+	if databaseURL != "" {
+		storage, err = store.NewPostgresStore(databaseURL, encryptor, store.WithInvalidCodeThreshold(invalidThresholdInt))
+	} else {
+		storage, err = store.NewSqliteStore(dbFilePath, encryptor, store.WithInvalidCodeThreshold(invalidThresholdInt))
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -107,6 +186,13 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	b.SetShiftOptions(shift.WithRequestTimeout(time.Second * time.Duration(requestTimeoutInt)))
+	b.SetBreaker(bot.NewBreaker(breakerThresholdInt, bot.DefaultBreakerCooldown))
+	b.SetWorkers(redeemWorkersInt)
+	b.SetShiftLimiter(shift.NewLimiter(
+		shift.Rate{PerSecond: globalRateFloat, Burst: shift.DefaultGlobalRate.Burst},
+		shift.Rate{PerSecond: accountRateFloat, Burst: shift.DefaultAccountRate.Burst},
+	))
 	err = b.Start()
 	if err != nil {
 		log.Fatal(err)
@@ -119,13 +205,19 @@ func main() {
 		log.Fatal(err)
 	}
 
-	kill := make(chan bool, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 
-	go b.StartUserRedemptionProcessing(time.Minute*time.Duration(redeemIntervalInt), kill)
+	go func() {
+		b.StartUserRedemptionProcessing(ctx, time.Minute*time.Duration(redeemIntervalInt))
+		close(done)
+	}()
 
 	<-sc
 	log.Printf("Received Sigterm or Kill signal. Bot terminating after deleting commands")
-	kill <- true
+	cancel()
+	// wait for in-flight redemptions to be cancelled, and every worker to finish, before closing storage
+	<-done
 
 	b.DeleteCommands(guildID, cmds)
 	err = b.Stop()
@@ -133,3 +225,12 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// redactURL hides the password in a database URL, so it can be logged
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<redacted>"
+	}
+	return u.Redacted()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 
@@ -30,7 +31,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	err = c.Login(email, password)
+	err = c.Login(context.Background(), email, password)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,15 +49,14 @@ func main() {
 
 	codes := data.DefaultBL4Codes()
 	for code := range codes {
-		status, err := c.RedeemCode(code, shift.Steam)
+		result, err := c.RedeemCode(context.Background(), code, shift.Borderlands4, shift.Steam)
 		if err != nil {
 			log.Println("Couldn't redeem code with error:", err)
 		}
-		statusCode := shift.DetermineResponseType(status)
-		if statusCode == shift.Success {
+		if result.Type == shift.Success {
 			log.Printf("Redeemed %s successfully\n", code)
 		} else {
-			log.Printf("Redeemed %s failed with status: \"%s\"\n", code, status)
+			log.Printf("Redeemed %s failed with status: \"%s\" (http statuses: %v, retryable: %t)\n", code, result.Message, result.StatusCodes, result.Retryable)
 		}
 	}
 
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/denverquane/slickshift/store"
+)
+
+// migrate applies the database migrations the bot would apply when it starts, or shows what they are. It uses the
+// same DATABASE_URL and DATABASE_FILE_PATH as the bot
+func main() {
+	var status bool
+	var dryRun bool
+	var down int64
+	flag.BoolVar(&status, "status", false, "Show every migration and whether it was applied")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be applied (or undone, with -down) without changing anything")
+	flag.Int64Var(&down, "down", -1, "Undo the migrations applied after this version, using their .down.sql scripts")
+	flag.Parse()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	dbFilePath := os.Getenv("DATABASE_FILE_PATH")
+	if databaseURL != "" && dbFilePath != "" {
+		log.Fatal("Only one of DATABASE_URL and DATABASE_FILE_PATH can be set")
+	} else if databaseURL == "" && dbFilePath == "" {
+		dbFilePath = "./sqlite.db"
+	}
+
+	var migrator *store.Migrator
+	var err error
+	if databaseURL != "" {
+		migrator, err = store.NewPostgresMigrator(databaseURL)
+	} else {
+		migrator, err = store.NewSqliteMigrator(dbFilePath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer migrator.Close()
+
+	if status || dryRun {
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			switch {
+			case status:
+				printStatus(s)
+			case s.Changed:
+				fmt.Printf("Can't migrate, %d changed since it was applied\n", s.Version)
+			case down < 0 && !s.Applied:
+				fmt.Printf("Would apply %d\n", s.Version)
+			case down >= 0 && s.Applied && s.Version > down && !s.Reversible:
+				fmt.Printf("Can't undo %d, it has no down migration\n", s.Version)
+			case down >= 0 && s.Applied && s.Version > down:
+				fmt.Printf("Would undo %d\n", s.Version)
+			}
+		}
+		return
+	}
+
+	var versions []int64
+	if down >= 0 {
+		versions, err = migrator.Down(down)
+	} else {
+		versions, err = migrator.Up()
+	}
+	for _, version := range versions {
+		if down >= 0 {
+			fmt.Printf("Undid %d\n", version)
+		} else {
+			fmt.Printf("Applied %d\n", version)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(versions) == 0 {
+		fmt.Println("Nothing to do")
+	}
+}
+
+func printStatus(s store.MigrationStatus) {
+	str := "pending"
+	if s.Applied {
+		str = "applied " + time.Unix(s.AppliedUnix, 0).UTC().Format(time.DateTime)
+	}
+	if s.Changed {
+		str += ", CHANGED since it was applied"
+	}
+	if s.Unknown {
+		str += ", unknown to this version"
+	}
+	if s.Reversible {
+		str += ", reversible"
+	}
+	fmt.Printf("%d: %s\n", s.Version, str)
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	_ "embed"
-	"strings"
 
 	"github.com/denverquane/slickshift/shift"
 )
@@ -17,11 +16,9 @@ var bl4CodesText string
 // return a map to guarantee no duplicates
 func DefaultBL4Codes() map[string]struct{} {
 	codes := map[string]struct{}{}
-	for _, code := range strings.Split(bl4CodesText, "\n") {
-		code = strings.TrimSpace(code)
-		if shift.CodeRegex.MatchString(code) {
-			codes[code] = struct{}{}
-		}
+	extracted, _ := shift.ExtractCodes(bl4CodesText)
+	for _, code := range extracted {
+		codes[code] = struct{}{}
 	}
 	return codes
 }
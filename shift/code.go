@@ -1,7 +1,114 @@
 package shift
 
-import "regexp"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
 
 var CodeLength = 29
 
 var CodeRegex = regexp.MustCompile("^(?:[A-Z0-9]{5}-){4}[A-Z0-9]{5}$")
+
+// candidateRegex matches anything in free-form text that might be a SHiFT code: runs of letters and digits, optionally
+// split by hyphens with stray whitespace around them, or five uppercase groups of five split by single spaces. Spaces
+// alone only count as separators in uppercase, or any five five-letter words would be a candidate. Whether a candidate
+// really is a code is decided afterward
+var candidateRegex = regexp.MustCompile(`(?i)\b(?:(?-i:[A-Z0-9]{5}(?:[ \t][A-Z0-9]{5}){4})|[A-Z0-9]{4,25}(?:[ \t]*-[ \t]*[A-Z0-9]{4,25})*)\b`)
+
+// looseDashRegex matches a hyphen with whitespace on at least one side
+var looseDashRegex = regexp.MustCompile(`[ \t]+-[ \t]*|[ \t]*-[ \t]+`)
+
+// dashReplacer turns the dashes that word processors and social media like to substitute back into hyphens
+var dashReplacer = strings.NewReplacer("‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "−", "-")
+
+// codeLookalikes are characters SHiFT leaves out of codes because they're easily mistaken for each other
+const codeLookalikes = "O0I1L"
+
+// Rejection is something in the text that looked like a SHiFT code, but isn't one
+type Rejection struct {
+	Text   string
+	Reason string
+}
+
+// ExtractCodes finds every SHiFT code in free-form text, like a tweet or patch notes, and returns them in the canonical
+// XXXXX-XXXXX-XXXXX-XXXXX-XXXXX form, without duplicates and in the order they appeared. Lowercase, stray whitespace
+// around hyphens, dashes in place of hyphens, missing hyphens, spaces in place of hyphens and codes run together with
+// hyphens are all tolerated. Things that look like codes but can't be are returned as rejections, with the reason why
+func ExtractCodes(text string) ([]string, []Rejection) {
+	var codes []string
+	var rejected []Rejection
+	seen := map[string]bool{}
+
+	for _, match := range candidateRegex.FindAllString(dashReplacer.Replace(text), -1) {
+		// a dash with spaces around it may be punctuation rather than part of the code, like "CODE - redeem it now",
+		// so if the whole match isn't a code, each part between those dashes is tried on its own
+		parts := []string{match}
+		if len(codeChars(match)) != 25 {
+			parts = looseDashRegex.Split(match, -1)
+		}
+		var pieces []string
+		for _, part := range parts {
+			pieces = append(pieces, splitCodes(part)...)
+		}
+		for _, part := range pieces {
+			code := codeChars(part)
+			switch {
+			case len(code) == 25:
+				if i := strings.IndexAny(code, codeLookalikes); i >= 0 {
+					rejected = append(rejected, Rejection{
+						Text:   part,
+						Reason: fmt.Sprintf("contains %q, which SHiFT codes never use", code[i]),
+					})
+					continue
+				}
+				code = code[0:5] + "-" + code[5:10] + "-" + code[10:15] + "-" + code[15:20] + "-" + code[20:25]
+				if !seen[code] {
+					seen[code] = true
+					codes = append(codes, code)
+				}
+			case strings.Count(part, "-") >= 3 && len(code) >= 20 && len(code) <= 30:
+				// hyphenated like a code, but the wrong length. Anything else is just text
+				rejected = append(rejected, Rejection{
+					Text:   part,
+					Reason: fmt.Sprintf("has %d letters and numbers, but SHiFT codes have 25", len(code)),
+				})
+			}
+		}
+	}
+	return codes, rejected
+}
+
+// codeChars returns the letters and numbers of a candidate code, uppercased
+func codeChars(candidate string) string {
+	var chars strings.Builder
+	for _, group := range strings.Split(candidate, "-") {
+		chars.WriteString(strings.ToUpper(strings.Join(strings.Fields(group), "")))
+	}
+	return chars.String()
+}
+
+// splitCodes splits a candidate that's too long to be one code, like two codes joined by a hyphen or a code followed by
+// "-extra", into 25 character pieces, and whatever is left over. The pieces have to end between the hyphenated groups;
+// otherwise the candidate is returned as it was
+func splitCodes(candidate string) []string {
+	if len(codeChars(candidate)) <= 25 {
+		return []string{candidate}
+	}
+	var pieces []string
+	start, length := 0, 0
+	groups := strings.Split(candidate, "-")
+	for i, group := range groups {
+		length += len(codeChars(group))
+		if length > 25 {
+			return []string{candidate}
+		} else if length == 25 {
+			pieces = append(pieces, strings.Join(groups[start:i+1], "-"))
+			start, length = i+1, 0
+		}
+	}
+	if start < len(groups) {
+		pieces = append(pieces, strings.Join(groups[start:], "-"))
+	}
+	return pieces
+}
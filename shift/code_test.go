@@ -0,0 +1,61 @@
+package shift
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExtractCodes(t *testing.T) {
+	const code = "T9RJB-BFKRR-3RBTW-B33TB-KCZB9"
+	const other = "39FB3-SHWXS-RRWZK-533TB-JHJBC"
+	tests := []struct {
+		name     string
+		text     string
+		codes    []string
+		rejected int
+	}{
+		{"clean", code, []string{code}, 0},
+		{"lowercase", strings.ToLower(code), []string{code}, 0},
+		{"whitespace", "  T9RJB - BFKRR -3RBTW- B33TB -KCZB9\n", []string{code}, 0},
+		{"en dashes", "T9RJB–BFKRR–3RBTW–B33TB–KCZB9", []string{code}, 0},
+		{"missing hyphens", "T9RJBBFKRR3RBTWB33TBKCZB9", []string{code}, 0},
+		{"tweet", "New #Borderlands4 SHiFT code 🔑 " + code + " — redeem for a Golden Key! Expires 10/31. https://shift.gearboxsoftware.com", []string{code}, 0},
+		{"several with duplicates", "Codes:\n1. " + code + "\n2. " + other + "\n3. " + strings.ToLower(code), []string{code, other}, 0},
+		{"lookalike", "T9RJB-BFKRR-3RBTW-B33TB-KCZBO", nil, 1},
+		{"too short", "T9RJB-BFKRR-3RBTW-B33TB-KCZB", nil, 1},
+		{"space separated", "code: T9RJB BFKRR 3RBTW B33TB KCZB9!", []string{code}, 0},
+		{"space separated words", "these words never could match", nil, 0},
+		{"trailing group", code + "-extra", []string{code}, 0},
+		{"joined", code + "-" + other, []string{code, other}, 0},
+		{"joined with trailing group", code + "-" + other + "-TX9HB", []string{code, other}, 0},
+		{"joined with broken code", code + "-T9RJB-BFKRR-3RBTW-B33TB-KCZB", []string{code}, 1},
+		{"uneven groups", "T9RJBB-FKRR3-RBTWB-33TBK-CZB9TX9HB", nil, 1},
+		{"uuid", "550e8400-e29b-41d4-a716-446655440000", nil, 0},
+		{"ordinary text", "a well-known state-of-the-art release on 2025-10-03", nil, 0},
+		{"long identifier", "see commit 5f3c9b2e8d7a6c5b4a39281706f5e4d3c2b1a098", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes, rejected := ExtractCodes(tt.text)
+			if !slices.Equal(codes, tt.codes) {
+				t.Fatalf("Expected codes %v, got %v", tt.codes, codes)
+			}
+			if len(rejected) != tt.rejected {
+				t.Fatalf("Expected %d rejections, got %v", tt.rejected, rejected)
+			}
+			for _, r := range rejected {
+				if r.Reason == "" || r.Text == "" {
+					t.Fatal("Expected rejections to say what and why, got ", r)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractCodes_LookalikeReason(t *testing.T) {
+	_, rejected := ExtractCodes("t9rjb-bfkrr-3rbtw-b33tb-kczbo")
+	if len(rejected) != 1 || !strings.Contains(rejected[0].Reason, "'O'") {
+		t.Fatal("Expected the lookalike character to be named, got ", rejected)
+	}
+}
@@ -0,0 +1,81 @@
+package shift
+
+type Game string
+
+const (
+	Borderlands4         Game = "Borderlands 4"
+	Borderlands3         Game = "Borderlands 3"
+	Borderlands2         Game = "Borderlands 2"
+	BorderlandsPreSequel Game = "Borderlands: The Pre-Sequel"
+	BorderlandsGOTY      Game = "Borderlands: Game of the Year Edition"
+	TinyTinasWonderlands Game = "Tiny Tina's Wonderlands"
+)
+
+// DefaultGame is the game codes are assumed to be for, and the game users redeem codes for if they haven't picked any
+const DefaultGame = Borderlands4
+
+type GameInfo struct {
+	Game Game
+	// Title is the ID SHiFT uses for the game in its redemption forms
+	Title string
+	// RewardsHeader is the heading the game's rewards are listed under on the rewards page
+	RewardsHeader string
+}
+
+// Games is every game SHiFT codes can be redeemed for, newest first
+var Games = []GameInfo{
+	{Borderlands4, "oak2", "Borderlands 4"},
+	{TinyTinasWonderlands, "daffodil", "Tiny Tina's Wonderlands"},
+	{Borderlands3, "oak", "Borderlands 3"},
+	{BorderlandsPreSequel, "cork", "Borderlands: The Pre-Sequel"},
+	{Borderlands2, "willow2", "Borderlands 2"},
+	{BorderlandsGOTY, "mopane", "Borderlands: Game of the Year Edition"},
+}
+
+func lookupGame(g Game) (GameInfo, bool) {
+	for _, info := range Games {
+		if info.Game == g {
+			return info, true
+		}
+	}
+	return GameInfo{}, false
+}
+
+func ValidGame(g string) bool {
+	_, ok := lookupGame(Game(g))
+	return ok
+}
+
+// GameFromTitle returns the game for a SHiFT title ID, like "oak2"
+func GameFromTitle(title string) (Game, bool) {
+	for _, info := range Games {
+		if info.Title == title {
+			return info.Game, true
+		}
+	}
+	return "", false
+}
+
+// GameFromRewardsHeader returns the game whose rewards are listed under a header on the rewards page
+func GameFromRewardsHeader(header string) (Game, bool) {
+	for _, info := range Games {
+		if info.RewardsHeader == header {
+			return info.Game, true
+		}
+	}
+	return "", false
+}
+
+// Title returns the SHiFT title ID for the game, or an empty string if the game is unknown
+func (g Game) Title() string {
+	info, _ := lookupGame(g)
+	return info.Title
+}
+
+// RewardsHeader returns the heading the game's rewards are listed under on the rewards page
+func (g Game) RewardsHeader() string {
+	if info, ok := lookupGame(g); ok {
+		return info.RewardsHeader
+	}
+	return string(g)
+}
@@ -2,30 +2,287 @@ package shift
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
+// paths on the SHiFT website, relative to the base URL the Client is configured with
 const (
-	HOME        = "https://shift.gearboxsoftware.com/home"
-	SESSIONS    = "https://shift.gearboxsoftware.com/sessions"
-	REWARDS     = "https://shift.gearboxsoftware.com/rewards"
-	ENTITLEMENT = "https://shift.gearboxsoftware.com/entitlement_offer_codes"
-	REDEMPTIONS = "https://shift.gearboxsoftware.com/code_redemptions"
+	HOME        = "/home"
+	SESSIONS    = "/sessions"
+	REWARDS     = "/rewards"
+	ENTITLEMENT = "/entitlement_offer_codes"
+	REDEMPTIONS = "/code_redemptions"
 )
 
+// GearboxURL is the base URL of the live SHiFT website, and the default for every Client
 var GearboxURL = &url.URL{
 	Scheme: "https",
 	Host:   "shift.gearboxsoftware.com",
 }
 
+const (
+	DefaultRequestTimeout   = 30 * time.Second
+	DefaultOperationTimeout = 2 * time.Minute
+	DefaultDelay            = 1 * time.Second
+	DefaultPollInterval     = 1 * time.Second
+	DefaultPollTimeout      = 30 * time.Second
+
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 2 * time.Second
+
+	// the longest the Client waits between polls of a redemption's status
+	maxPollInterval = 8 * time.Second
+	// the longest the Client waits before retrying a throttled request. If SHiFT asks for longer, it gives up instead
+	maxRetryWait = 30 * time.Second
+)
+
+// ErrRateLimited is returned (wrapped in a RateLimitedError) when SHiFT keeps throttling requests after retrying
+var ErrRateLimited = errors.New("rate limited by SHiFT")
+
+type RateLimitedError struct {
+	StatusCode int
+	// RetryAfter is how long SHiFT asked us to wait, if it said
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	msg := fmt.Sprintf("%s (status %d)", ErrRateLimited.Error(), e.StatusCode)
+	if e.RetryAfter > 0 {
+		msg += ", retry after " + e.RetryAfter.String()
+	}
+	return msg
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+var (
+	// DefaultGlobalRate is the request budget shared by every account
+	DefaultGlobalRate = Rate{PerSecond: 5, Burst: 10}
+	// DefaultAccountRate is the request budget of each account
+	DefaultAccountRate = Rate{PerSecond: 1, Burst: 3}
+)
+
+// ErrUnavailable is matched by errors that mean SHiFT itself is down or failing, rather than anything being wrong with
+// the account making the request
+var ErrUnavailable = errors.New("SHiFT is unavailable")
+
+// StatusError is returned when SHiFT responds with an unexpected status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid response code %d", e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnavailable && e.StatusCode >= 500
+}
+
+// IsUpstream reports whether an error means SHiFT is down or unreachable, as opposed to a problem with the account
+// (like an expired session) or the request being cancelled
+func IsUpstream(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Rate is a budget of requests: Burst requests can be made at once, refilling at PerSecond. A PerSecond of zero or
+// less is unlimited
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accumulated since the bucket was last used, and returns how long until it has one to spend
+func (b *bucket) refill(rate Rate, now time.Time) time.Duration {
+	burst := float64(max(rate.Burst, 1))
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*rate.PerSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate.PerSecond * float64(time.Second))
+}
+
+// Limiter is a token bucket limiter for requests to SHiFT, with a budget shared by every account and a budget for each
+// account. One Limiter should be shared by every Client. It is safe for concurrent use
+type Limiter struct {
+	lock     sync.Mutex
+	global   Rate
+	account  Rate
+	buckets  map[string]*bucket
+	shared   *bucket
+	lastSeen time.Time
+}
+
+func NewLimiter(global, account Rate) *Limiter {
+	return &Limiter{
+		global:  global,
+		account: account,
+		buckets: map[string]*bucket{},
+		shared:  &bucket{tokens: float64(max(global.Burst, 1)), last: time.Now()},
+	}
+}
+
+// Wait blocks until a request for the account fits in both the global and account budgets, or the context is done
+func (l *Limiter) Wait(ctx context.Context, account string) error {
+	for {
+		wait := l.reserve(account, time.Now())
+		if wait == 0 {
+			return nil
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve spends a token from each budget if both have one, or returns how long until they might
+func (l *Limiter) reserve(account string, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var wait time.Duration
+	if l.global.PerSecond > 0 {
+		wait = l.shared.refill(l.global, now)
+	}
+	var b *bucket
+	if account != "" && l.account.PerSecond > 0 {
+		b = l.buckets[account]
+		if b == nil {
+			b = &bucket{tokens: float64(max(l.account.Burst, 1)), last: now}
+			l.buckets[account] = b
+		}
+		wait = max(wait, b.refill(l.account, now))
+	}
+	if wait > 0 {
+		return wait
+	}
+	if l.global.PerSecond > 0 {
+		l.shared.tokens--
+	}
+	if b != nil {
+		b.tokens--
+	}
+	// forget accounts that haven't made requests for a while, since their buckets would be full anyway
+	if now.Sub(l.lastSeen) > time.Minute {
+		for k, v := range l.buckets {
+			if now.Sub(v.last) > time.Minute {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSeen = now
+	}
+	return 0
+}
+
+// WithLimiter makes the Client wait for the limiter before every request. The account identifies whose budget the
+// requests are spent from
+func WithLimiter(limiter *Limiter, account string) Option {
+	return func(client *httpClient) error {
+		client.limiter = limiter
+		client.account = account
+		return nil
+	}
+}
+
+// WithRetries sets how many times a throttled request (429, or 5xx while SHiFT is overloaded) is retried, and the base
+// backoff between retries, which doubles every attempt and is jittered
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(client *httpClient) error {
+		if maxRetries < 0 || backoff <= 0 {
+			return errors.New("retries cannot be negative and backoff must be positive")
+		}
+		client.maxRetries = maxRetries
+		client.retryBackoff = backoff
+		return nil
+	}
+}
+
+// Option configures optional behavior of a Client
+type Option func(*httpClient) error
+
+// WithRequestTimeout bounds how long any single request to SHiFT can take
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(client *httpClient) error {
+		client.client.Timeout = timeout
+		return nil
+	}
+}
+
+// WithOperationTimeout bounds how long a whole Client call (like a Login or RedeemCode, which are made of several
+// requests) can take
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(client *httpClient) error {
+		client.operationTimeout = timeout
+		return nil
+	}
+}
+
+// WithPolling sets how long the Client first waits before checking the status of a redemption (doubling every time
+// it is still in progress), and how long it keeps checking before giving up
+func WithPolling(interval, timeout time.Duration) Option {
+	return func(client *httpClient) error {
+		if interval <= 0 || timeout <= 0 {
+			return errors.New("poll interval and timeout must be positive")
+		}
+		client.pollInterval = interval
+		client.pollTimeout = timeout
+		return nil
+	}
+}
+
+// WithDelay sets how long the Client pauses before submitting forms, to mimic a human
+func WithDelay(delay time.Duration) Option {
+	return func(client *httpClient) error {
+		client.delay = delay
+		return nil
+	}
+}
+
+// WithBaseURL points the Client at a different SHiFT website, such as the fake server provided by shifttest
+func WithBaseURL(rawURL string) Option {
+	return func(client *httpClient) error {
+		baseURL, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		if baseURL.Scheme == "" || baseURL.Host == "" {
+			return errors.New("base url must be absolute: " + rawURL)
+		}
+		client.baseURL = baseURL
+		return nil
+	}
+}
+
 var defaultHeaders = http.Header{
 	"User-Agent":                []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/119.0"},
 	"Accept":                    []string{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
@@ -40,7 +297,7 @@ func readAsHTML(resp http.Response) (*goquery.Document, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, errors.New("invalid response code")
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
@@ -69,27 +326,102 @@ func readAsJson(resp http.Response) (map[string]any, error) {
 }
 
 type httpClient struct {
-	client  http.Client
-	headers http.Header
+	client           http.Client
+	jar              *sessionJar
+	headers          http.Header
+	baseURL          *url.URL
+	operationTimeout time.Duration
+	delay            time.Duration
+	pollInterval     time.Duration
+	pollTimeout      time.Duration
+	limiter          *Limiter
+	account          string
+	maxRetries       int
+	retryBackoff     time.Duration
 }
 
-func newHttpClient(cookies []*http.Cookie) (*httpClient, error) {
-	jar, err := cookiejar.New(nil)
+func newHttpClient(cookies []*http.Cookie, opts ...Option) (*httpClient, error) {
+	cookieJar, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, errors.New("failed to setup cookies")
 	}
-	jar.SetCookies(GearboxURL, cookies)
+	jar := newSessionJar(cookieJar)
 
-	return &httpClient{
-		http.Client{
-			Jar: jar,
+	client := &httpClient{
+		client: http.Client{
+			Jar:     jar,
+			Timeout: DefaultRequestTimeout,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				// Don't follow redirects automatically - we want to handle them manually
 				return http.ErrUseLastResponse
 			},
 		},
-		defaultHeaders,
-	}, nil
+		jar:              jar,
+		headers:          defaultHeaders,
+		baseURL:          GearboxURL,
+		operationTimeout: DefaultOperationTimeout,
+		delay:            DefaultDelay,
+		pollInterval:     DefaultPollInterval,
+		pollTimeout:      DefaultPollTimeout,
+		maxRetries:       DefaultMaxRetries,
+		retryBackoff:     DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		if err = opt(client); err != nil {
+			return nil, err
+		}
+	}
+	jar.SetCookies(client.baseURL, cookies)
+
+	return client, nil
+}
+
+// operation bounds a Client call by the operation timeout
+func (client *httpClient) operation(ctx context.Context) (context.Context, context.CancelFunc) {
+	if client.operationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, client.operationTimeout)
+}
+
+// pause waits for the configured delay, unless the context is cancelled first
+func (client *httpClient) pause(ctx context.Context) error {
+	return sleep(ctx, client.delay)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// url resolves a path (or a Location header returned by SHiFT) against the base URL. Paths are kept under the base
+// URL's path, so with a base URL of http://proxy/shift/, /home is http://proxy/shift/home
+func (client *httpClient) url(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return client.baseURL.String() + ref
+	}
+	if u.IsAbs() || u.Host != "" {
+		return client.baseURL.ResolveReference(u).String()
+	}
+	// a Location header from a proxy might already be under the base URL's path
+	prefix := strings.TrimSuffix(client.baseURL.Path, "/")
+	if prefix != "" && (u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")) {
+		return client.baseURL.ResolveReference(u).String()
+	}
+	joined := client.baseURL.JoinPath(u.Path)
+	joined.RawQuery = u.RawQuery
+	joined.Fragment = u.Fragment
+	return joined.String()
 }
 
 func (client *httpClient) do(req *http.Request, headers map[string]string) (*http.Response, error) {
@@ -101,7 +433,80 @@ func (client *httpClient) do(req *http.Request, headers map[string]string) (*htt
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
-	return client.client.Do(req)
+
+	for attempt := 0; ; attempt++ {
+		if client.limiter != nil {
+			if err := client.limiter.Wait(req.Context(), client.account); err != nil {
+				return nil, err
+			}
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := client.client.Do(req)
+		if err != nil || !throttled(resp.StatusCode) {
+			return resp, err
+		}
+
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		// a 503 is only rate limiting if SHiFT says when to come back; otherwise it's more likely down for maintenance
+		refused := resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode == http.StatusServiceUnavailable && retryAfter > 0)
+		// a proxy's 502 or 504 doesn't say whether SHiFT processed a form, so sending it again could redeem a code (or
+		// log in) twice. Forms are only resent if SHiFT refused them
+		if req.Method != http.MethodGet && !refused {
+			return resp, nil
+		}
+		if attempt >= client.maxRetries || retryAfter > maxRetryWait {
+			if refused {
+				resp.Body.Close()
+				return nil, &RateLimitedError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+			}
+			// other server errors are left for the caller to interpret
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if err = sleep(req.Context(), client.backoff(attempt, retryAfter)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// throttled reports whether a response status means SHiFT is throttling us or overloaded, and it's worth retrying
+func throttled(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before retrying: what SHiFT asked for if it did, otherwise an exponential backoff.
+// Either way it is jittered, so many clients don't retry in lockstep
+func (client *httpClient) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter + rand.N(retryAfter/4+1)
+	}
+	wait := min(client.retryBackoff<<attempt, maxRetryWait)
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
 }
 
 func setsRequiredCookies(resp *http.Response) bool {
@@ -110,28 +515,51 @@ func setsRequiredCookies(resp *http.Response) bool {
 	return len(ParseRequiredCookies(cookies)) == 2
 }
 
+// ParseRequiredCookies picks the si and _session_id cookies out of cookie strings copied from a browser. Attributes
+// following a cookie are kept if they say when it expires, whether they're in the same string or split into their own
 func ParseRequiredCookies(cookies []string) []*http.Cookie {
 	var newCookies []*http.Cookie
 
+	// the required cookie that attributes currently apply to
+	var current *http.Cookie
 	for _, cookie := range cookies {
-		cookie = strings.TrimSpace(cookie)
-		if strings.HasPrefix(cookie, "_session_id=") || strings.HasPrefix(cookie, "si=") {
-			first := strings.Split(cookie, ";")
-			if len(first) < 1 {
+		for _, part := range strings.Split(cookie, ";") {
+			part = strings.TrimSpace(part)
+			name, value, found := strings.Cut(part, "=")
+			if strings.HasPrefix(part, "_session_id=") || strings.HasPrefix(part, "si=") {
+				if strings.Contains(value, "=") {
+					current = nil
+					continue
+				}
+				current = &http.Cookie{Name: name, Value: value}
+				newCookies = append(newCookies, current)
 				continue
 			}
-			items := strings.Split(first[0], "=")
-			if len(items) != 2 {
-				continue
+			switch strings.ToLower(name) {
+			case "expires":
+				if current != nil && found {
+					if expires, err := http.ParseTime(value); err == nil {
+						current.Expires = expires
+					}
+				}
+			case "max-age":
+				if current != nil && found {
+					if seconds, err := strconv.Atoi(value); err == nil {
+						current.Expires = time.Now().Add(time.Duration(seconds) * time.Second)
+					}
+				}
+			case "path", "domain", "secure", "httponly", "samesite", "partitioned", "":
+			default:
+				// another cookie, so any attributes that follow aren't for the required ones
+				current = nil
 			}
-			newCookies = append(newCookies, &http.Cookie{Name: items[0], Value: items[1]})
 		}
 	}
 	return newCookies
 }
 
-func (client *httpClient) Get(url string, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func (client *httpClient) Get(ctx context.Context, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", client.url(url), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -145,24 +573,24 @@ func (client *httpClient) Get(url string, headers map[string]string) (*http.Resp
 	return resp, nil
 }
 
-func (client *httpClient) GetAsHTML(url string, headers map[string]string) (*goquery.Document, error) {
-	resp, err := client.Get(url, headers)
+func (client *httpClient) GetAsHTML(ctx context.Context, url string, headers map[string]string) (*goquery.Document, error) {
+	resp, err := client.Get(ctx, url, headers)
 	if err != nil {
 		return nil, err
 	}
 	return readAsHTML(*resp)
 }
 
-func (client *httpClient) GetAsJSON(url string, headers map[string]string) (map[string]any, error) {
-	resp, err := client.Get(url, headers)
+func (client *httpClient) GetAsJSON(ctx context.Context, url string, headers map[string]string) (map[string]any, error) {
+	resp, err := client.Get(ctx, url, headers)
 	if err != nil {
 		return nil, err
 	}
 	return readAsJson(*resp)
 }
 
-func (client *httpClient) PostForm(url string, headers map[string]string, data string) (*http.Response, error) {
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(data))
+func (client *httpClient) PostForm(ctx context.Context, url string, headers map[string]string, data string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url(url), bytes.NewBufferString(data))
 	if err != nil {
 		return nil, errors.New("failed to create login request: " + err.Error())
 	}
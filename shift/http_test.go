@@ -1,6 +1,10 @@
 package shift
 
-import "testing"
+import (
+	"net/http"
+	"testing"
+	"time"
+)
 
 func TestParseRequiredCookies(t *testing.T) {
 	cookies := []string{
@@ -26,4 +30,100 @@ func TestParseRequiredCookies(t *testing.T) {
 	if newCookies[1].Value != "session_id_here" {
 		t.Fatalf("Cookie _session_id value mismatch: %s", newCookies[1].Value)
 	}
+	if !newCookies[0].Expires.Equal(time.Date(2026, time.September, 30, 0, 24, 22, 0, time.UTC)) {
+		t.Fatalf("Cookie si expiry mismatch: %s", newCookies[0].Expires)
+	}
+	if !newCookies[1].Expires.IsZero() {
+		t.Fatalf("Cookie _session_id shouldn't have an expiry: %s", newCookies[1].Expires)
+	}
+}
+
+func TestParseRequiredCookies_SplitAttributes(t *testing.T) {
+	// what the login command gets after splitting a pasted cookie string on ;
+	cookies := []string{"other=x", " expires=Thu, 01 Oct 2026 00:00:00 GMT", "si=si_here", "path=/", "expires=Wed, 30 Sep 2026 00:24:22 GMT", "_session_id=session_id_here", "HttpOnly"}
+
+	newCookies := ParseRequiredCookies(cookies)
+
+	if len(newCookies) != 2 {
+		t.Fatal("Expected 2 cookies, got ", len(newCookies))
+	}
+	if !newCookies[0].Expires.Equal(time.Date(2026, time.September, 30, 0, 24, 22, 0, time.UTC)) {
+		t.Fatalf("Cookie si expiry mismatch: %s", newCookies[0].Expires)
+	}
+	if !newCookies[1].Expires.IsZero() {
+		t.Fatalf("Cookie _session_id shouldn't have an expiry: %s", newCookies[1].Expires)
+	}
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	limiter := NewLimiter(Rate{PerSecond: 10, Burst: 3}, Rate{PerSecond: 1, Burst: 2})
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if wait := limiter.reserve("a", now); wait != 0 {
+			t.Fatal("Expected the account's burst to be available, got wait ", wait)
+		}
+	}
+	if wait := limiter.reserve("a", now); wait != time.Second {
+		t.Fatal("Expected the account to wait a second for its next token, got ", wait)
+	}
+	// another account has its own budget, but shares the last global token
+	if wait := limiter.reserve("b", now); wait != 0 {
+		t.Fatal("Expected another account not to wait, got ", wait)
+	}
+	if wait := limiter.reserve("b", now); wait != 100*time.Millisecond {
+		t.Fatal("Expected the global budget to be exhausted, got ", wait)
+	}
+	if wait := limiter.reserve("a", now.Add(time.Second)); wait != 0 {
+		t.Fatal("Expected the account's budget to refill, got ", wait)
+	}
+}
+
+func TestLimiter_Wait(t *testing.T) {
+	limiter := NewLimiter(Rate{}, Rate{PerSecond: 50, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(t.Context(), "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatal("Expected waits to be spaced by the account rate, took ", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Fatal("Expected 2 minutes, got ", d)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d <= 58*time.Second || d > time.Minute {
+		t.Fatal("Expected about a minute, got ", d)
+	}
+	if d := parseRetryAfter("soon"); d != 0 {
+		t.Fatal("Expected unparseable values to be ignored, got ", d)
+	}
+}
+
+func TestURL(t *testing.T) {
+	tests := []struct {
+		base, ref, expected string
+	}{
+		{"https://shift.example.com", "/home", "https://shift.example.com/home"},
+		{"https://shift.example.com", "/home?redirect_to=false", "https://shift.example.com/home?redirect_to=false"},
+		{"http://proxy/shift/", "/home", "http://proxy/shift/home"},
+		{"http://proxy/shift", "/code_redemptions/abc", "http://proxy/shift/code_redemptions/abc"},
+		{"http://proxy/shift/", "/shift/code_redemptions/abc", "http://proxy/shift/code_redemptions/abc"},
+		{"http://proxy/shift/", "https://shift.example.com/home", "https://shift.example.com/home"},
+	}
+	for _, tt := range tests {
+		client, err := newHttpClient(nil, WithBaseURL(tt.base))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u := client.url(tt.ref); u != tt.expected {
+			t.Errorf("Expected %s with base %s to be %s, got %s", tt.ref, tt.base, tt.expected, u)
+		}
+	}
 }
@@ -0,0 +1,67 @@
+package shift
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Offer is one of the redemption forms on the entitlement offer page. A code can have an offer for every
+// combination of game and service (platform) it can be redeemed for
+type Offer struct {
+	Title   string
+	Service Platform
+	// Commit is the label of the form's submit button, like "Redeem for Steam"
+	Commit string
+	// Action is where the form is posted to
+	Action string
+	// Fields are the hidden inputs of the form, including the authenticity token
+	Fields url.Values
+}
+
+// ParseOffers reads every redemption form from the entitlement offer page
+func ParseOffers(doc *goquery.Document) []Offer {
+	var offers []Offer
+	doc.Find("form").Each(func(i int, form *goquery.Selection) {
+		if form.Find("input[name='authenticity_token']").Length() == 0 {
+			return
+		}
+		offer := Offer{
+			Fields: url.Values{},
+		}
+		offer.Action, _ = form.Attr("action")
+		form.Find("input").Each(func(i int, input *goquery.Selection) {
+			name, _ := input.Attr("name")
+			value, _ := input.Attr("value")
+			if name == "" {
+				return
+			}
+			if name == "commit" {
+				offer.Commit = value
+				return
+			}
+			if inputType, _ := input.Attr("type"); strings.EqualFold(inputType, "hidden") {
+				offer.Fields.Set(name, value)
+			}
+		})
+		offer.Title = offer.Fields.Get("archway_code_redemption[title]")
+		offer.Service = Platform(offer.Fields.Get("archway_code_redemption[service]"))
+		offers = append(offers, offer)
+	})
+	return offers
+}
+
+// selectOffer picks the offer for the platform and game. If game is empty, an offer for any game will do
+func selectOffer(offers []Offer, platform Platform, game Game) (Offer, bool) {
+	for _, offer := range offers {
+		if offer.Service != platform {
+			continue
+		}
+		if game != "" && offer.Title != game.Title() {
+			continue
+		}
+		return offer, true
+	}
+	return Offer{}, false
+}
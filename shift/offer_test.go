@@ -0,0 +1,67 @@
+package shift
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const offersHTML = `<div>
+<form action="/code_redemptions" method="post">
+  <input type="hidden" name="authenticity_token" value="token">
+  <input type="hidden" name="archway_code_redemption[check]" value="check_oak">
+  <input type="hidden" name="archway_code_redemption[service]" value="steam">
+  <input type="hidden" name="archway_code_redemption[title]" value="oak">
+  <input type="submit" name="commit" value="Redeem for Steam">
+</form>
+<form action="/code_redemptions" method="post">
+  <input type="hidden" name="authenticity_token" value="token">
+  <input type="hidden" name="archway_code_redemption[check]" value="check_oak2">
+  <input type="hidden" name="archway_code_redemption[service]" value="steam">
+  <input type="hidden" name="archway_code_redemption[title]" value="oak2">
+  <input type="submit" name="commit" value="Redeem for Steam">
+</form>
+<form action="/code_redemptions" method="post">
+  <input type="hidden" name="authenticity_token" value="token">
+  <input type="hidden" name="archway_code_redemption[check]" value="check_oak2">
+  <input type="hidden" name="archway_code_redemption[service]" value="epic">
+  <input type="hidden" name="archway_code_redemption[title]" value="oak2">
+  <input type="submit" name="commit" value="Redeem for Epic">
+</form>
+<form action="/search"><input type="text" name="q"></form>
+</div>`
+
+func TestParseOffers(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(offersHTML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offers := ParseOffers(doc)
+	if len(offers) != 3 {
+		t.Fatal("Expected 3 offers, got ", len(offers))
+	}
+	if offers[2].Service != Epic || offers[2].Title != "oak2" || offers[2].Commit != "Redeem for Epic" {
+		t.Fatalf("Unexpected offer parsed: %+v", offers[2])
+	}
+	if offers[0].Fields.Get("authenticity_token") != "token" {
+		t.Fatal("Expected hidden fields to be parsed")
+	}
+
+	offer, ok := selectOffer(offers, Steam, Borderlands4)
+	if !ok {
+		t.Fatal("Expected an offer for Borderlands 4 on Steam")
+	}
+	if offer.Fields.Get("archway_code_redemption[check]") != "check_oak2" {
+		t.Fatal("Expected the Borderlands 4 form, got ", offer.Fields.Get("archway_code_redemption[check]"))
+	}
+	_, ok = selectOffer(offers, PSN, Borderlands4)
+	if ok {
+		t.Fatal("Expected no offer for PSN")
+	}
+	_, ok = selectOffer(offers, Epic, Borderlands3)
+	if ok {
+		t.Fatal("Expected no offer for Borderlands 3 on Epic")
+	}
+}
@@ -1,5 +1,7 @@
 package shift
 
+import "errors"
+
 type ResponseType int
 
 const (
@@ -8,6 +10,8 @@ const (
 	Invalid
 	Expired
 	Link2KAccount
+	NotAvailable
+	Pending // SHiFT never finished processing the redemption
 	Unrecognized
 )
 
@@ -17,6 +21,9 @@ const (
 	EXPIRED          = "This SHiFT code has expired"
 	SUCCESS          = "Your code was successfully redeemed"
 	LINK2K           = "To redeem this SHiFT code, please link your 2K account."
+	// NOT_AVAILABLE isn't a message from SHiFT; it's recorded when SHiFT doesn't offer a code for the requested
+	// platform and game
+	NOT_AVAILABLE = "This SHiFT code is not available for your platform"
 )
 
 func DetermineResponseType(input string) ResponseType {
@@ -31,7 +38,68 @@ func DetermineResponseType(input string) ResponseType {
 		return Invalid
 	case LINK2K:
 		return Link2KAccount
+	case NOT_AVAILABLE:
+		return NotAvailable
 	default:
 		return Unrecognized
 	}
 }
+
+// Status returns the SHiFT message for a response type, which is what gets recorded for redemptions.
+// Unrecognized responses have no status
+func (r ResponseType) Status() string {
+	switch r {
+	case Success:
+		return SUCCESS
+	case AlreadyRedeemed:
+		return ALREADY_REDEEMED
+	case Expired:
+		return EXPIRED
+	case Invalid:
+		return NOT_EXIST
+	case Link2KAccount:
+		return LINK2K
+	case NotAvailable:
+		return NOT_AVAILABLE
+	default:
+		return ""
+	}
+}
+
+// RedeemResult is everything we learned from an attempt to redeem a code
+type RedeemResult struct {
+	Type ResponseType
+	// Message is the raw text SHiFT responded with, which may not be recognized
+	Message string
+	// Title is the game title from the redemption form (oak2 for Borderlands 4, for example)
+	Title    string
+	Platform Platform
+	// StatusCodes is the HTTP status of every request made during the redemption, in order
+	StatusCodes []int
+	// Retryable indicates the redemption didn't reach a final outcome, and trying again later may succeed
+	Retryable bool
+	// Rewards is the rewards page from just before the code was redeemed, or nil if it wasn't loaded
+	Rewards *RewardsSnapshot
+}
+
+// Final reports whether the result is a recognized outcome from SHiFT that is worth recording
+func (r RedeemResult) Final() bool {
+	return r.Type.Status() != ""
+}
+
+// record adds the status of a response to the result, and marks it retryable if SHiFT was throttling or unavailable
+func (r *RedeemResult) record(statusCode int) {
+	r.StatusCodes = append(r.StatusCodes, statusCode)
+	if statusCode == 429 || statusCode >= 500 {
+		r.Retryable = true
+	}
+}
+
+// fail marks the result retryable after a request error, recording the status SHiFT throttled us with if there was one
+func (r *RedeemResult) fail(err error) {
+	var rateLimited *RateLimitedError
+	if errors.As(err, &rateLimited) {
+		r.record(rateLimited.StatusCode)
+	}
+	r.Retryable = true
+}
@@ -1,9 +1,148 @@
 package shift
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
 const GoldenKey = "Golden Key for Borderlands 4"
 
+// GoldenKeys returns how many golden keys a reward with the title is worth. Most are a single key, but some lead with a
+// count, like "5 Golden Keys"
+func GoldenKeys(title string) int {
+	if !strings.Contains(strings.ToLower(title), "golden key") {
+		return 0
+	}
+	count, _, found := strings.Cut(strings.TrimSpace(title), " ")
+	if n, err := strconv.Atoi(count); found && err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// rewardDateLayouts are the layouts SHiFT shows unlock dates in, most likely first
+var rewardDateLayouts = []string{"Jan 2, 2006", "January 2, 2006", "Jan 02, 2006", "2006-01-02", "01/02/2006"}
+
 type Reward struct {
-	Title       string
-	Date        string // UTC time? - 8:00+PST redemption was after midnight because it displayed the next day
+	Title string
+	// Date is the day the reward was unlocked, at midnight UTC, or zero if SHiFT's date couldn't be parsed. SHiFT shows
+	// days in UTC: a code redeemed after 4pm PST shows as unlocked the next day
+	Date        time.Time
 	Description string
 }
+
+// ParseRewardDate parses the unlock date shown next to a reward, like "Unlocked Sep 30, 2025", into midnight UTC on that
+// day
+func ParseRewardDate(text string) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if len(text) >= len("unlocked") && strings.EqualFold(text[:len("unlocked")], "unlocked") {
+		text = strings.TrimSpace(strings.TrimPrefix(text[len("unlocked"):], ":"))
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	for _, layout := range rewardDateLayouts {
+		if date, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized reward date %q", text)
+}
+
+// RewardEntry is a reward listed on the rewards page, along with the tab and section it was listed under
+type RewardEntry struct {
+	Platform Platform
+	Game     Game
+	Reward
+}
+
+// RewardsSnapshot is everything listed on the rewards page at one point in time, for every platform and game
+type RewardsSnapshot struct {
+	// entries in page order, so newest first within each platform and game
+	Entries []RewardEntry
+}
+
+// Rewards returns the rewards for a platform and game, newest first
+func (s *RewardsSnapshot) Rewards(platform Platform, game Game) []Reward {
+	var rewards []Reward
+	for _, entry := range s.Entries {
+		if entry.Platform == platform && entry.Game == game {
+			rewards = append(rewards, entry.Reward)
+		}
+	}
+	return rewards
+}
+
+// Diff returns the entries that appeared since the before snapshot. Entries are matched by platform, game, title,
+// unlock date and description, so a reward that's listed twice only counts as new if it's listed more times than before
+func (s *RewardsSnapshot) Diff(before *RewardsSnapshot) []RewardEntry {
+	seen := map[RewardEntry]int{}
+	if before != nil {
+		for _, entry := range before.Entries {
+			seen[entry]++
+		}
+	}
+	var added []RewardEntry
+	for _, entry := range s.Entries {
+		if seen[entry] > 0 {
+			seen[entry]--
+			continue
+		}
+		added = append(added, entry)
+	}
+	return added
+}
+
+// Rewards loads the rewards page once, and returns everything listed on it
+func (client *Client) Rewards(ctx context.Context) (*RewardsSnapshot, error) {
+	ctx, cancel := client.hClient.operation(ctx)
+	defer cancel()
+
+	if !client.hasCookies {
+		return nil, errors.New("no cookies found, login client before attempting to load rewards")
+	}
+	doc, err := client.hClient.GetAsHTML(ctx, REWARDS, map[string]string{})
+	if err != nil {
+		return nil, err
+	}
+	return ParseRewards(doc), nil
+}
+
+// ParseRewards reads every reward from the rewards page. Rewards for games that aren't known are skipped
+func ParseRewards(doc *goquery.Document) *RewardsSnapshot {
+	snapshot := &RewardsSnapshot{}
+	for _, platform := range Platforms {
+		var game Game
+		known := false
+		selector := fmt.Sprintf("div.tab-pane.well#%s div.sh_reward_list", string(platform))
+		doc.Find(selector).Children().Each(func(i int, s *goquery.Selection) {
+			if s.HasClass("shift-secondary-title") {
+				// Update current game context
+				game, known = GameFromRewardsHeader(strings.TrimSpace(s.Find("h2").Text()))
+			} else if goquery.NodeName(s) == "dl" && known {
+				// Parse a reward
+				title := strings.TrimSpace(s.Find("dt").Text())
+				// a date that can't be parsed isn't worth losing the reward over
+				date, _ := ParseRewardDate(s.Find("dd .reward_unlocked").Text())
+
+				dd := s.Find("dd").Clone()
+				dd.Find(".reward_unlocked").Remove()
+				description := strings.TrimSpace(dd.Text())
+				snapshot.Entries = append(snapshot.Entries, RewardEntry{
+					Platform: platform,
+					Game:     game,
+					Reward: Reward{
+						Title:       title,
+						Date:        date,
+						Description: description,
+					},
+				})
+			}
+		})
+	}
+	return snapshot
+}
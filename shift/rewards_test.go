@@ -0,0 +1,105 @@
+package shift
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestRewardsSnapshot_Diff(t *testing.T) {
+	day := time.Date(2025, time.September, 30, 0, 0, 0, 0, time.UTC)
+	key := RewardEntry{Platform: Steam, Game: Borderlands4, Reward: Reward{Title: GoldenKey, Date: day, Description: "Unlock a golden chest"}}
+	skin := RewardEntry{Platform: Steam, Game: Borderlands4, Reward: Reward{Title: "Skin", Date: day, Description: "A skin"}}
+	otherPlatform := key
+	otherPlatform.Platform = XboxLive
+
+	before := &RewardsSnapshot{Entries: []RewardEntry{key, skin}}
+	after := &RewardsSnapshot{Entries: []RewardEntry{key, key, skin, otherPlatform}}
+
+	added := after.Diff(before)
+	if len(added) != 2 {
+		t.Fatal("Expected the second golden key and the xbox one to be new, got ", added)
+	}
+	if added[0] != key || added[1] != otherPlatform {
+		t.Fatal("Unexpected new entries ", added)
+	}
+	if len(before.Diff(after)) != 0 {
+		t.Fatal("Expected nothing new when rewards disappear")
+	}
+	if len(after.Diff(nil)) != len(after.Entries) {
+		t.Fatal("Expected everything to be new without a snapshot to compare to")
+	}
+}
+
+func TestParseRewards(t *testing.T) {
+	file, err := os.Open("testdata/rewards.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := ParseRewards(doc)
+	if len(snapshot.Entries) != 4 {
+		t.Fatal("Expected 4 rewards for known games, got ", snapshot.Entries)
+	}
+	keys := snapshot.Rewards(Steam, Borderlands4)
+	if len(keys) != 2 {
+		t.Fatal("Expected 2 Borderlands 4 rewards on steam, got ", keys)
+	}
+	if keys[0].Title != GoldenKey || keys[0].Description != "Unlock a golden chest in Borderlands 4" {
+		t.Fatal("Unexpected reward ", keys[0])
+	}
+	if !keys[0].Date.Equal(time.Date(2025, time.October, 3, 0, 0, 0, 0, time.UTC)) || keys[0].Date.Location() != time.UTC {
+		t.Fatal("Expected Oct 3, 2025 UTC, got ", keys[0].Date)
+	}
+	if !keys[1].Date.Equal(time.Date(2025, time.September, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("Expected Sep 12, 2025 UTC, got ", keys[1].Date)
+	}
+	bl3 := snapshot.Rewards(Steam, Borderlands3)
+	if len(bl3) != 1 || bl3[0].Title != "Diamond Key" {
+		t.Fatal("Expected the Borderlands 3 reward, got ", bl3)
+	}
+	xbox := snapshot.Rewards(XboxLive, Borderlands4)
+	if len(xbox) != 1 || xbox[0] != keys[0] {
+		t.Fatal("Expected the same key on xbox, got ", xbox)
+	}
+}
+
+func TestParseRewardDate(t *testing.T) {
+	want := time.Date(2025, time.September, 30, 0, 0, 0, 0, time.UTC)
+	for _, text := range []string{"Sep 30, 2025", "Unlocked Sep 30, 2025", " unlocked:  September 30, 2025 ", "2025-09-30", "09/30/2025"} {
+		date, err := ParseRewardDate(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !date.Equal(want) {
+			t.Fatalf("Expected %s from %q, got %s", want, text, date)
+		}
+	}
+	if _, err := ParseRewardDate("yesterday"); err == nil {
+		t.Fatal("Expected error for an unrecognized date")
+	}
+}
+
+func TestGoldenKeys(t *testing.T) {
+	tests := map[string]int{
+		GoldenKey:                 1,
+		"5 Golden Keys":           5,
+		"Golden Key":              1,
+		"3 golden keys for BL3":   3,
+		"Diamond Key":             0,
+		"Vault Hunter Skin":       0,
+		"Borderlands Golden Key!": 1,
+	}
+	for title, want := range tests {
+		if got := GoldenKeys(title); got != want {
+			t.Errorf("Expected %d keys for %q, got %d", want, title, got)
+		}
+	}
+}
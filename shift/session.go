@@ -0,0 +1,172 @@
+package shift
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+type SessionState string
+
+const (
+	SessionValid        SessionState = "valid"
+	SessionExpiringSoon SessionState = "expiring_soon"
+	SessionInvalid      SessionState = "invalid"
+
+	// SessionExpiryWarning is how long before the si cookie expires that a session counts as expiring soon
+	SessionExpiryWarning = 3 * 24 * time.Hour
+)
+
+// Session is the health of the session a Client was created with
+type Session struct {
+	State SessionState
+	// Expires is when the si cookie expires, or zero if it wasn't known
+	Expires time.Time
+	// Rewards is the rewards page the check loaded, or nil if the session is invalid
+	Rewards *RewardsSnapshot
+}
+
+// SessionStatus checks whether SHiFT still accepts the client's session, by loading the rewards page and checking it
+// wasn't redirected to the login page. An error means the check couldn't be made, not that the session is invalid
+func (client *Client) SessionStatus(ctx context.Context) (Session, error) {
+	ctx, cancel := client.hClient.operation(ctx)
+	defer cancel()
+
+	session := Session{State: SessionInvalid, Expires: client.sessionExpires}
+	if !client.hasCookies {
+		return session, nil
+	}
+	if !session.Expires.IsZero() && time.Now().After(session.Expires) {
+		return session, nil
+	}
+
+	resp, err := client.hClient.Get(ctx, REWARDS, nil)
+	if err != nil {
+		return session, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if strings.Contains(location, HOME) || strings.Contains(location, SESSIONS) {
+			return session, nil
+		}
+		return session, errors.New("rewards page redirected elsewhere: " + location)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return session, nil
+	}
+	doc, err := readAsHTML(*resp)
+	if err != nil {
+		return session, err
+	}
+	if !hasRewardsTab(doc) {
+		return session, nil
+	}
+
+	session.State = SessionValid
+	session.Rewards = ParseRewards(doc)
+	// SHiFT may have sent a new si cookie along with the page
+	if expires := sessionExpiry(client.DumpCookies()); !expires.IsZero() {
+		session.Expires = expires
+	}
+	if !session.Expires.IsZero() && time.Until(session.Expires) < SessionExpiryWarning {
+		session.State = SessionExpiringSoon
+	}
+	return session, nil
+}
+
+// hasRewardsTab reports whether a page is the rewards page of a logged in user, rather than the login form
+func hasRewardsTab(doc *goquery.Document) bool {
+	return doc.Find("div.tab-pane.well div.sh_reward_list").Length() > 0
+}
+
+// sessionExpiry returns when the si cookie expires, or zero if it doesn't say
+func sessionExpiry(cookies []*http.Cookie) time.Time {
+	for _, cookie := range cookies {
+		if cookie.Name == "si" {
+			return cookie.Expires
+		}
+	}
+	return time.Time{}
+}
+
+// sessionJar is a cookie jar that remembers when the si and _session_id cookies expire, which a cookiejar.Jar keeps
+// to itself
+type sessionJar struct {
+	*cookiejar.Jar
+
+	lock    sync.Mutex
+	expires map[string]time.Time // keyed by cookie name + value
+}
+
+func newSessionJar(jar *cookiejar.Jar) *sessionJar {
+	return &sessionJar{Jar: jar, expires: map[string]time.Time{}}
+}
+
+func (jar *sessionJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.lock.Lock()
+	for _, cookie := range cookies {
+		if !requiredCookie(cookie.Name) {
+			continue
+		}
+		if !cookie.Expires.IsZero() {
+			jar.expires[cookie.Name+"="+cookie.Value] = cookie.Expires
+		} else if cookie.MaxAge > 0 {
+			jar.expires[cookie.Name+"="+cookie.Value] = time.Now().Add(time.Duration(cookie.MaxAge) * time.Second)
+		}
+	}
+	jar.lock.Unlock()
+	jar.Jar.SetCookies(u, cookies)
+}
+
+// dump returns the jar's cookies for the url, with the expiry of the required ones filled in
+func (jar *sessionJar) dump(u *url.URL) []*http.Cookie {
+	jar.lock.Lock()
+	defer jar.lock.Unlock()
+
+	cookies := jar.Jar.Cookies(u)
+	for _, cookie := range cookies {
+		cookie.Expires = jar.expires[cookie.Name+"="+cookie.Value]
+	}
+	return cookies
+}
+
+func requiredCookie(name string) bool {
+	return name == "si" || name == "_session_id"
+}
+
+// RotatedCookies compares the required cookies a session was started with to the ones it ended with. If SHiFT rotated
+// any of them, it returns the current si and _session_id cookies and true
+func RotatedCookies(stored, current []*http.Cookie) ([]*http.Cookie, bool) {
+	var required []*http.Cookie
+	for _, cookie := range current {
+		if requiredCookie(cookie.Name) {
+			required = append(required, cookie)
+		}
+	}
+	// never replace a complete session with an incomplete one
+	if len(required) != 2 {
+		return nil, false
+	}
+	for _, cookie := range required {
+		found := false
+		for _, old := range stored {
+			if old.Name == cookie.Name && old.Value == cookie.Value && old.Expires.Equal(cookie.Expires) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return required, true
+		}
+	}
+	return nil, false
+}
@@ -2,6 +2,7 @@ package shift
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -24,6 +25,18 @@ const (
 	PSN      Platform = "psn"
 )
 
+// Platforms is every platform codes can be redeemed for
+var Platforms = []Platform{Steam, Epic, XboxLive, PSN}
+
+func ValidPlatform(p string) bool {
+	for _, platform := range Platforms {
+		if string(platform) == p {
+			return true
+		}
+	}
+	return false
+}
+
 func ToPretty(p Platform) string {
 	switch p {
 	case Total:
@@ -40,44 +53,30 @@ func ToPretty(p Platform) string {
 	return ""
 }
 
-//type Title string
-//
-//const (
-//	BL4 Title = "oak2"
-//)
-
-type Game string
-
-const (
-	Borderlands4 Game = "Borderlands 4"
-)
-
-func ValidGame(g string) bool {
-	switch g {
-	case string(Borderlands4):
-		return true
-	}
-	return false
-}
-
 type Client struct {
 	hClient    httpClient
 	hasCookies bool
+	// when the si cookie the client was created with expires, if known
+	sessionExpires time.Time
 }
 
-func NewClient(cookies []*http.Cookie) (*Client, error) {
-	hClient, err := newHttpClient(cookies)
+func NewClient(cookies []*http.Cookie, opts ...Option) (*Client, error) {
+	hClient, err := newHttpClient(cookies, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
 		*hClient,
 		cookies != nil && len(cookies) > 1, // require both the si and _session_id headers
+		sessionExpiry(cookies),
 	}, nil
 }
 
-func (client *Client) Login(email string, password string) error {
-	doc, err := client.hClient.GetAsHTML(HOME, nil)
+func (client *Client) Login(ctx context.Context, email string, password string) error {
+	ctx, cancel := client.hClient.operation(ctx)
+	defer cancel()
+
+	doc, err := client.hClient.GetAsHTML(ctx, HOME, nil)
 	if err != nil {
 		return err
 	}
@@ -88,7 +87,9 @@ func (client *Client) Login(email string, password string) error {
 	}
 
 	// Add a small delay to mimic human behavior
-	time.Sleep(1 * time.Second)
+	if err = client.hClient.pause(ctx); err != nil {
+		return err
+	}
 
 	// Prepare form data using proper URL encoding
 	formValues := url.Values{}
@@ -101,16 +102,17 @@ func (client *Client) Login(email string, password string) error {
 	formData := formValues.Encode()
 
 	headers := map[string]string{
-		"Referer": HOME,
+		"Referer": client.hClient.url(HOME),
 	}
-	resp, err := client.hClient.PostForm(SESSIONS, headers, formData)
-	if err != nil {
+	resp, err := client.hClient.PostForm(ctx, SESSIONS, headers, formData)
+	if errors.Is(err, ErrRateLimited) {
+		return fmt.Errorf("SHiFT login service is temporarily unavailable. This may be due to rate limiting, maintenance, or the service being overloaded. Please try again later: %w", err)
+	} else if err != nil {
 		return errors.New("failed to submit login credentials: " + err.Error())
 	}
 	defer resp.Body.Close()
 
-	// Check for successful login (should be a redirect)
-	if resp.StatusCode == 503 {
+	if resp.StatusCode == http.StatusServiceUnavailable {
 		return errors.New("SHiFT login service is temporarily unavailable (503). This may be due to rate limiting, maintenance, or the service being overloaded. Please try again later.")
 	}
 
@@ -177,24 +179,50 @@ func (client *Client) Login(email string, password string) error {
 	return errors.New("failed to extract session cookie")
 }
 
+// Ping checks that SHiFT is up by loading the home page, which doesn't need a session
+func (client *Client) Ping(ctx context.Context) error {
+	ctx, cancel := client.hClient.operation(ctx)
+	defer cancel()
+
+	resp, err := client.hClient.Get(ctx, HOME, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// DumpCookies returns the client's current cookies, including any SHiFT has rotated since the client was created. The
+// si and _session_id cookies keep their expiry, when it's known
 func (client *Client) DumpCookies() []*http.Cookie {
-	return client.hClient.client.Jar.Cookies(GearboxURL)
+	return client.hClient.jar.dump(client.hClient.baseURL)
 }
 
-func (client *Client) RedeemCode(code string, platform Platform) (string, error) {
+// RedeemCode redeems a code for the game on the platform. If game is empty, the code is redeemed for whichever game
+// SHiFT offers it for
+func (client *Client) RedeemCode(ctx context.Context, code string, game Game, platform Platform) (RedeemResult, error) {
+	ctx, cancel := client.hClient.operation(ctx)
+	defer cancel()
+
+	result := RedeemResult{Type: Unrecognized, Platform: platform}
 	if !client.hasCookies {
-		return "", errors.New("no cookies found, login client before attempting to redeem code")
+		return result, errors.New("no cookies found, login client before attempting to redeem code")
 	}
 	headers := map[string]string{}
-	doc, err := client.hClient.GetAsHTML(REWARDS, headers)
+	doc, err := client.getAsHTML(ctx, &result, REWARDS, headers)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	csrfToken, exists := doc.Find("meta[name='csrf-token']").Attr("content")
+	_, exists := doc.Find("meta[name='csrf-token']").Attr("content")
 	if !exists {
-		return "", errors.New("failed to find csrf token in redemption form")
+		return result, errors.New("failed to find csrf token in redemption form")
 	}
+	// the page is loaded anyway, so it doubles as the snapshot to find the redeemed reward against
+	result.Rewards = ParseRewards(doc)
 
 	// override all headers to be clear about what's required/expected
 	headers = map[string]string{
@@ -203,45 +231,52 @@ func (client *Client) RedeemCode(code string, platform Platform) (string, error)
 		"X-Requested-With": "XMLHttpRequest",
 	}
 
-	doc, err = client.hClient.GetAsHTML(ENTITLEMENT+"?code="+code, headers)
+	doc, err = client.getAsHTML(ctx, &result, ENTITLEMENT+"?code="+code, headers)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	csrfToken, exists = doc.Find("input[name='authenticity_token']").Attr("value")
-	if !exists {
+	offers := ParseOffers(doc)
+	if len(offers) == 0 {
 		text := strings.TrimSpace(doc.Text())
-		respType := DetermineResponseType(text)
-		if respType != Unrecognized {
-			return text, nil
+		result.Message = text
+		result.Type = DetermineResponseType(text)
+		if result.Type != Unrecognized {
+			return result, nil
 		} else {
 			log.Println("Undetected response message when authenticity token is not found:")
 			log.Println(text)
 		}
-		return "", errors.New("failed to find authenticity token in code redemption form")
+		return result, errors.New("failed to find authenticity token in code redemption form")
 	}
-	check, exists := doc.Find("input[name='archway_code_redemption[check]']").Attr("value")
-	if !exists {
-		return "", errors.New("failed to find archway_code_redemption[check] in form")
+	offer, ok := selectOffer(offers, platform, game)
+	if !ok {
+		result.Type = NotAvailable
+		result.Message = NOT_AVAILABLE
+		return result, nil
 	}
-
-	game, exists := doc.Find("input[name='archway_code_redemption[title]']").Attr("value")
-	if !exists {
-		return "", errors.New("failed to find archway_code_redemption[title] in form")
+	if offer.Fields.Get("archway_code_redemption[check]") == "" {
+		return result, errors.New("failed to find archway_code_redemption[check] in form")
+	}
+	if offer.Title == "" {
+		return result, errors.New("failed to find archway_code_redemption[title] in form")
 	}
+	result.Title = offer.Title
 
-	time.Sleep(1 * time.Second)
+	if err = client.hClient.pause(ctx); err != nil {
+		result.Retryable = true
+		return result, err
+	}
 
-	// Prepare form data using proper URL encoding
+	// Submit the offer's form as-is, as a browser would
 	formValues := url.Values{}
-	formValues.Set("utf8", "✓")
-	formValues.Set("authenticity_token", csrfToken)
-	formValues.Set("archway_code_redemption[code]", code)
-	formValues.Set("archway_code_redemption[service]", string(platform))
-	formValues.Set("archway_code_redemption[check]", check)
-	formValues.Set("archway_code_redemption[title]", game)
-	// TODO how does this work for other platforms besides Steam/PSN
-	formValues.Set("commit", commitMessage(platform))
+	for k, v := range offer.Fields {
+		formValues[k] = v
+	}
+	if formValues.Get("archway_code_redemption[code]") == "" {
+		formValues.Set("archway_code_redemption[code]", code)
+	}
+	formValues.Set("commit", offer.Commit)
 
 	formData := formValues.Encode()
 
@@ -249,102 +284,116 @@ func (client *Client) RedeemCode(code string, platform Platform) (string, error)
 		//"X-CSRF-TOKEN": csrfToken,
 		//"Referer": REWARDS,
 	}
-	resp, err := client.hClient.PostForm(REDEMPTIONS, headers, formData)
+	action := offer.Action
+	if action == "" {
+		action = REDEMPTIONS
+	}
+	resp, err := client.hClient.PostForm(ctx, action, headers, formData)
 	if err != nil {
-		return "", err
+		result.fail(err)
+		return result, err
 	}
+	resp.Body.Close()
+	result.record(resp.StatusCode)
 
-	if resp.StatusCode == 302 {
-		location := resp.Header.Get("Location")
+	if resp.StatusCode != 302 {
+		return result, fmt.Errorf("unexpected code redemption response: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	location := resp.Header.Get("Location")
 
-		if bytes.Contains([]byte(location), []byte("?redirect_to=false")) {
-			return "", errors.New("redeem code failed (redirected back to redeem page)")
-		}
+	if bytes.Contains([]byte(location), []byte("?redirect_to=false")) {
+		return result, errors.New("redeem code failed (redirected back to redeem page)")
+	}
+	if location == "" {
+		return result, errors.New("code redemption redirect is missing a location")
+	}
 
-		// If it's a redirect to somewhere else, it's likely successful
-		if location != "" {
-			headers = map[string]string{
-				//"X-CSRF-TOKEN":     csrfToken,
-				//"Referer":          REWARDS,
-				"X-Requested-With": "XMLHttpRequest",
-			}
+	// If it's a redirect to somewhere else, it's likely successful
+	headers = map[string]string{
+		//"X-CSRF-TOKEN":     csrfToken,
+		//"Referer":          REWARDS,
+		"X-Requested-With": "XMLHttpRequest",
+	}
+
+	return client.pollRedemption(ctx, result, location, headers)
+}
 
-			// attempt to mitigate issue where sometimes the json response is "in_progress"
-			time.Sleep(1 * time.Second)
+// pollRedemption polls the status of a redemption until SHiFT reports it is no longer in progress, backing off
+// exponentially between polls. If it never resolves within the poll timeout, the result is Pending
+func (client *Client) pollRedemption(ctx context.Context, result RedeemResult, location string, headers map[string]string) (RedeemResult, error) {
+	deadline := time.Now().Add(client.hClient.pollTimeout)
+	wait := client.hClient.pollInterval
+	for {
+		if err := sleep(ctx, wait); err != nil {
+			result.Retryable = true
+			return result, err
+		}
 
-			js, err := client.hClient.GetAsJSON(location, headers)
-			if err != nil {
-				return "", err
-			}
-			//log.Println(js)
-			if text, ok := js["text"]; ok {
-				respType := DetermineResponseType(text.(string))
-				if respType != Unrecognized {
-					return text.(string), nil
-				} else {
-					log.Println("Undetected response message after posting code redemption")
-					log.Println(text.(string))
-				}
-				return text.(string), nil
-			} else {
-				log.Println(js)
-				return "", errors.New("failed to read json text status returned from code redemption")
+		resp, err := client.hClient.Get(ctx, location, headers)
+		if err != nil {
+			result.fail(err)
+			return result, err
+		}
+		result.record(resp.StatusCode)
+		js, err := readAsJson(*resp)
+		if err != nil {
+			return result, err
+		}
+		text, ok := js["text"].(string)
+		if !ok && !inProgress(js) {
+			log.Println(js)
+			return result, errors.New("failed to read json text status returned from code redemption")
+		}
+		result.Message = text
+
+		if !inProgress(js) {
+			result.Type = DetermineResponseType(text)
+			result.Retryable = false
+			if result.Type == Unrecognized {
+				log.Println("Undetected response message after posting code redemption")
+				log.Println(text)
 			}
+			return result, nil
 		}
-	}
 
-	return "", nil
+		wait = min(wait*2, maxPollInterval)
+		if time.Now().Add(wait).After(deadline) {
+			result.Type = Pending
+			result.Retryable = true
+			return result, nil
+		}
+	}
 }
 
-func commitMessage(platform Platform) string {
-	switch platform {
-	case Steam:
-		return "Redeem for Steam"
-	case PSN:
-		return "Redeem for PSN"
-	case Epic:
-		return "Redeem for Epic"
-	case XboxLive:
-		return "Redeem for Xbox Live"
-	default:
-		return ""
+// inProgress reports whether a redemption status response says the redemption hasn't finished yet
+func inProgress(js map[string]any) bool {
+	if v, ok := js["in_progress"].(bool); ok && v {
+		return true
 	}
+	status, _ := js["status"].(string)
+	return status == "in_progress"
 }
 
-func (client *Client) CheckRewards(platform Platform, game Game, limit int) ([]Reward, error) {
-	if !client.hasCookies {
-		return nil, errors.New("no cookies found, login client before attempting to load rewards")
-	}
-	headers := map[string]string{}
-	doc, err := client.hClient.GetAsHTML(REWARDS, headers)
+// getAsHTML is a GET request that records the response status on the redemption result
+func (client *Client) getAsHTML(ctx context.Context, result *RedeemResult, url string, headers map[string]string) (*goquery.Document, error) {
+	resp, err := client.hClient.Get(ctx, url, headers)
 	if err != nil {
+		result.fail(err)
 		return nil, err
 	}
+	result.record(resp.StatusCode)
+	return readAsHTML(*resp)
+}
 
-	var rewards []Reward
-	currentGame := ""
-	selector := fmt.Sprintf("div.tab-pane.well#%s div.sh_reward_list", string(platform))
-	doc.Find(selector).Children().Each(func(i int, s *goquery.Selection) {
-		if s.HasClass("shift-secondary-title") {
-			// Update current game context
-			currentGame = strings.TrimSpace(s.Find("h2").Text())
-		} else if goquery.NodeName(s) == "dl" && currentGame == string(game) {
-			// Parse a reward
-			title := strings.TrimSpace(s.Find("dt").Text())
-			date := strings.TrimSpace(s.Find("dd .reward_unlocked").Text())
-
-			dd := s.Find("dd").Clone()
-			dd.Find(".reward_unlocked").Remove()
-			description := strings.TrimSpace(dd.Text())
-			rewards = append(rewards, Reward{
-				Title:       title,
-				Date:        date,
-				Description: description,
-			})
-			if limit > 0 && len(rewards) == limit {
-				return
-			}
-		}
-	})
+// CheckRewards returns up to limit of the newest rewards for a platform and game. A limit of zero or less returns them all
+func (client *Client) CheckRewards(ctx context.Context, platform Platform, game Game, limit int) ([]Reward, error) {
+	snapshot, err := client.Rewards(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rewards := snapshot.Rewards(platform, game)
+	if limit > 0 && len(rewards) > limit {
+		rewards = rewards[:limit]
+	}
 	return rewards, nil
 }
@@ -0,0 +1,678 @@
+package shift_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/shift/shifttest"
+)
+
+const (
+	testEmail    = "vault@hunter.com"
+	testPassword = "hunter2"
+	testCode     = "AAAAA-BBBBB-CCCCC-DDDDD-EEEEE"
+)
+
+func newTestServer(t *testing.T) *shifttest.Server {
+	server := shifttest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddAccount(testEmail, testPassword)
+	return server
+}
+
+func newTestClient(t *testing.T, server *shifttest.Server) *shift.Client {
+	client, err := shift.NewClient(server.Cookies(testEmail), shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond), shift.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewClient_InvalidBaseURL(t *testing.T) {
+	_, err := shift.NewClient(nil, shift.WithBaseURL("not a url"))
+	if err == nil {
+		t.Fatal("Expected error for relative base url")
+	}
+}
+
+func TestClient_Login(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Login(t.Context(), testEmail, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.DumpCookies()) != 2 {
+		t.Fatal("Expected si and _session_id cookies after login, got ", len(client.DumpCookies()))
+	}
+
+	// the dumped cookies should be sufficient to make a new client
+	client, err = shift.NewClient(client.DumpCookies(), shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_LoginInvalidCredentials(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Login(t.Context(), testEmail, "wrong")
+	if err == nil {
+		t.Fatal("Expected error logging in with the wrong password")
+	}
+}
+
+func TestClient_RedeemCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		outcome   shifttest.Outcome
+		respType  shift.ResponseType
+		message   string
+		retryable bool
+		wantErr   bool
+	}{
+		{"success", shifttest.Success, shift.Success, shift.SUCCESS, false, false},
+		{"already redeemed", shifttest.AlreadyRedeemed, shift.AlreadyRedeemed, shift.ALREADY_REDEEMED, false, false},
+		{"expired", shifttest.Expired, shift.Expired, shift.EXPIRED, false, false},
+		{"not exist", shifttest.NotExist, shift.Invalid, shift.NOT_EXIST, false, false},
+		{"link 2k", shifttest.Link2K, shift.Link2KAccount, shift.LINK2K, false, false},
+		{"in progress", shifttest.InProgress, shift.Pending, shifttest.InProgressMsg, true, false},
+		{"unavailable", shifttest.Unavailable, shift.Unrecognized, "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			server.SetCode(testCode, shifttest.Code{Outcome: tt.outcome})
+			client := newTestClient(t, server)
+
+			result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error: %t, got %v", tt.wantErr, err)
+			}
+			if result.Type != tt.respType {
+				t.Fatalf("Expected response type %d, got %d", tt.respType, result.Type)
+			}
+			if result.Message != tt.message {
+				t.Fatalf("Expected message %q, got %q", tt.message, result.Message)
+			}
+			if result.Retryable != tt.retryable {
+				t.Fatalf("Expected retryable: %t, got %t", tt.retryable, result.Retryable)
+			}
+			if result.Platform != shift.Steam {
+				t.Fatal("Expected platform steam, got ", result.Platform)
+			}
+			if len(result.StatusCodes) == 0 {
+				t.Fatal("Expected http status codes to be recorded")
+			}
+		})
+	}
+}
+
+func TestClient_RedeemCodeTwice(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.Success {
+		t.Fatal("Expected success, got ", result.Message)
+	}
+	if result.Title != "oak2" {
+		t.Fatal("Expected title from the redemption form, got ", result.Title)
+	}
+	result, err = client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.AlreadyRedeemed {
+		t.Fatal("Expected already redeemed, got ", result.Message)
+	}
+	// codes are redeemed per platform
+	result, err = client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.PSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.Success {
+		t.Fatal("Expected success on another platform, got ", result.Message)
+	}
+}
+
+func TestClient_CheckRewards(t *testing.T) {
+	server := newTestServer(t)
+	const otherCode = "FFFFF-GGGGG-HHHHH-JJJJJ-KKKKK"
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	server.SetCode(otherCode, shifttest.Code{Outcome: shifttest.Success, Reward: "Vault Card Skin"})
+	client := newTestClient(t, server)
+
+	rewards, err := client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 0 {
+		t.Fatal("Expected no rewards for a fresh account, got ", len(rewards))
+	}
+
+	client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	client.RedeemCode(t.Context(), otherCode, shift.Borderlands4, shift.Steam)
+
+	rewards, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 2 {
+		t.Fatal("Expected 2 rewards, got ", len(rewards))
+	}
+	if rewards[0].Title != "Vault Card Skin" {
+		t.Fatal("Expected newest reward first, got ", rewards[0].Title)
+	}
+	if rewards[1].Title != shift.GoldenKey {
+		t.Fatal("Expected golden key, got ", rewards[1].Title)
+	}
+
+	rewards, err = client.CheckRewards(t.Context(), shift.PSN, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 0 {
+		t.Fatal("Expected no rewards on a platform nothing was redeemed for, got ", len(rewards))
+	}
+}
+
+func TestClient_CheckRewardsExpiredSession(t *testing.T) {
+	server := newTestServer(t)
+	stale := []*http.Cookie{
+		{Name: "si", Value: "stale"},
+		{Name: "_session_id", Value: "stale"},
+	}
+	client, err := shift.NewClient(stale, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithPolling(time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err == nil {
+		t.Fatal("Expected error checking rewards with a session SHiFT doesn't recognize")
+	}
+}
+
+func TestClient_CheckRewardsPerGame(t *testing.T) {
+	server := newTestServer(t)
+	const bl3Code = "FFFFF-GGGGG-HHHHH-JJJJJ-KKKKK"
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	server.SetCode(bl3Code, shifttest.Code{Outcome: shifttest.Success, Game: shift.Borderlands3, Reward: "Diamond Key"})
+	client := newTestClient(t, server)
+
+	result, err := client.RedeemCode(t.Context(), bl3Code, shift.Borderlands3, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game, _ := shift.GameFromTitle(result.Title); game != shift.Borderlands3 {
+		t.Fatal("Expected redemption form for Borderlands 3, got ", result.Title)
+	}
+	client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+
+	rewards, err := client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands3, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 1 || rewards[0].Title != "Diamond Key" {
+		t.Fatal("Expected only the Borderlands 3 reward, got ", rewards)
+	}
+	rewards, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 1 || rewards[0].Title != shift.GoldenKey {
+		t.Fatal("Expected only the Borderlands 4 reward, got ", rewards)
+	}
+}
+
+func TestClient_RewardsDiff(t *testing.T) {
+	server := newTestServer(t)
+	const secondCode = "FFFFF-GGGGG-HHHHH-JJJJJ-KKKKK"
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	server.SetCode(secondCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	_, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same reward again, which comparing list lengths per platform couldn't tell apart
+	result, err := client.RedeemCode(t.Context(), secondCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rewards == nil || len(result.Rewards.Rewards(shift.Steam, shift.Borderlands4)) != 1 {
+		t.Fatal("Expected the result to include the rewards from before the redemption")
+	}
+
+	after, err := client.Rewards(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := after.Diff(result.Rewards)
+	if len(added) != 1 || added[0].Title != shift.GoldenKey || added[0].Platform != shift.Steam || added[0].Game != shift.Borderlands4 {
+		t.Fatal("Expected exactly the new golden key, got ", added)
+	}
+}
+
+func TestClient_RedeemCodeNotAvailable(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success, Platforms: []shift.Platform{shift.PSN}})
+	client := newTestClient(t, server)
+
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.NotAvailable {
+		t.Fatal("Expected not available for steam, got ", result.Message)
+	}
+	// a code for one game isn't redeemed for another
+	result, err = client.RedeemCode(t.Context(), testCode, shift.Borderlands3, shift.PSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.NotAvailable {
+		t.Fatal("Expected not available for Borderlands 3, got ", result.Message)
+	}
+	if len(server.Rewards(testEmail, shift.Steam)) != 0 {
+		t.Fatal("Expected nothing to be redeemed")
+	}
+
+	// without a game, whatever game the code is for is fine
+	result, err = client.RedeemCode(t.Context(), testCode, "", shift.PSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.Success {
+		t.Fatal("Expected success on psn, got ", result.Message)
+	}
+}
+
+func TestClient_RedeemCodeCancelled(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	// the default delay before submitting the form is long enough to be interrupted
+	client, err := shift.NewClient(server.Cookies(testEmail), shift.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := client.RedeemCode(ctx, testCode, shift.Borderlands4, shift.Steam)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected deadline exceeded, got ", err)
+	}
+	if time.Since(start) >= shift.DefaultDelay {
+		t.Fatal("Expected redemption to be interrupted before the delay finished")
+	}
+	if !result.Retryable {
+		t.Fatal("Expected a cancelled redemption to be retryable")
+	}
+	if len(server.Rewards(testEmail, shift.Steam)) != 0 {
+		t.Fatal("Expected nothing to be redeemed")
+	}
+}
+
+func TestClient_OperationTimeout(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithOperationTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Login(t.Context(), testEmail, testPassword)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected deadline exceeded, got ", err)
+	}
+}
+
+func TestClient_RedeemCodePolling(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success, PendingPolls: 3})
+	client := newTestClient(t, server)
+
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.Success {
+		t.Fatal("Expected success after polling, got ", result.Message)
+	}
+	if result.Retryable {
+		t.Fatal("Expected a resolved redemption not to be retryable")
+	}
+	// rewards, entitlement, redemption post, and then a status poll for every in_progress response plus the final one
+	if len(result.StatusCodes) != 3+4 {
+		t.Fatal("Expected 7 requests, got ", result.StatusCodes)
+	}
+}
+
+func TestClient_RedeemCodePollingTimeout(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.InProgress})
+	client := newTestClient(t, server)
+
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type != shift.Pending {
+		t.Fatal("Expected pending, got ", result.Type)
+	}
+	if result.Final() {
+		t.Fatal("A pending result shouldn't be recorded")
+	}
+	if len(result.StatusCodes) < 5 {
+		t.Fatal("Expected several polls before giving up, got ", result.StatusCodes)
+	}
+}
+
+func TestClient_RedeemCodeThrottled(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	server.Throttle(2, "")
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if err != nil {
+		t.Fatal("Expected throttled requests to be retried, got ", err)
+	}
+	if result.Type != shift.Success {
+		t.Fatal("Expected success, got ", result.Message)
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	server.Throttle(3, "")
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	var rateLimited *shift.RateLimitedError
+	if !errors.Is(err, shift.ErrRateLimited) || !errors.As(err, &rateLimited) {
+		t.Fatal("Expected rate limited error, got ", err)
+	}
+	if rateLimited.StatusCode != http.StatusTooManyRequests {
+		t.Fatal("Expected status 429, got ", rateLimited.StatusCode)
+	}
+	if !result.Retryable || len(result.StatusCodes) != 1 || result.StatusCodes[0] != http.StatusTooManyRequests {
+		t.Fatal("Expected a retryable result with the 429 recorded, got ", result.StatusCodes)
+	}
+	if server.Requests() != 3 {
+		t.Fatal("Expected the first request and 2 retries, got ", server.Requests())
+	}
+}
+
+func TestClient_RateLimitedRetryAfter(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server)
+
+	// waiting an hour isn't worth it, so the client gives up straight away
+	server.Throttle(1, "3600")
+	_, err := client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	var rateLimited *shift.RateLimitedError
+	if !errors.As(err, &rateLimited) {
+		t.Fatal("Expected rate limited error, got ", err)
+	}
+	if rateLimited.RetryAfter != time.Hour {
+		t.Fatal("Expected retry after of an hour, got ", rateLimited.RetryAfter)
+	}
+	if server.Requests() != 1 {
+		t.Fatal("Expected no retries, got ", server.Requests())
+	}
+}
+
+func TestClient_LoginRateLimited(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithRetries(0, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Throttle(1, "")
+	err = client.Login(t.Context(), testEmail, testPassword)
+	if !errors.Is(err, shift.ErrRateLimited) {
+		t.Fatal("Expected rate limited error, got ", err)
+	}
+}
+
+// a 502 after the form was sent doesn't say whether SHiFT redeemed the code, so it isn't sent again
+func TestClient_RedeemCodeBadGatewayNotResent(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	server.FailPosts(1, http.StatusBadGateway)
+	_, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if !shift.IsUpstream(err) {
+		t.Fatal("Expected an upstream error, got ", err)
+	}
+	if server.Posts() != 1 {
+		t.Fatal("Expected the redemption to be posted once, got ", server.Posts())
+	}
+}
+
+func TestClient_LoginBadGatewayNotResent(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.FailPosts(1, http.StatusGatewayTimeout)
+	err = client.Login(t.Context(), testEmail, testPassword)
+	if err == nil {
+		t.Fatal("Expected the login to fail")
+	}
+	if server.Posts() != 1 {
+		t.Fatal("Expected the login to be posted once, got ", server.Posts())
+	}
+}
+
+// a 503 without Retry-After is maintenance rather than throttling, so it isn't sent again either
+func TestClient_LoginMaintenance(t *testing.T) {
+	server := newTestServer(t)
+	client, err := shift.NewClient(nil, shift.WithBaseURL(server.URL), shift.WithDelay(0), shift.WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.FailPosts(1, http.StatusServiceUnavailable)
+	err = client.Login(t.Context(), testEmail, testPassword)
+	if err == nil || !strings.Contains(err.Error(), "temporarily unavailable") {
+		t.Fatal("Expected a temporarily unavailable error, got ", err)
+	}
+	if server.Posts() != 1 {
+		t.Fatal("Expected the login to be posted once, got ", server.Posts())
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server)
+
+	if err := client.Ping(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	server.SetDown(true)
+	err := client.Ping(t.Context())
+	if !errors.Is(err, shift.ErrUnavailable) {
+		t.Fatal("Expected unavailable error, got ", err)
+	}
+}
+
+func TestClient_RedeemCodeDown(t *testing.T) {
+	server := newTestServer(t)
+	server.SetCode(testCode, shifttest.Code{Outcome: shifttest.Success})
+	client := newTestClient(t, server)
+
+	server.SetDown(true)
+	result, err := client.RedeemCode(t.Context(), testCode, shift.Borderlands4, shift.Steam)
+	if !shift.IsUpstream(err) {
+		t.Fatal("Expected an upstream error, got ", err)
+	}
+	if errors.Is(err, shift.ErrRateLimited) {
+		t.Fatal("A 503 without Retry-After shouldn't be treated as rate limiting")
+	}
+	if !result.Retryable {
+		t.Fatal("Expected the result to be retryable")
+	}
+}
+
+func TestIsUpstream(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"server error", &shift.StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"client error", &shift.StatusError{StatusCode: http.StatusNotFound}, false},
+		{"timeout", fmt.Errorf("loading rewards: %w", context.DeadlineExceeded), true},
+		{"cancelled", context.Canceled, false},
+		{"account", errors.New("failed to find csrf token in redemption form"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shift.IsUpstream(tt.err); got != tt.want {
+				t.Fatalf("Expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClient_SessionStatus(t *testing.T) {
+	server := newTestServer(t)
+	expiring := server.Cookies(testEmail)
+	expiring[0].Expires = time.Now().Add(time.Hour)
+	expired := server.Cookies(testEmail)
+	expired[0].Expires = time.Now().Add(-time.Hour)
+	stale := []*http.Cookie{
+		{Name: "si", Value: "stale"},
+		{Name: "_session_id", Value: "stale"},
+	}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		state   shift.SessionState
+	}{
+		{"valid", server.Cookies(testEmail), shift.SessionValid},
+		{"expiring soon", expiring, shift.SessionExpiringSoon},
+		{"expired", expired, shift.SessionInvalid},
+		{"unrecognized", stale, shift.SessionInvalid},
+		{"no cookies", nil, shift.SessionInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := shift.NewClient(tt.cookies, shift.WithBaseURL(server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			session, err := client.SessionStatus(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.State != tt.state {
+				t.Fatalf("Expected %s, got %s", tt.state, session.State)
+			}
+		})
+	}
+}
+
+func TestClient_SessionStatusDown(t *testing.T) {
+	server := newTestServer(t)
+	client := newTestClient(t, server)
+
+	server.SetDown(true)
+	_, err := client.SessionStatus(t.Context())
+	if !shift.IsUpstream(err) {
+		t.Fatal("Expected an upstream error rather than an invalid session, got ", err)
+	}
+}
+
+func TestClient_DumpCookiesRotated(t *testing.T) {
+	server := newTestServer(t)
+	stored := server.Cookies(testEmail)
+	client, err := shift.NewClient(stored, shift.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, rotated := shift.RotatedCookies(stored, client.DumpCookies()); rotated {
+		t.Fatal("Expected cookies not to change without rotation")
+	}
+
+	server.RotateSessions(true)
+	_, err = client.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies, rotated := shift.RotatedCookies(stored, client.DumpCookies())
+	if !rotated || len(cookies) != 2 {
+		t.Fatal("Expected the rotated session cookies, got ", cookies)
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == "_session_id" && (cookie.Value == stored[1].Value || cookie.Expires.IsZero()) {
+			t.Fatal("Expected a new _session_id with an expiry, got ", cookie)
+		}
+	}
+
+	// the stored session is stale now, but the rotated one works
+	server.RotateSessions(false)
+	stale, err := shift.NewClient(stored, shift.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stale.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1); err == nil {
+		t.Fatal("Expected the old session to be rejected")
+	}
+	fresh, err := shift.NewClient(cookies, shift.WithBaseURL(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fresh.CheckRewards(t.Context(), shift.Steam, shift.Borderlands4, -1); err != nil {
+		t.Fatal("Expected the rotated session to work, got ", err)
+	}
+}
+
+func TestRotatedCookies_Incomplete(t *testing.T) {
+	stored := []*http.Cookie{
+		{Name: "si", Value: "si"},
+		{Name: "_session_id", Value: "session"},
+	}
+	if _, rotated := shift.RotatedCookies(stored, []*http.Cookie{{Name: "_session_id", Value: "new"}}); rotated {
+		t.Fatal("An incomplete session shouldn't replace the stored one")
+	}
+}
@@ -0,0 +1,565 @@
+// Package shifttest provides an in-process fake of the SHiFT website, so that shift.Client (and everything built on
+// top of it) can be exercised without talking to Gearbox.
+package shifttest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+)
+
+// Outcome is the scripted result the fake server produces when a code is redeemed
+type Outcome int
+
+const (
+	Success Outcome = iota
+	AlreadyRedeemed
+	Expired
+	NotExist
+	Link2K
+	InProgress
+	Unavailable // the entitlement lookup responds with a 503
+)
+
+const (
+	CSRFToken     = "fake-csrf-token"
+	InProgressMsg = "Your code is being processed"
+	// DateLayout is the layout used for the unlock date shown next to each reward
+	DateLayout = "Jan 2, 2006"
+
+	// where SHiFT sends users when a redemption form is rejected
+	redeemPage = "/code_redemptions/new"
+)
+
+// Code describes how the fake server responds when a code is redeemed
+type Code struct {
+	Outcome Outcome
+	Game    shift.Game
+	// Reward is the reward title unlocked on Success. Defaults to shift.GoldenKey
+	Reward string
+	// Platforms the code can be redeemed on. Defaults to every platform
+	Platforms []shift.Platform
+	// PendingPolls is how many times the redemption status reports in_progress before resolving to the Outcome.
+	// The InProgress outcome never resolves
+	PendingPolls int
+}
+
+type redemptionStatus struct {
+	text         string
+	pendingPolls int
+}
+
+type account struct {
+	password string
+	// rewards per platform, newest first
+	rewards  map[shift.Platform][]reward
+	redeemed map[string]bool // keyed by code + platform
+}
+
+type reward struct {
+	game        shift.Game
+	title       string
+	description string
+	unlocked    time.Time
+}
+
+// Server is a fake SHiFT website. It is safe for concurrent use
+type Server struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	accounts map[string]*account // keyed by email
+	sessions map[string]string   // _session_id -> email
+	codes    map[string]Code
+	pending  map[string]*redemptionStatus // keyed by redemption job id
+
+	throttled  int // how many more requests are answered with 429
+	retryAfter string
+	failPosts  int // how many more POSTs are answered with failStatus, after they're handled
+	failStatus int
+	requests   int
+	posts      int
+	down       bool
+	rotate     bool
+}
+
+// NewServer starts a fake SHiFT server. Callers should Close it when finished
+func NewServer() *Server {
+	s := &Server{
+		accounts: map[string]*account{},
+		sessions: map[string]string{},
+		codes:    map[string]Code{},
+		pending:  map[string]*redemptionStatus{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+shift.HOME, s.handleHome)
+	mux.HandleFunc("POST "+shift.SESSIONS, s.handleSessions)
+	mux.HandleFunc("GET "+shift.REWARDS, s.handleRewards)
+	mux.HandleFunc("GET "+shift.ENTITLEMENT, s.handleEntitlement)
+	mux.HandleFunc("POST "+shift.REDEMPTIONS, s.handleRedemption)
+	mux.HandleFunc("GET "+shift.REDEMPTIONS+"/{id}", s.handleRedemptionStatus)
+	s.Server = httptest.NewServer(s.throttle(mux))
+	return s
+}
+
+// Throttle makes the server answer the next n requests with 429 Too Many Requests, sending retryAfter as the
+// Retry-After header if it isn't empty
+func (s *Server) Throttle(n int, retryAfter string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.throttled = n
+	s.retryAfter = retryAfter
+}
+
+// FailPosts makes the server answer the next n POST requests with statusCode, like a proxy that gave up waiting after
+// SHiFT had already handled them. The requests still take effect
+func (s *Server) FailPosts(n int, statusCode int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failPosts = n
+	s.failStatus = statusCode
+}
+
+// SetDown puts the server into (or out of) maintenance, where every request is answered with 503 Service Unavailable
+func (s *Server) SetDown(down bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.down = down
+}
+
+// RotateSessions makes the server replace the _session_id cookie every time the rewards page is loaded, like SHiFT
+// does during normal browsing. The old session stops working
+func (s *Server) RotateSessions(rotate bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rotate = rotate
+}
+
+// Requests returns how many requests the server has received, including throttled ones
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+// Posts returns how many POST requests the server has received, including throttled ones
+func (s *Server) Posts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.posts
+}
+
+func (s *Server) throttle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.requests++
+		if r.Method == http.MethodPost {
+			s.posts++
+		}
+		if s.down {
+			s.lock.Unlock()
+			http.Error(w, "SHiFT is down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		throttled := s.throttled > 0
+		if throttled {
+			s.throttled--
+			if s.retryAfter != "" {
+				w.Header().Set("Retry-After", s.retryAfter)
+			}
+		}
+		failStatus := 0
+		if !throttled && r.Method == http.MethodPost && s.failPosts > 0 {
+			s.failPosts--
+			failStatus = s.failStatus
+		}
+		s.lock.Unlock()
+		if throttled {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		if failStatus != 0 {
+			next.ServeHTTP(httptest.NewRecorder(), r)
+			http.Error(w, http.StatusText(failStatus), failStatus)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AddAccount registers a SHiFT account that can log in with the provided credentials
+func (s *Server) AddAccount(email, password string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.accounts[email] = &account{
+		password: password,
+		rewards:  map[shift.Platform][]reward{},
+		redeemed: map[string]bool{},
+	}
+}
+
+// Cookies starts a new session for an existing account, and returns the si and _session_id cookies for it,
+// as if the user had copied them out of their browser
+func (s *Server) Cookies(email string) []*http.Cookie {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.accounts[email]; !ok {
+		return nil
+	}
+	return s.newSession(email)
+}
+
+// SetCode scripts how the server responds to redemptions of the provided code. Unknown codes do not exist
+func (s *Server) SetCode(code string, c Code) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c.Game == "" {
+		c.Game = shift.Borderlands4
+	}
+	if c.Reward == "" {
+		c.Reward = shift.GoldenKey
+	}
+	if len(c.Platforms) == 0 {
+		c.Platforms = shift.Platforms
+	}
+	s.codes[code] = c
+}
+
+// Rewards returns the titles of the rewards unlocked for an account on a platform, newest first
+func (s *Server) Rewards(email string, platform shift.Platform) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	acc, ok := s.accounts[email]
+	if !ok {
+		return nil
+	}
+	var titles []string
+	for _, r := range acc.rewards[platform] {
+		titles = append(titles, r.title)
+	}
+	return titles
+}
+
+func (s *Server) newSession(email string) []*http.Cookie {
+	id := randomHex()
+	s.sessions[id] = email
+	return []*http.Cookie{
+		{Name: "si", Value: randomHex()},
+		{Name: "_session_id", Value: id},
+	}
+}
+
+// rotateSession moves the request's session to a new _session_id. Callers must hold the lock
+func (s *Server) rotateSession(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("_session_id")
+	if err != nil {
+		return
+	}
+	id := randomHex()
+	s.sessions[id] = s.sessions[cookie.Value]
+	delete(s.sessions, cookie.Value)
+	http.SetCookie(w, &http.Cookie{Name: "_session_id", Value: id, Path: "/", Expires: time.Now().Add(14 * 24 * time.Hour), HttpOnly: true})
+}
+
+// account returns the account for the request's session cookie, if there is one. Callers must hold the lock
+func (s *Server) account(r *http.Request) *account {
+	cookie, err := r.Cookie("_session_id")
+	if err != nil {
+		return nil
+	}
+	email, ok := s.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	return s.accounts[email]
+}
+
+func randomHex() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+var homeTemplate = template.Must(template.New("home").Parse(`<!DOCTYPE html>
+<html>
+<head><meta name="csrf-token" content="{{.}}"></head>
+<body>
+<h1>Sign in</h1>
+<form action="/sessions" method="post">
+  <input name="utf8" type="hidden" value="&#x2713;">
+  <input type="hidden" name="authenticity_token" value="{{.}}">
+  <input type="email" name="user[email]">
+  <input type="password" name="user[password]">
+  <input type="submit" name="commit" value="SIGN IN">
+</form>
+</body>
+</html>`))
+
+func (s *Server) handleHome(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = homeTemplate.Execute(w, CSRFToken)
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("authenticity_token") != CSRFToken {
+		http.Error(w, "invalid authenticity token", http.StatusUnprocessableEntity)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	email := r.PostFormValue("user[email]")
+	acc, ok := s.accounts[email]
+	if !ok || acc.password != r.PostFormValue("user[password]") {
+		http.Redirect(w, r, shift.HOME+"?redirect_to=false", http.StatusFound)
+		return
+	}
+	for _, cookie := range s.newSession(email) {
+		cookie.Path = "/"
+		cookie.HttpOnly = true
+		http.SetCookie(w, cookie)
+	}
+	http.Redirect(w, r, "/account", http.StatusFound)
+}
+
+type rewardsSection struct {
+	Game    shift.Game
+	Header  string
+	Rewards []rewardView
+}
+
+type rewardView struct {
+	Title       string
+	Description string
+	Unlocked    string
+}
+
+type rewardsTab struct {
+	Platform shift.Platform
+	Sections []rewardsSection
+}
+
+var rewardsTemplate = template.Must(template.New("rewards").Parse(`<!DOCTYPE html>
+<html>
+<head><meta name="csrf-token" content="{{.CSRF}}"></head>
+<body>
+<div class="tab-content">
+{{- range .Tabs}}
+  <div class="tab-pane well" id="{{.Platform}}">
+    <div class="sh_reward_list">
+    {{- range .Sections}}
+      <div class="shift-secondary-title"><h2>{{.Header}}</h2></div>
+      {{- range .Rewards}}
+      <dl>
+        <dt>{{.Title}}</dt>
+        <dd><span class="reward_unlocked">{{.Unlocked}}</span>{{.Description}}</dd>
+      </dl>
+      {{- end}}
+    {{- end}}
+    </div>
+  </div>
+{{- end}}
+</div>
+</body>
+</html>`))
+
+func (s *Server) handleRewards(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	acc := s.account(r)
+	if acc == nil {
+		http.Redirect(w, r, shift.HOME, http.StatusFound)
+		return
+	}
+	if s.rotate {
+		s.rotateSession(w, r)
+	}
+
+	var data struct {
+		CSRF string
+		Tabs []rewardsTab
+	}
+	data.CSRF = CSRFToken
+	for _, platform := range shift.Platforms {
+		tab := rewardsTab{Platform: platform}
+		for _, rew := range acc.rewards[platform] {
+			view := rewardView{
+				Title:       rew.title,
+				Description: rew.description,
+				Unlocked:    rew.unlocked.Format(DateLayout),
+			}
+			// rewards are grouped under a header for each game, in the order the games were first seen
+			found := false
+			for i := range tab.Sections {
+				if tab.Sections[i].Game == rew.game {
+					tab.Sections[i].Rewards = append(tab.Sections[i].Rewards, view)
+					found = true
+					break
+				}
+			}
+			if !found {
+				tab.Sections = append(tab.Sections, rewardsSection{Game: rew.game, Header: rew.game.RewardsHeader(), Rewards: []rewardView{view}})
+			}
+		}
+		data.Tabs = append(data.Tabs, tab)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = rewardsTemplate.Execute(w, data)
+}
+
+type offerForm struct {
+	CSRF    string
+	Code    string
+	Check   string
+	Service shift.Platform
+	Title   string
+	Commit  string
+}
+
+var entitlementTemplate = template.Must(template.New("entitlement").Parse(`<div class="redemption_options">
+{{- range .}}
+<form class="new_archway_code_redemption" action="/code_redemptions" method="post">
+  <input name="utf8" type="hidden" value="&#x2713;">
+  <input type="hidden" name="authenticity_token" value="{{.CSRF}}">
+  <input type="hidden" name="archway_code_redemption[code]" value="{{.Code}}">
+  <input type="hidden" name="archway_code_redemption[check]" value="{{.Check}}">
+  <input type="hidden" name="archway_code_redemption[service]" value="{{.Service}}">
+  <input type="hidden" name="archway_code_redemption[title]" value="{{.Title}}">
+  <input type="submit" name="commit" value="{{.Commit}}" class="submit_button redeem_button">
+</form>
+{{- end}}
+</div>`))
+
+var commitLabels = map[shift.Platform]string{
+	shift.Steam:    "Redeem for Steam",
+	shift.Epic:     "Redeem for Epic",
+	shift.XboxLive: "Redeem for Xbox Live",
+	shift.PSN:      "Redeem for PSN",
+}
+
+func (s *Server) handleEntitlement(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.account(r) == nil {
+		http.Error(w, "You need to sign in or sign up before continuing.", http.StatusUnauthorized)
+		return
+	}
+	code := r.URL.Query().Get("code")
+	c, ok := s.codes[code]
+	if !ok {
+		c = Code{Outcome: NotExist}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	switch c.Outcome {
+	case Unavailable:
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	case NotExist:
+		fmt.Fprintln(w, shift.NOT_EXIST)
+		return
+	case Expired:
+		fmt.Fprintln(w, shift.EXPIRED)
+		return
+	}
+
+	title := c.Game.Title()
+	var forms []offerForm
+	for _, platform := range c.Platforms {
+		forms = append(forms, offerForm{
+			CSRF:    CSRFToken,
+			Code:    code,
+			Check:   code,
+			Service: platform,
+			Title:   title,
+			Commit:  commitLabels[platform],
+		})
+	}
+	_ = entitlementTemplate.Execute(w, forms)
+}
+
+func (s *Server) handleRedemption(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	acc := s.account(r)
+	if acc == nil || r.PostFormValue("authenticity_token") != CSRFToken {
+		http.Redirect(w, r, redeemPage+"?redirect_to=false", http.StatusFound)
+		return
+	}
+	code := r.PostFormValue("archway_code_redemption[code]")
+	platform := shift.Platform(r.PostFormValue("archway_code_redemption[service]"))
+	c, ok := s.codes[code]
+	if !ok || r.PostFormValue("archway_code_redemption[check]") != code ||
+		r.PostFormValue("archway_code_redemption[title]") != c.Game.Title() || !slices.Contains(c.Platforms, platform) {
+		http.Redirect(w, r, redeemPage+"?redirect_to=false", http.StatusFound)
+		return
+	}
+
+	var text string
+	switch c.Outcome {
+	case Success:
+		key := code + "|" + string(platform)
+		if acc.redeemed[key] {
+			text = shift.ALREADY_REDEEMED
+			break
+		}
+		acc.redeemed[key] = true
+		acc.rewards[platform] = append([]reward{{
+			game:        c.Game,
+			title:       c.Reward,
+			description: "Redeemed with " + code,
+			unlocked:    time.Now().UTC(),
+		}}, acc.rewards[platform]...)
+		text = shift.SUCCESS
+	case AlreadyRedeemed:
+		text = shift.ALREADY_REDEEMED
+	case Expired:
+		text = shift.EXPIRED
+	case NotExist:
+		text = shift.NOT_EXIST
+	case Link2K:
+		text = shift.LINK2K
+	case InProgress:
+		text = InProgressMsg
+	}
+	id := randomHex()
+	s.pending[id] = &redemptionStatus{text: text, pendingPolls: c.PendingPolls}
+	http.Redirect(w, r, shift.REDEMPTIONS+"/"+id, http.StatusFound)
+}
+
+func (s *Server) handleRedemptionStatus(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	status, ok := s.pending[r.PathValue("id")]
+	var inProgress bool
+	var text string
+	if ok {
+		inProgress = status.text == InProgressMsg || status.pendingPolls > 0
+		status.pendingPolls--
+		text = status.text
+		if inProgress {
+			text = InProgressMsg
+		}
+	}
+	s.lock.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"in_progress": inProgress,
+		"text":        text,
+	})
+}
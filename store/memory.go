@@ -0,0 +1,1108 @@
+package store
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+)
+
+// ErrConstraint is returned by Memory for changes a database would reject, like adding a redemption for a user that
+// doesn't exist
+var ErrConstraint = errors.New("constraint failed")
+
+// Memory is a Store that keeps everything in memory, for tests that don't need a database. It behaves like the
+// database stores, down to how results are ordered, but cookies aren't encrypted and nothing outlives the process
+type Memory struct {
+	lock sync.Mutex
+	options
+
+	users       map[string]*memoryUser
+	codes       map[string]*memoryCode
+	codeOrder   []string // codes in the order they were added, which is how ties are broken
+	redemptions []memoryRedemption
+	validity    map[codePlatform]int
+	rewards     []memoryReward
+	errors      []memoryError
+	jobs        map[int64]*RedemptionJob
+	lastID      int64
+}
+
+type memoryUser struct {
+	dm             *bool
+	platforms      []memoryPlatform
+	games          []string
+	canary         bool
+	backoff        UserBackoff
+	redemptionUnix *int64
+	cookies        *memoryCookies
+}
+
+type memoryPlatform struct {
+	platform    string
+	createdUnix int64
+}
+
+type memoryCookies struct {
+	json          []byte
+	state         shift.SessionState
+	expiresUnix   *int64
+	checkedUnix   int64
+	warned        bool
+	refreshedUnix int64
+}
+
+type memoryCode struct {
+	game        string
+	reward      sql.NullString
+	userID      sql.NullString
+	validation  CodeValidation
+	successUnix *int64
+	createdUnix int64
+}
+
+type memoryRedemption struct {
+	code, userID, platform, status string
+	createdUnix                    int64
+}
+
+type codePlatform struct {
+	code, platform string
+}
+
+type memoryReward struct {
+	id     int64
+	userID string
+	UserReward
+	unlocked   *int64
+	goldenKeys int
+}
+
+type memoryError struct {
+	userID, text string
+	createdUnix  int64
+}
+
+func NewMemoryStore(opts ...Option) Store {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Memory{
+		options:  o,
+		users:    map[string]*memoryUser{},
+		codes:    map[string]*memoryCode{},
+		validity: map[codePlatform]int{},
+		jobs:     map[int64]*RedemptionJob{},
+	}
+}
+
+// compareUserIDs orders user IDs like the database does, which stores them as numbers
+func compareUserIDs(a, b string) int {
+	x, errX := strconv.ParseUint(a, 10, 64)
+	y, errY := strconv.ParseUint(b, 10, 64)
+	if errX == nil && errY == nil {
+		return cmp.Compare(x, y)
+	}
+	return cmp.Compare(a, b)
+}
+
+// compareNullsFirst orders optional times ascending, like the database does, where missing ones come first
+func compareNullsFirst(a, b *int64) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return cmp.Compare(*a, *b)
+}
+
+// limited cuts s down to limit elements. A negative limit keeps them all
+func limited[T any](s []T, limit int) []T {
+	if limit >= 0 && len(s) > limit {
+		return s[:limit]
+	}
+	return s
+}
+
+func (m *Memory) UserExists(userID string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.users[userID] != nil
+}
+
+func (m *Memory) AddUser(userID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.users[userID] != nil {
+		return fmt.Errorf("%w: user %s already exists", ErrConstraint, userID)
+	}
+	m.users[userID] = &memoryUser{}
+	return nil
+}
+
+// user returns the user, or sql.ErrNoRows like a query for them would
+func (m *Memory) user(userID string) (*memoryUser, error) {
+	user := m.users[userID]
+	if user == nil {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *Memory) GetUserPlatformsAndDM(userID string) ([]string, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user, err := m.user(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	platforms := slices.Clone(user.platforms)
+	slices.SortStableFunc(platforms, func(a, b memoryPlatform) int {
+		return cmp.Or(cmp.Compare(a.createdUnix, b.createdUnix), cmp.Compare(a.platform, b.platform))
+	})
+	var names []string
+	for _, p := range platforms {
+		names = append(names, p.platform)
+	}
+	return names, user.dm != nil && *user.dm, nil
+}
+
+func (m *Memory) SetUserDM(userID string, dm bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil {
+		user.dm = &dm
+	}
+	return nil
+}
+
+func (m *Memory) SetUserPlatforms(userID string, platforms []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user := m.users[userID]
+	if user == nil {
+		if len(platforms) > 0 {
+			return fmt.Errorf("%w: user %s does not exist", ErrConstraint, userID)
+		}
+		return nil
+	}
+	t := time.Now().Unix()
+	user.platforms = nil
+	for _, platform := range platforms {
+		if !slices.ContainsFunc(user.platforms, func(p memoryPlatform) bool { return p.platform == platform }) {
+			user.platforms = append(user.platforms, memoryPlatform{platform: platform, createdUnix: t})
+		}
+	}
+	return nil
+}
+
+// GetUserGames returns the games a user wants codes redeemed for. Empty if they haven't picked any
+func (m *Memory) GetUserGames(userID string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil {
+		return slices.Clone(user.games), nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) SetUserGames(userID string, games []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user := m.users[userID]
+	if user == nil {
+		if len(games) > 0 {
+			return fmt.Errorf("%w: user %s does not exist", ErrConstraint, userID)
+		}
+		return nil
+	}
+	user.games = slices.Compact(slices.Sorted(slices.Values(games)))
+	return nil
+}
+
+func (m *Memory) GetUserCanary(userID string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user, err := m.user(userID)
+	if err != nil {
+		return false, err
+	}
+	return user.canary, nil
+}
+
+func (m *Memory) SetUserCanary(userID string, canary bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil {
+		user.canary = canary
+	}
+	return nil
+}
+
+// GetCanaryUsers returns the users who opted in to trying new codes and have stored cookies, most recently redeemed
+// first, since their sessions are the likeliest to work
+func (m *Memory) GetCanaryUsers() ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var users []string
+	for userID, user := range m.users {
+		if user.canary && user.cookies != nil {
+			users = append(users, userID)
+		}
+	}
+	slices.SortFunc(users, func(a, b string) int {
+		return cmp.Or(-compareNullsFirst(m.users[a].redemptionUnix, m.users[b].redemptionUnix), compareUserIDs(a, b))
+	})
+	return users, nil
+}
+
+func (m *Memory) GetUserBackoff(userID string) (UserBackoff, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user, err := m.user(userID)
+	if err != nil {
+		return UserBackoff{}, err
+	}
+	return user.backoff, nil
+}
+
+func (m *Memory) SetUserBackoff(userID string, backoff UserBackoff) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil {
+		user.backoff = backoff
+	}
+	return nil
+}
+
+// ResetUserBackoff ends a user's run of failures, after a success or a fresh login
+func (m *Memory) ResetUserBackoff(userID string) error {
+	return m.SetUserBackoff(userID, UserBackoff{})
+}
+
+func (m *Memory) UserCookiesExists(userID string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user := m.users[userID]
+	return user != nil && user.cookies != nil
+}
+
+func (m *Memory) EncryptAndSetUserCookies(userID string, cookies []*http.Cookie) error {
+	cookieJson, err := json.Marshal(cookies)
+	if err != nil {
+		return err
+	}
+	var expires *int64
+	for _, cookie := range cookies {
+		if cookie.Name == "si" && !cookie.Expires.IsZero() {
+			unix := cookie.Expires.Unix()
+			expires = &unix
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user := m.users[userID]
+	if user == nil {
+		return fmt.Errorf("%w: user %s does not exist", ErrConstraint, userID)
+	}
+	stored := &memoryCookies{json: cookieJson, expiresUnix: expires}
+	// new cookies need checking again, but users are only warned again if the session expires at a different time
+	if old := user.cookies; old != nil {
+		stored.refreshedUnix = old.refreshedUnix
+		stored.warned = old.warned && compareNullsFirst(old.expiresUnix, expires) == 0
+	}
+	user.cookies = stored
+	return nil
+}
+
+func (m *Memory) SetUserCookiesRefreshed(userID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil && user.cookies != nil {
+		user.cookies.refreshedUnix = time.Now().Unix()
+	}
+	return nil
+}
+
+// cookies returns the user's stored cookies, or sql.ErrNoRows like a query for them would
+func (m *Memory) cookies(userID string) (*memoryCookies, error) {
+	user := m.users[userID]
+	if user == nil || user.cookies == nil {
+		return nil, sql.ErrNoRows
+	}
+	return user.cookies, nil
+}
+
+func (m *Memory) GetDecryptedUserCookies(userID string) ([]*http.Cookie, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, err := m.cookies(userID)
+	if err != nil {
+		return nil, err
+	}
+	var cookies []*http.Cookie
+	err = json.Unmarshal(stored.json, &cookies)
+	if err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+func (m *Memory) GetUserSession(userID string) (Session, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, err := m.cookies(userID)
+	if err != nil {
+		return Session{}, err
+	}
+	session := Session{
+		State:         stored.state,
+		CheckedUnix:   stored.checkedUnix,
+		Warned:        stored.warned,
+		RefreshedUnix: stored.refreshedUnix,
+	}
+	if stored.expiresUnix != nil {
+		session.ExpiresUnix = *stored.expiresUnix
+	}
+	return session, nil
+}
+
+func (m *Memory) SetUserSession(userID string, session shift.Session) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, err := m.cookies(userID)
+	if err != nil {
+		return nil
+	}
+	stored.state = session.State
+	stored.expiresUnix = nil
+	if !session.Expires.IsZero() {
+		unix := session.Expires.Unix()
+		stored.expiresUnix = &unix
+	}
+	stored.checkedUnix = time.Now().Unix()
+	return nil
+}
+
+func (m *Memory) SetUserSessionWarned(userID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if stored, err := m.cookies(userID); err == nil {
+		stored.warned = true
+	}
+	return nil
+}
+
+func (m *Memory) DeleteUserCookies(userID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if user := m.users[userID]; user != nil {
+		user.cookies = nil
+	}
+	return nil
+}
+
+func (m *Memory) GetAllDecryptedUserCookiesSorted(limit int64) ([]UserCookies, error) {
+	m.lock.Lock()
+	var userIDs []string
+	for userID, user := range m.users {
+		if user.cookies != nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	// least recently redeemed first, and users who haven't redeemed anything before everyone else
+	slices.SortFunc(userIDs, func(a, b string) int {
+		return cmp.Or(compareNullsFirst(m.users[a].redemptionUnix, m.users[b].redemptionUnix), compareUserIDs(a, b))
+	})
+	m.lock.Unlock()
+
+	var userCookies []UserCookies
+	for _, userID := range limited(userIDs, int(limit)) {
+		cookies, err := m.GetDecryptedUserCookies(userID)
+		if err != nil {
+			return nil, err
+		}
+		userCookies = append(userCookies, UserCookies{UserID: userID, Cookies: cookies})
+	}
+	return userCookies, nil
+}
+
+func (m *Memory) CodeExists(code string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.codes[code] != nil
+}
+
+func (m *Memory) addCode(code, game string, userID *string, validation CodeValidation) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.codes[code] != nil {
+		return false, nil
+	}
+	c := &memoryCode{game: game, validation: validation, createdUnix: time.Now().Unix()}
+	if userID != nil {
+		if m.users[*userID] == nil {
+			return false, fmt.Errorf("%w: user %s does not exist", ErrConstraint, *userID)
+		}
+		c.userID = sql.NullString{String: *userID, Valid: true}
+	}
+	m.codes[code] = c
+	m.codeOrder = append(m.codeOrder, code)
+	return true, nil
+}
+
+func (m *Memory) AddCode(code, game string, userID *string, source *string) error {
+	_, err := m.addCode(code, game, userID, ValidationValid)
+	return err
+}
+
+// AddCodeForValidation adds a code that is only redeemed for canary accounts, until one of them has shown it's valid.
+// It returns false if the code already existed
+func (m *Memory) AddCodeForValidation(code, game string, userID *string, source *string) (bool, error) {
+	return m.addCode(code, game, userID, ValidationPending)
+}
+
+// GetPendingCodes returns the codes waiting to be tried on a canary account, oldest first
+func (m *Memory) GetPendingCodes() ([]PendingCode, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var codes []PendingCode
+	for _, code := range m.codeOrder {
+		c := m.codes[code]
+		if c.validation == ValidationPending {
+			codes = append(codes, PendingCode{Code: code, Game: c.game, UserID: c.userID, CreatedUnix: c.createdUnix})
+		}
+	}
+	slices.SortFunc(codes, func(a, b PendingCode) int {
+		return cmp.Or(cmp.Compare(a.CreatedUnix, b.CreatedUnix), cmp.Compare(a.Code, b.Code))
+	})
+	return codes, nil
+}
+
+func (m *Memory) SetCodeValidation(code string, validation CodeValidation) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if c := m.codes[code]; c != nil {
+		c.validation = validation
+	}
+	return nil
+}
+
+func (m *Memory) SetCodeRewardAndSuccess(code, reward string, success bool) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c := m.codes[code]
+	if c == nil {
+		return false, nil
+	}
+	if success {
+		t := time.Now().Unix()
+		c.successUnix = &t
+	}
+	if c.reward.Valid {
+		return false, nil
+	}
+	c.reward = sql.NullString{String: reward, Valid: true}
+	return true, nil
+}
+
+// validCodesNotRedeemed returns the codes that should be redeemed for a user on a platform, most recently-successful
+// first. It's the same condition as the database stores' validCodesNotRedeemed
+func (m *Memory) validCodesNotRedeemed(userID, platform string) []string {
+	var games []string
+	if user := m.users[userID]; user != nil {
+		games = user.games
+	}
+	var codes []string
+	for _, code := range m.codeOrder {
+		c := m.codes[code]
+		if c.validation != ValidationValid || m.validity[codePlatform{code, platform}] >= m.invalidCodeThreshold {
+			continue
+		}
+		if len(games) == 0 && c.game != string(shift.DefaultGame) || len(games) > 0 && !slices.Contains(games, c.game) {
+			continue
+		}
+		if slices.ContainsFunc(m.redemptions, func(r memoryRedemption) bool {
+			return r.code == code && r.userID == userID && r.platform == platform
+		}) {
+			continue
+		}
+		codes = append(codes, code)
+	}
+	slices.SortStableFunc(codes, func(a, b string) int {
+		return -compareNullsFirst(m.codes[a].successUnix, m.codes[b].successUnix)
+	})
+	return codes
+}
+
+func (m *Memory) GetValidCodesNotRedeemedForUser(userID, platform string, limit int) ([]ShiftCode, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var codes []ShiftCode
+	for _, code := range limited(m.validCodesNotRedeemed(userID, platform), limit) {
+		codes = append(codes, ShiftCode{Code: code, Game: m.codes[code].game})
+	}
+	return codes, nil
+}
+
+// CodeInvalidOnPlatform returns whether a code has had enough expired/not exist results on the platform (since its last
+// success) that it's no longer redeemed there
+func (m *Memory) CodeInvalidOnPlatform(code, platform string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.validity[codePlatform{code, platform}] >= m.invalidCodeThreshold, nil
+}
+
+// CodeFailed returns whether a code has had any expired/not exist results on any platform (since its last success
+// there), even if not enough to stop redeeming it
+func (m *Memory) CodeFailed(code string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, failures := range m.validity {
+		if key.code == code && failures > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RestoreCode clears the expired/not exist results recorded for a code, and marks it valid if a canary account found
+// it invalid, so it is redeemed again. If platform is empty,
+// the code is restored on every platform. Returns whether the code was considered invalid anywhere beforehand
+func (m *Memory) RestoreCode(code, platform string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	restored := false
+	for key, failures := range m.validity {
+		if key.code == code && (platform == "" || key.platform == platform) && failures >= m.invalidCodeThreshold {
+			m.validity[key] = 0
+			restored = true
+		}
+	}
+	// a canary account finding the code invalid counts on every platform
+	if c := m.codes[code]; c != nil && c.validation == ValidationInvalid {
+		c.validation = ValidationValid
+		restored = true
+	}
+	return restored, nil
+}
+
+// EnqueueRedemptionJobs adds a job for every code that should be redeemed for the user on the platform and doesn't have
+// one yet, and returns how many were added. Pending jobs that no longer need redeeming, like ones for a platform the
+// user dropped, are removed
+func (m *Memory) EnqueueRedemptionJobs(userID, platform string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t := time.Now().Unix()
+	codes := m.validCodesNotRedeemed(userID, platform)
+	var platforms []memoryPlatform
+	if user := m.users[userID]; user != nil {
+		platforms = user.platforms
+	}
+	for id, job := range m.jobs {
+		if job.UserID != userID || job.State != JobPending {
+			continue
+		}
+		if job.Platform == platform && !slices.Contains(codes, job.Code) ||
+			!slices.ContainsFunc(platforms, func(p memoryPlatform) bool { return p.platform == job.Platform }) {
+			delete(m.jobs, id)
+		}
+	}
+	if len(codes) > 0 && m.users[userID] == nil {
+		return 0, fmt.Errorf("%w: user %s does not exist", ErrConstraint, userID)
+	}
+
+	added := 0
+	for _, code := range codes {
+		exists := false
+		for _, job := range m.jobs {
+			if job.UserID == userID && job.Code == code && job.Platform == platform {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		m.lastID++
+		m.jobs[m.lastID] = &RedemptionJob{
+			ID:          m.lastID,
+			UserID:      userID,
+			Code:        code,
+			Game:        m.codes[code].game,
+			Platform:    platform,
+			State:       JobPending,
+			NextRunUnix: t,
+			CreatedUnix: t,
+			UpdatedUnix: t,
+		}
+		added++
+	}
+	return added, nil
+}
+
+// sortedJobs returns copies of the jobs that match, ordered by compare
+func (m *Memory) sortedJobs(match func(*RedemptionJob) bool, compare func(a, b RedemptionJob) int) []RedemptionJob {
+	var jobs []RedemptionJob
+	for _, job := range m.jobs {
+		if match(job) {
+			jobs = append(jobs, *job)
+		}
+	}
+	slices.SortFunc(jobs, compare)
+	return jobs
+}
+
+// ClaimRedemptionJobs marks up to limit of the user's pending jobs that are due as running, claimed by the worker until
+// the lease expires, and returns them. Jobs are only claimed for platforms the user still has, and the most
+// recently-successful codes come first
+func (m *Memory) ClaimRedemptionJobs(workerID, userID string, limit int, lease time.Duration) ([]RedemptionJob, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	t := now.Unix()
+	user := m.users[userID]
+	if user == nil {
+		return nil, nil
+	}
+	jobs := m.sortedJobs(func(job *RedemptionJob) bool {
+		return job.UserID == userID && job.State == JobPending && job.NextRunUnix <= t &&
+			slices.ContainsFunc(user.platforms, func(p memoryPlatform) bool { return p.platform == job.Platform })
+	}, func(a, b RedemptionJob) int {
+		return cmp.Or(-compareNullsFirst(m.codes[a.Code].successUnix, m.codes[b.Code].successUnix), cmp.Compare(a.ID, b.ID))
+	})
+	jobs = limited(jobs, limit)
+	for i := range jobs {
+		job := m.jobs[jobs[i].ID]
+		job.State = JobRunning
+		job.ClaimedBy = workerID
+		job.LeaseExpiresUnix = now.Add(lease).Unix()
+		job.UpdatedUnix = t
+		jobs[i] = *job
+	}
+	return jobs, nil
+}
+
+// FinishRedemptionJob removes a job that reached a final result, which is recorded as a redemption instead
+func (m *Memory) FinishRedemptionJob(id int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.jobs, id)
+	return nil
+}
+
+// RetryRedemptionJob records a failed attempt at a job, and leaves it pending until nextRun
+func (m *Memory) RetryRedemptionJob(id int64, nextRun time.Time, lastError string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if job := m.jobs[id]; job != nil {
+		job.State = JobPending
+		job.ClaimedBy, job.LeaseExpiresUnix = "", 0
+		job.Attempts++
+		job.NextRunUnix = nextRun.Unix()
+		job.LastError = lastError
+		job.UpdatedUnix = time.Now().Unix()
+	}
+	return nil
+}
+
+// ParkRedemptionJob records a failed attempt at a job, and stops it being claimed until it's requeued
+func (m *Memory) ParkRedemptionJob(id int64, lastError string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if job := m.jobs[id]; job != nil {
+		job.State = JobParked
+		job.ClaimedBy, job.LeaseExpiresUnix = "", 0
+		job.Attempts++
+		job.LastError = lastError
+		job.UpdatedUnix = time.Now().Unix()
+	}
+	return nil
+}
+
+// ReleaseRedemptionJob returns a claimed job that wasn't attempted to pending, without counting an attempt
+func (m *Memory) ReleaseRedemptionJob(id int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if job := m.jobs[id]; job != nil && job.State == JobRunning {
+		job.State = JobPending
+		job.ClaimedBy, job.LeaseExpiresUnix = "", 0
+		job.UpdatedUnix = time.Now().Unix()
+	}
+	return nil
+}
+
+// ResetRedemptionJobs returns running jobs whose lease has expired to pending, for jobs whose worker stopped without
+// finishing them. Jobs a worker is still redeeming are left alone. Returns how many there were
+func (m *Memory) ResetRedemptionJobs() (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t := time.Now().Unix()
+	reset := 0
+	for _, job := range m.jobs {
+		if job.State == JobRunning && job.LeaseExpiresUnix <= t {
+			job.State = JobPending
+			job.ClaimedBy, job.LeaseExpiresUnix = "", 0
+			job.UpdatedUnix = t
+			reset++
+		}
+	}
+	return reset, nil
+}
+
+// GetUsersWithDueRedemptionJobs returns the users with pending jobs that are due to be claimed, leaving out users whose
+// redemptions are backing off
+func (m *Memory) GetUsersWithDueRedemptionJobs() ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t := time.Now().Unix()
+	var users []string
+	for _, job := range m.jobs {
+		if job.State == JobPending && job.NextRunUnix <= t && m.users[job.UserID].backoff.NextAttemptUnix <= t &&
+			!slices.Contains(users, job.UserID) {
+			users = append(users, job.UserID)
+		}
+	}
+	slices.SortFunc(users, compareUserIDs)
+	return users, nil
+}
+
+// GetRedemptionJobs returns up to limit jobs in a state, most recently updated first. A limit of zero or less returns
+// them all
+func (m *Memory) GetRedemptionJobs(state RedemptionJobState, limit int) ([]RedemptionJob, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if limit <= 0 {
+		limit = -1
+	}
+	jobs := m.sortedJobs(func(job *RedemptionJob) bool {
+		return job.State == state
+	}, func(a, b RedemptionJob) int {
+		return cmp.Or(-cmp.Compare(a.UpdatedUnix, b.UpdatedUnix), -cmp.Compare(a.ID, b.ID))
+	})
+	return limited(jobs, limit), nil
+}
+
+// RequeueRedemptionJob returns a parked job to pending with no attempts, due straight away. Returns whether the job
+// was parked
+func (m *Memory) RequeueRedemptionJob(id int64) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job := m.jobs[id]
+	if job == nil || job.State != JobParked {
+		return false, nil
+	}
+	t := time.Now().Unix()
+	job.State = JobPending
+	job.Attempts = 0
+	job.NextRunUnix = t
+	job.UpdatedUnix = t
+	return true, nil
+}
+
+// RedemptionExists returns whether a result was recorded for redeeming the code for the user on the platform
+func (m *Memory) RedemptionExists(userID, code, platform string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return slices.ContainsFunc(m.redemptions, func(r memoryRedemption) bool {
+		return r.userID == userID && r.code == code && r.platform == platform
+	})
+}
+
+func (m *Memory) GetRecentRedemptionsForUser(userID string, status string, quantity int) ([]Redemption, error) {
+	if quantity <= 0 {
+		return nil, nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var redemptions []Redemption
+	// newest first, and the last added first when they were added at the same time
+	for _, r := range slices.Backward(m.redemptions) {
+		if r.userID != userID || status != "" && r.status != status {
+			continue
+		}
+		c := m.codes[r.code]
+		redemptions = append(redemptions, Redemption{
+			Code:     r.code,
+			Platform: r.platform,
+			Status:   r.status,
+			TimeUnix: r.createdUnix,
+			Game:     c.game,
+			Reward:   c.reward,
+		})
+	}
+	slices.SortStableFunc(redemptions, func(a, b Redemption) int {
+		return -cmp.Compare(a.TimeUnix, b.TimeUnix)
+	})
+	return limited(redemptions, quantity), nil
+}
+
+func (m *Memory) RedemptionSummaryForUser(userID string) (map[string]int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	summary := map[string]int64{
+		"total":            0,
+		"success":          0,
+		"already_redeemed": 0,
+	}
+	for _, r := range m.redemptions {
+		if r.userID != userID {
+			continue
+		}
+		summary["total"]++
+		switch r.status {
+		case shift.SUCCESS:
+			summary["success"]++
+		case shift.ALREADY_REDEEMED:
+			summary["already_redeemed"]++
+		}
+	}
+	return summary, nil
+}
+
+func (m *Memory) AddRedemption(userID, code string, result shift.RedeemResult) error {
+	status := result.Type.Status()
+	if status == "" {
+		return ErrUnrecognizedRedemption
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	user := m.users[userID]
+	if user == nil || m.codes[code] == nil {
+		return fmt.Errorf("%w: user %s or code %s does not exist", ErrConstraint, userID, code)
+	}
+	platform := string(result.Platform)
+	if slices.ContainsFunc(m.redemptions, func(r memoryRedemption) bool {
+		return r.code == code && r.userID == userID && r.platform == platform
+	}) {
+		return fmt.Errorf("%w: code %s was already redeemed for user %s on %s", ErrConstraint, code, userID, platform)
+	}
+	t := time.Now().Unix()
+	m.redemptions = append(m.redemptions, memoryRedemption{code: code, userID: userID, platform: platform, status: status, createdUnix: t})
+	user.redemptionUnix = &t
+
+	// expired/not exist results count towards the code being invalid on the platform, and a success or already redeemed
+	// result contradicts them
+	key := codePlatform{code, platform}
+	switch result.Type {
+	case shift.Expired, shift.Invalid:
+		m.validity[key]++
+	case shift.Success, shift.AlreadyRedeemed:
+		m.validity[key] = 0
+	}
+	return nil
+}
+
+func (m *Memory) addReward(userID string, code *string, entry shift.RewardEntry, t int64) {
+	m.lastID++
+	reward := memoryReward{
+		id:     m.lastID,
+		userID: userID,
+		UserReward: UserReward{
+			Platform:    string(entry.Platform),
+			Game:        string(entry.Game),
+			Title:       entry.Title,
+			Description: entry.Description,
+			CreatedUnix: t,
+		},
+		unlocked:   unlockedUnix(entry.Date),
+		goldenKeys: shift.GoldenKeys(entry.Title),
+	}
+	if reward.unlocked != nil {
+		reward.UnlockedUnix = *reward.unlocked
+	}
+	if code != nil {
+		reward.Code = sql.NullString{String: *code, Valid: true}
+	}
+	m.rewards = append(m.rewards, reward)
+}
+
+func (m *Memory) AddUserReward(userID, code string, entry shift.RewardEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.users[userID] == nil || m.codes[code] == nil {
+		return fmt.Errorf("%w: user %s or code %s does not exist", ErrConstraint, userID, code)
+	}
+	m.addReward(userID, &code, entry, time.Now().Unix())
+	return nil
+}
+
+// SyncUserRewards stores the rewards in the snapshot that aren't stored for the user yet, and returns how many there
+// were. Rewards are matched by everything SHiFT shows about them, so a reward unlocked twice is stored twice
+func (m *Memory) SyncUserRewards(userID string, snapshot *shift.RewardsSnapshot) (int, error) {
+	type key struct {
+		platform, game, title, description string
+		unlocked                           int64
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored := map[key]int{}
+	for _, r := range m.rewards {
+		if r.userID == userID {
+			stored[key{r.Platform, r.Game, r.Title, r.Description, r.UnlockedUnix}]++
+		}
+	}
+
+	t := time.Now().Unix()
+	added := 0
+	for _, entry := range snapshot.Entries {
+		k := key{string(entry.Platform), string(entry.Game), entry.Title, entry.Description, 0}
+		if unlocked := unlockedUnix(entry.Date); unlocked != nil {
+			k.unlocked = *unlocked
+		}
+		if stored[k] > 0 {
+			stored[k]--
+			continue
+		}
+		if m.users[userID] == nil {
+			return 0, fmt.Errorf("%w: user %s does not exist", ErrConstraint, userID)
+		}
+		m.addReward(userID, nil, entry, t)
+		added++
+	}
+	return added, nil
+}
+
+// GetUserRewards returns the user's rewards, most recently unlocked first. A limit of zero or less returns them all
+func (m *Memory) GetUserRewards(userID string, limit int) ([]UserReward, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if limit <= 0 {
+		limit = -1
+	}
+	var rewards []memoryReward
+	for _, r := range m.rewards {
+		if r.userID == userID {
+			rewards = append(rewards, r)
+		}
+	}
+	slices.SortFunc(rewards, func(a, b memoryReward) int {
+		return cmp.Or(-cmp.Compare(cmp.Or(a.UnlockedUnix, a.CreatedUnix), cmp.Or(b.UnlockedUnix, b.CreatedUnix)), -cmp.Compare(a.id, b.id))
+	})
+	var userRewards []UserReward
+	for _, r := range limited(rewards, limit) {
+		userRewards = append(userRewards, r.UserReward)
+	}
+	return userRewards, nil
+}
+
+// GetUserGoldenKeys counts the user's golden keys by platform and the month they were unlocked, newest month first.
+// Rewards without an unlock date count toward the month SlickShift first saw them
+func (m *Memory) GetUserGoldenKeys(userID string) ([]GoldenKeys, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []GoldenKeys
+	for _, r := range m.rewards {
+		if r.userID != userID || r.goldenKeys <= 0 {
+			continue
+		}
+		month := time.Unix(cmp.Or(r.UnlockedUnix, r.CreatedUnix), 0).UTC().Format("2006-01")
+		i := slices.IndexFunc(keys, func(k GoldenKeys) bool { return k.Platform == r.Platform && k.Month == month })
+		if i < 0 {
+			keys = append(keys, GoldenKeys{Platform: r.Platform, Month: month})
+			i = len(keys) - 1
+		}
+		keys[i].Total += int64(r.goldenKeys)
+		if r.Code.Valid {
+			keys[i].Earned += int64(r.goldenKeys)
+		}
+	}
+	slices.SortFunc(keys, func(a, b GoldenKeys) int {
+		return cmp.Or(-cmp.Compare(a.Month, b.Month), cmp.Compare(a.Platform, b.Platform))
+	})
+	return keys, nil
+}
+
+func (m *Memory) AddShiftError(userID, code, platform, error string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.users[userID] == nil || m.codes[code] == nil {
+		return fmt.Errorf("%w: user %s or code %s does not exist", ErrConstraint, userID, code)
+	}
+	m.errors = append(m.errors, memoryError{userID: userID, text: error, createdUnix: time.Now().Unix()})
+	return nil
+}
+
+func (m *Memory) GetShiftErrors(userID string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var userErrors []memoryError
+	for _, e := range m.errors {
+		if e.userID == userID {
+			userErrors = append(userErrors, e)
+		}
+	}
+	slices.SortStableFunc(userErrors, func(a, b memoryError) int {
+		return -cmp.Compare(a.createdUnix, b.createdUnix)
+	})
+	var errs []string
+	for _, e := range userErrors {
+		errs = append(errs, e.text)
+	}
+	return errs, nil
+}
+
+func (m *Memory) ClearShiftErrors(userID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.errors = slices.DeleteFunc(m.errors, func(e memoryError) bool {
+		return e.userID == userID
+	})
+	return nil
+}
+
+func (m *Memory) GetStatistics(userID string) (Statistics, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := Statistics{
+		Users:       map[string]int64{"total": int64(len(m.users)), "steam": 0, "epic": 0, "xbox": 0, "psn": 0},
+		Codes:       map[string]int64{"total": int64(len(m.codes)), "golden_key": 0, "other": 0, "unknown": 0},
+		Redemptions: map[string]int64{"total": int64(len(m.redemptions)), "expired": 0, "already_redeemed": 0, "success": 0},
+	}
+	platforms := map[shift.Platform]string{shift.Steam: "steam", shift.Epic: "epic", shift.XboxLive: "xbox", shift.PSN: "psn"}
+	for _, user := range m.users {
+		for _, p := range user.platforms {
+			if name, ok := platforms[shift.Platform(p.platform)]; ok {
+				stats.Users[name]++
+			}
+		}
+	}
+	for _, c := range m.codes {
+		switch {
+		case !c.reward.Valid:
+			stats.Codes["unknown"]++
+		case c.reward.String == shift.GoldenKey:
+			stats.Codes["golden_key"]++
+		default:
+			stats.Codes["other"]++
+		}
+	}
+	for _, r := range m.redemptions {
+		switch r.status {
+		case shift.EXPIRED:
+			stats.Redemptions["expired"]++
+		case shift.ALREADY_REDEEMED:
+			stats.Redemptions["already_redeemed"]++
+		case shift.SUCCESS:
+			stats.Redemptions["success"]++
+		}
+	}
+	return stats, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
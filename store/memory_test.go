@@ -0,0 +1,24 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/denverquane/slickshift/store"
+	"github.com/denverquane/slickshift/store/storetest"
+)
+
+func newMemoryTestDB(t *testing.T, opts ...store.Option) store.Store {
+	return store.NewMemoryStore(opts...)
+}
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, newMemoryTestDB)
+}
+
+func TestMemoryStore_Shared(t *testing.T) {
+	// a memory store can't be shared between processes, but the same one can be used by two workers
+	storetest.RunShared(t, func(t *testing.T) (store.Store, store.Store) {
+		st := store.NewMemoryStore()
+		return st, st
+	})
+}
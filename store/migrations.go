@@ -0,0 +1,367 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMigrationChanged is returned when a migration script was edited after it was applied to the database. The
+// script should be put back the way it was, and the change made in a new migration instead
+var ErrMigrationChanged = errors.New("migration changed since it was applied")
+
+// migration is a numbered script in a dialect's directory, like 3.sql, along with 3.down.sql to undo it, if there is one
+type migration struct {
+	version  int64
+	path     string
+	up       string
+	down     string
+	checksum string
+}
+
+// dialect is what migrating needs to know about a kind of database
+type dialect struct {
+	fsys fs.FS
+	dir  string
+	// lock and unlock are run around migrating, so processes sharing the database don't migrate it at the same time
+	lock, unlock string
+	// legacyVersion returns the last migration applied before they were recorded in schema_migrations, and clears it
+	legacyVersion func(ctx context.Context, tx *sql.Tx) (int64, error)
+}
+
+// Migrator applies the migrations for a database, each in its own transaction. Every migration applied is recorded in
+// the schema_migrations table with a checksum of its script
+type Migrator struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// MigrationStatus is whether a migration was applied to the database
+type MigrationStatus struct {
+	Version     int64
+	Applied     bool
+	AppliedUnix int64
+	// Changed is whether the script was edited after it was applied
+	Changed bool
+	// Unknown is whether the migration was applied but there's no script for it, like when the database was migrated
+	// by a newer version of SlickShift
+	Unknown bool
+	// Reversible is whether there's a down migration to undo it
+	Reversible bool
+}
+
+// NewSqliteMigrator opens the SQLite database at filepath for migrating, without applying anything yet
+func NewSqliteMigrator(filepath string) (*Migrator, error) {
+	db, err := openSqlite(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: sqliteDialect}, nil
+}
+
+// NewPostgresMigrator connects to the database at url for migrating, without applying anything yet
+func NewPostgresMigrator(url string) (*Migrator, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: postgresDialect}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// readMigrations lists the numbered .sql scripts in a directory in the order they're applied. That has to be numeric
+// order, but directories are listed in lexical order (10.sql before 2.sql)
+func readMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	byVersion := map[int64]*migration{}
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".sql") {
+			return nil
+		}
+		name, isDown := strings.CutSuffix(strings.TrimSuffix(d.Name(), ".sql"), ".down")
+		val, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			return err
+		}
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		m := byVersion[val]
+		if m == nil {
+			m = &migration{version: val}
+			byVersion[val] = m
+		}
+		if isDown {
+			m.down = string(contents)
+			return nil
+		}
+		if m.up != "" {
+			return fmt.Errorf("more than one migration for version %d in %s", val, dir)
+		}
+		sum := sha256.Sum256(contents)
+		m.path = p
+		m.up = string(contents)
+		m.checksum = hex.EncodeToString(sum[:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("down migration for version %d in %s has nothing to undo", m.version, dir)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return cmp.Compare(a.version, b.version)
+	})
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	checksum    string
+	appliedUnix int64
+}
+
+// withConn runs f on a single connection, holding the dialect's lock
+func (m *Migrator) withConn(f func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	// the lock belongs to the connection, so everything has to happen on the same one
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.dialect.lock != "" {
+		_, err = conn.ExecContext(ctx, m.dialect.lock)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, m.dialect.unlock)
+	}
+	return f(ctx, conn)
+}
+
+// applied returns the migrations recorded in schema_migrations, creating it first if needed. Migrations that were
+// applied before they were recorded there are added to it
+func (m *Migrator) applied(ctx context.Context, tx *sql.Tx, migrations []migration) (map[int64]appliedMigration, error) {
+	_, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, "+
+		"checksum TEXT NOT NULL, applied_unix BIGINT NOT NULL)")
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := m.dialect.legacyVersion(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Now().Unix()
+	for _, migration := range migrations {
+		if migration.version > legacy {
+			break
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, checksum, applied_unix) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (version) DO NOTHING", migration.version, migration.checksum, t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT version, checksum, applied_unix FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int64]appliedMigration{}
+	for rows.Next() {
+		var version int64
+		var a appliedMigration
+		err = rows.Scan(&version, &a.checksum, &a.appliedUnix)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// status works out the status of every migration, from the scripts and what was applied to the database
+func status(migrations []migration, applied map[int64]appliedMigration) []MigrationStatus {
+	var statuses []MigrationStatus
+	for _, migration := range migrations {
+		a, ok := applied[migration.version]
+		statuses = append(statuses, MigrationStatus{
+			Version:     migration.version,
+			Applied:     ok,
+			AppliedUnix: a.appliedUnix,
+			Changed:     ok && a.checksum != migration.checksum,
+			Reversible:  migration.down != "",
+		})
+	}
+	for version, a := range applied {
+		if !slices.ContainsFunc(migrations, func(m migration) bool { return m.version == version }) {
+			statuses = append(statuses, MigrationStatus{Version: version, Applied: true, AppliedUnix: a.appliedUnix, Unknown: true})
+		}
+	}
+	slices.SortFunc(statuses, func(a, b MigrationStatus) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return statuses
+}
+
+// load reads the migrations, and what was applied to the database
+func (m *Migrator) load(ctx context.Context, tx *sql.Tx) ([]migration, map[int64]appliedMigration, error) {
+	migrations, err := readMigrations(m.dialect.fsys, m.dialect.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	applied, err := m.applied(ctx, tx, migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrations, applied, nil
+}
+
+// prepare is load for changing the database. It returns an error if a script that was applied has changed since
+func (m *Migrator) prepare(ctx context.Context, conn *sql.Conn) ([]migration, map[int64]appliedMigration, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+	migrations, applied, err := m.load(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range status(migrations, applied) {
+		if s.Changed {
+			return nil, nil, fmt.Errorf("%w: version %d", ErrMigrationChanged, s.Version)
+		}
+		if s.Unknown {
+			slog.Warn("Database has a migration this version doesn't know about", "version", s.Version)
+		}
+	}
+	return migrations, applied, tx.Commit()
+}
+
+// Status returns the status of every migration, in the order they're applied. Nothing is changed, so it also shows what
+// Up would apply
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withConn(func(ctx context.Context, conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// rolled back, so migrations that were applied before schema_migrations existed aren't recorded yet
+		defer tx.Rollback()
+		migrations, applied, err := m.load(ctx, tx)
+		if err != nil {
+			return err
+		}
+		statuses = status(migrations, applied)
+		return nil
+	})
+	return statuses, err
+}
+
+// apply runs a script and updates schema_migrations in the same transaction, so a script that fails part way through
+// leaves the database as it was
+func apply(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every migration that hasn't been applied yet, in order, and returns their versions. It stops at the first
+// one that fails
+func (m *Migrator) Up() ([]int64, error) {
+	var versions []int64
+	err := m.withConn(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, applied, err := m.prepare(ctx, conn)
+		if err != nil {
+			return err
+		}
+		var version int64
+		for v := range applied {
+			version = max(version, v)
+		}
+		slog.Info("initialized db", "version", version)
+		for _, migration := range migrations {
+			if _, ok := applied[migration.version]; ok {
+				continue
+			}
+			slog.Info("applying migration script", "version", migration.version, "path", migration.path)
+			err = apply(ctx, conn, migration.up, "INSERT INTO schema_migrations (version, checksum, applied_unix) VALUES ($1, $2, $3)",
+				migration.version, migration.checksum, time.Now().Unix())
+			if err != nil {
+				return fmt.Errorf("migration %d: %w", migration.version, err)
+			}
+			versions = append(versions, migration.version)
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// Down undoes every applied migration after version, newest first, and returns their versions. Nothing is undone if
+// any of them doesn't have a down migration
+func (m *Migrator) Down(version int64) ([]int64, error) {
+	var versions []int64
+	err := m.withConn(func(ctx context.Context, conn *sql.Conn) error {
+		migrations, applied, err := m.prepare(ctx, conn)
+		if err != nil {
+			return err
+		}
+		var undo []migration
+		for _, s := range slices.Backward(status(migrations, applied)) {
+			if !s.Applied || s.Version <= version {
+				continue
+			}
+			if !s.Reversible {
+				return fmt.Errorf("migration %d has no down migration", s.Version)
+			}
+			i := slices.IndexFunc(migrations, func(m migration) bool { return m.version == s.Version })
+			undo = append(undo, migrations[i])
+		}
+		for _, migration := range undo {
+			slog.Info("undoing migration script", "version", migration.version, "path", migration.path)
+			err = apply(ctx, conn, migration.down, "DELETE FROM schema_migrations WHERE version = $1", migration.version)
+			if err != nil {
+				return fmt.Errorf("down migration %d: %w", migration.version, err)
+			}
+			versions = append(versions, migration.version)
+		}
+		return nil
+	})
+	return versions, err
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestMigrator(t *testing.T, fsys fstest.MapFS) *Migrator {
+	db, err := openSqlite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return &Migrator{db: db, dialect: dialect{fsys: fsys, dir: "migrations", legacyVersion: sqliteDialect.legacyVersion}}
+}
+
+func script(sql string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(sql)}
+}
+
+func tableExists(t *testing.T, m *Migrator, table string) bool {
+	var exists bool
+	err := m.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+// 10.sql sorts before 2.sql, but has to be applied after it
+func TestMigrator_NumericOrder(t *testing.T) {
+	m := newTestMigrator(t, fstest.MapFS{
+		"migrations/1.sql":  script("CREATE TABLE a (id INTEGER);"),
+		"migrations/2.sql":  script("CREATE TABLE b (id INTEGER);"),
+		"migrations/10.sql": script("ALTER TABLE b ADD COLUMN name TEXT;"),
+	})
+
+	versions, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{1, 2, 10}) {
+		t.Fatal("Expected migrations 1, 2 and 10 to be applied in order, got ", versions)
+	}
+	versions, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Fatal("Expected nothing to be applied twice, got ", versions)
+	}
+}
+
+// a script that fails part way through leaves the database as it was before the script
+func TestMigrator_FailedMigrationRollsBack(t *testing.T) {
+	m := newTestMigrator(t, fstest.MapFS{
+		"migrations/1.sql": script("CREATE TABLE a (id INTEGER);"),
+		"migrations/2.sql": script("CREATE TABLE b (id INTEGER); INSERT INTO missing VALUES (1);"),
+	})
+
+	versions, err := m.Up()
+	if err == nil {
+		t.Fatal("Expected the broken migration to fail")
+	}
+	if !slices.Equal(versions, []int64{1}) {
+		t.Fatal("Expected only migration 1 to be applied, got ", versions)
+	}
+	if tableExists(t, m, "b") {
+		t.Fatal("Expected the broken migration to be rolled back")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Fatal("Expected only migration 1 to be recorded, got ", statuses)
+	}
+}
+
+func TestMigrator_ChangedMigration(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1.sql": script("CREATE TABLE a (id INTEGER);"),
+	}
+	m := newTestMigrator(t, fsys)
+	_, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys["migrations/1.sql"] = script("CREATE TABLE a (id INTEGER, name TEXT);")
+	fsys["migrations/2.sql"] = script("CREATE TABLE b (id INTEGER);")
+	_, err = m.Up()
+	if !errors.Is(err, ErrMigrationChanged) {
+		t.Fatal("Expected ErrMigrationChanged, got ", err)
+	}
+	if tableExists(t, m, "b") {
+		t.Fatal("Expected nothing to be applied after a migration changed")
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Changed || statuses[1].Applied {
+		t.Fatal("Unexpected statuses ", statuses)
+	}
+}
+
+// the status shows what would be applied, without applying anything
+func TestMigrator_Status(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1.sql":      script("CREATE TABLE a (id INTEGER);"),
+		"migrations/1.down.sql": script("DROP TABLE a;"),
+	}
+	m := newTestMigrator(t, fsys)
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Applied || !statuses[0].Reversible {
+		t.Fatal("Unexpected statuses ", statuses)
+	}
+	if tableExists(t, m, "a") || tableExists(t, m, "schema_migrations") {
+		t.Fatal("Expected the status not to change the database")
+	}
+
+	_, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(fsys, "migrations/1.sql")
+	delete(fsys, "migrations/1.down.sql")
+	fsys["migrations/2.sql"] = script("CREATE TABLE b (id INTEGER);")
+	statuses, err = m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[0].AppliedUnix == 0 || !statuses[0].Unknown || statuses[1].Applied {
+		t.Fatal("Expected a migration without a script to be unknown, got ", statuses)
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1.sql":      script("CREATE TABLE a (id INTEGER);"),
+		"migrations/2.sql":      script("CREATE TABLE b (id INTEGER);"),
+		"migrations/3.sql":      script("CREATE TABLE c (id INTEGER);"),
+		"migrations/3.down.sql": script("DROP TABLE c;"),
+	}
+	m := newTestMigrator(t, fsys)
+	_, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing is undone unless every migration can be
+	_, err = m.Down(1)
+	if err == nil {
+		t.Fatal("Expected an error undoing a migration without a down migration")
+	}
+	if !tableExists(t, m, "c") {
+		t.Fatal("Expected nothing to be undone")
+	}
+
+	versions, err := m.Down(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{3}) || tableExists(t, m, "c") {
+		t.Fatal("Expected migration 3 to be undone, got ", versions)
+	}
+	versions, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{3}) {
+		t.Fatal("Expected migration 3 to be applied again, got ", versions)
+	}
+}
+
+// databases migrated before schema_migrations existed only recorded the last version in PRAGMA user_version
+func TestMigrator_LegacyVersion(t *testing.T) {
+	m := newTestMigrator(t, fstest.MapFS{
+		"migrations/1.sql": script("CREATE TABLE a (id INTEGER);"),
+		"migrations/2.sql": script("CREATE TABLE b (id INTEGER);"),
+		"migrations/3.sql": script("CREATE TABLE c (id INTEGER);"),
+	})
+	_, err := m.db.Exec("CREATE TABLE a (id INTEGER); CREATE TABLE b (id INTEGER); PRAGMA user_version = 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{3}) {
+		t.Fatal("Expected only migration 3 to be applied, got ", versions)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Fatal("Expected every migration to be recorded, got ", statuses)
+		}
+	}
+}
+
+func TestMigrator_SqliteDown(t *testing.T) {
+	m, err := NewSqliteMigrator(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	_, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := statuses[len(statuses)-1].Version
+	versions, err := m.Down(latest - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{latest}) {
+		t.Fatal("Expected the latest migration to be undone, got ", versions)
+	}
+	versions, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []int64{latest}) {
+		t.Fatal("Expected the latest migration to be applied again, got ", versions)
+	}
+}
+
+// every SQLite migration can be undone, back to an empty database, and applied again
+func TestMigrator_SqliteDownAll(t *testing.T) {
+	m, err := NewSqliteMigrator(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.db.Exec("INSERT INTO users (id, should_dm, updated_unix, created_unix) VALUES (1, 0, 0, 0); " +
+		"INSERT INTO user_platforms (user_id, platform, created_unix) VALUES (1, 'steam', 0);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// before user_platforms, a user's platform was stored with them
+	_, err = m.Down(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var platform string
+	err = m.db.QueryRow("SELECT platform FROM users WHERE id = 1").Scan(&platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if platform != "steam" {
+		t.Fatal("Expected the user's platform to be moved back, got ", platform)
+	}
+
+	_, err = m.Down(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, m, "users") {
+		t.Fatal("Expected every table to be dropped")
+	}
+	versions, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, applied) {
+		t.Fatal("Expected every migration to be applied again, got ", versions)
+	}
+}
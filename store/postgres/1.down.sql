@@ -0,0 +1,10 @@
+DROP TABLE redemption_jobs;
+DROP TABLE user_rewards;
+DROP TABLE code_validity;
+DROP TABLE shift_errors;
+DROP TABLE redemptions;
+DROP TABLE shift_codes;
+DROP TABLE user_games;
+DROP TABLE user_platforms;
+DROP TABLE user_cookies;
+DROP TABLE users;
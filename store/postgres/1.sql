@@ -0,0 +1,128 @@
+-- the schema from sqlite/1.sql through sqlite/12.sql, in one go since there's no existing data to migrate
+CREATE TABLE users (
+    id BIGINT PRIMARY KEY,
+    should_dm BOOLEAN,
+    redemption_unix BIGINT, -- last time ANY code was redeemed (success or not)
+    consecutive_failures INTEGER NOT NULL DEFAULT 0, -- redemptions that failed in a row, for reasons that are likely the user's
+    next_attempt_unix BIGINT NOT NULL DEFAULT 0, -- the user's codes aren't redeemed before this time
+    failure_warned BOOLEAN NOT NULL DEFAULT FALSE, -- whether the user was told about the current run of failures
+    canary BOOLEAN NOT NULL DEFAULT FALSE, -- whether the user opted in to trying new codes before everyone else
+    updated_unix BIGINT NOT NULL,
+    created_unix BIGINT NOT NULL
+);
+
+CREATE TABLE user_cookies (
+    user_id BIGINT PRIMARY KEY,
+    encrypted_cookie_json TEXT NOT NULL,
+    session_state TEXT, -- valid, expiring_soon or invalid; NULL until the session is checked
+    session_expires_unix BIGINT, -- when the si cookie expires, if known
+    session_checked_unix BIGINT,
+    session_warned BOOLEAN NOT NULL DEFAULT FALSE, -- whether the user was warned their session is expiring
+    refreshed_unix BIGINT, -- last time SHiFT rotated the cookies and they were stored again
+    updated_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_platforms (
+    user_id BIGINT NOT NULL,
+    platform TEXT NOT NULL,
+    created_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, platform)
+);
+
+CREATE TABLE user_games (
+    user_id BIGINT NOT NULL,
+    game TEXT NOT NULL,
+    created_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, game)
+);
+
+CREATE TABLE shift_codes (
+    code TEXT NOT NULL PRIMARY KEY,
+    game TEXT NOT NULL,
+    reward TEXT,
+    source TEXT, -- where the code was sourced, if not by a user (twitter, instagram, etc)
+    user_id BIGINT, -- who added/registered the code
+    validation TEXT NOT NULL DEFAULT 'valid', -- pending until a canary account has tried the code, then valid or invalid
+    success_unix BIGINT, -- last time the code was redeemed successfully
+    created_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE redemptions (
+    code TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    platform TEXT NOT NULL,
+    status TEXT NOT NULL,
+
+    created_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (code, user_id, platform)
+);
+
+CREATE TABLE shift_errors (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    code TEXT NOT NULL,
+    platform TEXT NOT NULL,
+    error TEXT NOT NULL,
+    created_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id),
+    FOREIGN KEY (code) REFERENCES shift_codes (code)
+);
+
+CREATE TABLE code_validity (
+    code TEXT NOT NULL,
+    platform TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0, -- expired/not exist results since the last successful redemption
+    updated_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE CASCADE,
+    PRIMARY KEY (code, platform)
+);
+
+CREATE TABLE user_rewards (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    platform TEXT NOT NULL,
+    game TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    unlocked_unix BIGINT, -- the day SHiFT says the reward was unlocked (midnight UTC), NULL if it couldn't be parsed
+    code TEXT, -- the code SlickShift redeemed to unlock the reward, if it did
+    golden_keys INTEGER NOT NULL DEFAULT 0, -- how many golden keys the reward is worth
+    created_unix BIGINT NOT NULL, -- when SlickShift first saw the reward
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE SET NULL
+);
+
+CREATE INDEX user_rewards_user_id ON user_rewards (user_id, unlocked_unix);
+
+CREATE TABLE redemption_jobs (
+    id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    code TEXT NOT NULL,
+    platform TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'pending', -- pending, running (claimed by a worker) or parked (failed too many times)
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_run_unix BIGINT NOT NULL, -- the job isn't claimed before this time, so failures back off
+    last_error TEXT,
+    created_unix BIGINT NOT NULL,
+    updated_unix BIGINT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE CASCADE,
+    UNIQUE (user_id, code, platform)
+);
+
+CREATE INDEX redemption_jobs_state ON redemption_jobs (state, next_run_unix);
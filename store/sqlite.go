@@ -7,12 +7,8 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"io/fs"
 	"log"
-	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/denverquane/slickshift/shift"
@@ -21,74 +17,63 @@ import (
 )
 
 //go:embed sqlite/*.sql
-var schemaFS embed.FS
+var sqliteSchemaFS embed.FS
 
 type Sqlite struct {
 	db        *sql.DB
 	encryptor *Encryptor
+	options
 }
 
-func NewSqliteStore(filepath string, encryptor *Encryptor) (Store, error) {
+// sqliteDialect migrates SQLite databases, which used to record the last migration applied in PRAGMA user_version.
+// There's only ever one connection to the database, so it doesn't need a lock
+var sqliteDialect = dialect{
+	fsys: sqliteSchemaFS,
+	dir:  "sqlite",
+	legacyVersion: func(ctx context.Context, tx *sql.Tx) (int64, error) {
+		var version int64
+		err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+		if err != nil || version == 0 {
+			return version, err
+		}
+		_, err = tx.ExecContext(ctx, "PRAGMA user_version = 0")
+		return version, err
+	},
+}
+
+func openSqlite(filepath string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", filepath)
 	if err != nil {
 		return nil, err
 	}
+	// sqlite only allows one writer at a time, so sharing one connection between the daemon's workers and the handlers
+	// queues them up instead of failing with SQLITE_BUSY. It also keeps the foreign_keys pragma, which is per connection
+	db.SetMaxOpenConns(1)
 	_, err = db.Exec("PRAGMA foreign_keys = ON")
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	currentVersion, err := getVersion(db)
+	return db, nil
+}
+
+func NewSqliteStore(filepath string, encryptor *Encryptor, opts ...Option) (Store, error) {
+	db, err := openSqlite(filepath)
 	if err != nil {
 		return nil, err
 	}
-	slog.Info("initialized db", "version", currentVersion)
-
-	err = fs.WalkDir(schemaFS, "sqlite", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() && strings.HasSuffix(d.Name(), ".sql") {
-			name := strings.TrimSuffix(d.Name(), ".sql")
-			val, err := strconv.ParseInt(name, 10, 64)
-			if err != nil {
-				return err
-			}
-			if val > currentVersion {
-				contents, err := schemaFS.ReadFile(path)
-				if err != nil {
-					return err
-				}
-				slog.Info("applying migration script", "version", val, "path", path)
-				_, err = db.Exec(string(contents))
-				if err != nil {
-					return err
-				}
-				err = setVersion(db, val)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return nil
-	})
+	migrator := &Migrator{db: db, dialect: sqliteDialect}
+	_, err = migrator.Up()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &Sqlite{db: db, encryptor: encryptor}, nil
-}
-
-func getVersion(db *sql.DB) (int64, error) {
-	var version int64
-	err := db.QueryRow("PRAGMA user_version;").Scan(&version)
-	if err != nil {
-		return -1, err
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
 	}
-	return version, nil
-}
-func setVersion(db *sql.DB, version int64) error {
-	_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d;", version))
-	return err
+	return &Sqlite{db: db, encryptor: encryptor, options: o}, nil
 }
 
 func (s *Sqlite) UserExists(userID string) bool {
@@ -101,17 +86,26 @@ func (s *Sqlite) AddUser(userID string) error {
 	return err
 }
 
-func (s *Sqlite) GetUserPlatformAndDM(userID string) (string, bool, error) {
+func (s *Sqlite) GetUserPlatformsAndDM(userID string) ([]string, bool, error) {
 	var dm sql.NullBool
-	var platform sql.NullString
-	err := s.db.QueryRow("SELECT platform, should_dm FROM users WHERE id = ?", userID).Scan(&platform, &dm)
+	err := s.db.QueryRow("SELECT should_dm FROM users WHERE id = ?", userID).Scan(&dm)
+	if err != nil {
+		return nil, false, err
+	}
+	rows, err := s.db.Query("SELECT platform FROM user_platforms WHERE user_id = ? ORDER BY created_unix, platform", userID)
 	if err != nil {
-		return "", false, err
+		return nil, false, err
 	}
-	if !platform.Valid {
-		return "", dm.Valid && dm.Bool, nil
+	defer rows.Close()
+	var platforms []string
+	for rows.Next() {
+		var platform string
+		if err = rows.Scan(&platform); err != nil {
+			return nil, false, err
+		}
+		platforms = append(platforms, platform)
 	}
-	return platform.String, dm.Valid && dm.Bool, nil
+	return platforms, dm.Valid && dm.Bool, nil
 }
 
 func (s *Sqlite) SetUserDM(userID string, dm bool) error {
@@ -120,9 +114,125 @@ func (s *Sqlite) SetUserDM(userID string, dm bool) error {
 	return err
 }
 
-func (s *Sqlite) SetUserPlatform(userID, platform string) error {
+func (s *Sqlite) SetUserPlatforms(userID string, platforms []string) error {
+	t := time.Now().Unix()
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_platforms WHERE user_id = ?", userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, platform := range platforms {
+		_, err = tx.Exec("INSERT OR IGNORE INTO user_platforms (user_id, platform, created_unix) VALUES (?, ?, ?)", userID, platform, t)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE users SET updated_unix = ? WHERE id = ?", t, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUserGames returns the games a user wants codes redeemed for. Empty if they haven't picked any
+func (s *Sqlite) GetUserGames(userID string) ([]string, error) {
+	rows, err := s.db.Query("SELECT game FROM user_games WHERE user_id = ? ORDER BY game", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var games []string
+	for rows.Next() {
+		var game string
+		if err = rows.Scan(&game); err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+func (s *Sqlite) SetUserGames(userID string, games []string) error {
+	t := time.Now().Unix()
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_games WHERE user_id = ?", userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, game := range games {
+		_, err = tx.Exec("INSERT OR IGNORE INTO user_games (user_id, game, created_unix) VALUES (?, ?, ?)", userID, game, t)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("UPDATE users SET updated_unix = ? WHERE id = ?", t, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Sqlite) GetUserCanary(userID string) (bool, error) {
+	var canary bool
+	err := s.db.QueryRow("SELECT canary FROM users WHERE id = ?", userID).Scan(&canary)
+	return canary, err
+}
+
+func (s *Sqlite) SetUserCanary(userID string, canary bool) error {
 	t := time.Now().Unix()
-	_, err := s.db.Exec("UPDATE users SET platform = ?, updated_unix = ? WHERE id = ?", platform, t, userID)
+	_, err := s.db.Exec("UPDATE users SET canary = ?, updated_unix = ? WHERE id = ?", canary, t, userID)
+	return err
+}
+
+// GetCanaryUsers returns the users who opted in to trying new codes and have stored cookies, most recently redeemed
+// first, since their sessions are the likeliest to work
+func (s *Sqlite) GetCanaryUsers() ([]string, error) {
+	rows, err := s.db.Query("SELECT u.id FROM users u JOIN user_cookies c ON c.user_id = u.id WHERE u.canary = 1 " +
+		"ORDER BY u.redemption_unix DESC, u.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+func (s *Sqlite) GetUserBackoff(userID string) (UserBackoff, error) {
+	var backoff UserBackoff
+	err := s.db.QueryRow("SELECT consecutive_failures, next_attempt_unix, failure_warned FROM users WHERE id = ?", userID).
+		Scan(&backoff.ConsecutiveFailures, &backoff.NextAttemptUnix, &backoff.Warned)
+	return backoff, err
+}
+
+func (s *Sqlite) SetUserBackoff(userID string, backoff UserBackoff) error {
+	_, err := s.db.Exec("UPDATE users SET consecutive_failures = ?, next_attempt_unix = ?, failure_warned = ? WHERE id = ?",
+		backoff.ConsecutiveFailures, backoff.NextAttemptUnix, backoff.Warned, userID)
+	return err
+}
+
+// ResetUserBackoff ends a user's run of failures, after a success or a fresh login
+func (s *Sqlite) ResetUserBackoff(userID string) error {
+	_, err := s.db.Exec("UPDATE users SET consecutive_failures = 0, next_attempt_unix = 0, failure_warned = 0 WHERE id = ?", userID)
 	return err
 }
 
@@ -139,8 +249,26 @@ func (s *Sqlite) EncryptAndSetUserCookies(userID string, cookies []*http.Cookie)
 	if err != nil {
 		return err
 	}
+	var expires *int64
+	for _, cookie := range cookies {
+		if cookie.Name == "si" && !cookie.Expires.IsZero() {
+			unix := cookie.Expires.Unix()
+			expires = &unix
+		}
+	}
+	t := time.Now().Unix()
+	// new cookies need checking again, but users are only warned again if the session expires at a different time
+	_, err = s.db.Exec("INSERT INTO user_cookies (user_id, encrypted_cookie_json, updated_unix, session_expires_unix) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (user_id) DO UPDATE SET encrypted_cookie_json = excluded.encrypted_cookie_json, updated_unix = excluded.updated_unix, "+
+		"session_state = NULL, session_checked_unix = NULL, session_expires_unix = excluded.session_expires_unix, "+
+		"session_warned = CASE WHEN session_expires_unix IS excluded.session_expires_unix THEN session_warned ELSE 0 END",
+		userID, encrypted, t, expires)
+	return err
+}
+
+func (s *Sqlite) SetUserCookiesRefreshed(userID string) error {
 	t := time.Now().Unix()
-	_, err = s.db.Exec("INSERT INTO user_cookies (user_id, encrypted_cookie_json, updated_unix) VALUES (?, ?, ?) ON CONFLICT (user_id) DO UPDATE SET encrypted_cookie_json = excluded.encrypted_cookie_json, updated_unix = excluded.updated_unix", userID, encrypted, t)
+	_, err := s.db.Exec("UPDATE user_cookies SET refreshed_unix = ? WHERE user_id = ?", t, userID)
 	return err
 }
 
@@ -162,6 +290,37 @@ func (s *Sqlite) GetDecryptedUserCookies(userID string) ([]*http.Cookie, error)
 	return cookies, nil
 }
 
+func (s *Sqlite) GetUserSession(userID string) (Session, error) {
+	var state sql.NullString
+	var expires, checked, refreshed sql.NullInt64
+	var session Session
+	err := s.db.QueryRow("SELECT session_state, session_expires_unix, session_checked_unix, session_warned, refreshed_unix FROM user_cookies WHERE user_id = ?", userID).Scan(&state, &expires, &checked, &session.Warned, &refreshed)
+	if err != nil {
+		return session, err
+	}
+	session.State = shift.SessionState(state.String)
+	session.ExpiresUnix = expires.Int64
+	session.CheckedUnix = checked.Int64
+	session.RefreshedUnix = refreshed.Int64
+	return session, nil
+}
+
+func (s *Sqlite) SetUserSession(userID string, session shift.Session) error {
+	var expires *int64
+	if !session.Expires.IsZero() {
+		unix := session.Expires.Unix()
+		expires = &unix
+	}
+	t := time.Now().Unix()
+	_, err := s.db.Exec("UPDATE user_cookies SET session_state = ?, session_expires_unix = ?, session_checked_unix = ? WHERE user_id = ?", string(session.State), expires, t, userID)
+	return err
+}
+
+func (s *Sqlite) SetUserSessionWarned(userID string) error {
+	_, err := s.db.Exec("UPDATE user_cookies SET session_warned = 1 WHERE user_id = ?", userID)
+	return err
+}
+
 func (s *Sqlite) DeleteUserCookies(userID string) error {
 	_, err := s.db.Exec("DELETE FROM user_cookies WHERE user_id=?", userID)
 	return err
@@ -180,6 +339,46 @@ func (s *Sqlite) AddCode(code, game string, userID *string, source *string) erro
 	return nil
 }
 
+// AddCodeForValidation adds a code that is only redeemed for canary accounts, until one of them has shown it's valid.
+// It returns false if the code already existed
+func (s *Sqlite) AddCodeForValidation(code, game string, userID *string, source *string) (bool, error) {
+	t := time.Now().Unix()
+	res, err := s.db.Exec("INSERT OR IGNORE INTO shift_codes (code, game, user_id, source, validation, created_unix) VALUES (?, ?, ?, ?, ?, ?)",
+		code, game, userID, source, ValidationPending, t)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// GetPendingCodes returns the codes waiting to be tried on a canary account, oldest first
+func (s *Sqlite) GetPendingCodes() ([]PendingCode, error) {
+	rows, err := s.db.Query("SELECT code, game, user_id, created_unix FROM shift_codes WHERE validation = ? ORDER BY created_unix, code", ValidationPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var codes []PendingCode
+	for rows.Next() {
+		var code PendingCode
+		err = rows.Scan(&code.Code, &code.Game, &code.UserID, &code.CreatedUnix)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+func (s *Sqlite) SetCodeValidation(code string, validation CodeValidation) error {
+	_, err := s.db.Exec("UPDATE shift_codes SET validation = ? WHERE code = ?", validation, code)
+	return err
+}
+
 func (s *Sqlite) SetCodeRewardAndSuccess(code, reward string, success bool) (bool, error) {
 	t := time.Now().Unix()
 	tx, err := s.db.BeginTx(context.Background(), nil)
@@ -209,24 +408,33 @@ func (s *Sqlite) SetCodeRewardAndSuccess(code, reward string, success bool) (boo
 	return n == 1, tx.Commit()
 }
 
-func (s *Sqlite) GetValidCodesNotRedeemedForUser(userID, platform string, limit int) ([]string, error) {
-	// grab codes that the user hasn't redeemed for the platform before,
-	// AND, if the code hasn't been marked as expired/invalid before
+// validCodesNotRedeemed is the condition on shift_codes sc for codes that should be redeemed for a user on a platform.
+// Codes still waiting on a canary account are left out. Its arguments come from validCodesNotRedeemedArgs
+const validCodesNotRedeemed = "sc.validation = 'valid' AND NOT EXISTS (SELECT 1 FROM redemptions r WHERE r.code = sc.code AND r.user_id = ? AND r.platform = ?) AND " +
+	"NOT EXISTS (SELECT 1 FROM code_validity v WHERE v.code = sc.code AND v.platform = ? AND v.failures >= ?) AND " +
+	"(sc.game IN (SELECT g.game FROM user_games g WHERE g.user_id = ?) OR " +
+	"(sc.game = ? AND NOT EXISTS (SELECT 1 FROM user_games g WHERE g.user_id = ?)))"
 
-	// TODO maybe have a minimum threshold on how many expiries have to be marked before we ignore?
-	query := "SELECT sc.code FROM shift_codes sc WHERE " +
-		"NOT EXISTS (SELECT 1 FROM redemptions r WHERE r.code = sc.code AND r.user_id = ? AND r.platform = ?) AND " +
-		"NOT EXISTS (SELECT 1 FROM redemptions r WHERE r.code = sc.code AND (r.status = ? OR r.status = ?)) " +
-		"ORDER BY success_unix DESC LIMIT ?" // sort preferentially for the most recently-successful codes
-	rows, err := s.db.Query(query, userID, platform, shift.EXPIRED, shift.NOT_EXIST, limit)
+func (s *Sqlite) validCodesNotRedeemedArgs(userID, platform string) []any {
+	return []any{userID, platform, platform, s.invalidCodeThreshold, userID, shift.DefaultGame, userID}
+}
+
+func (s *Sqlite) GetValidCodesNotRedeemedForUser(userID, platform string, limit int) ([]ShiftCode, error) {
+	// grab codes that the user hasn't redeemed for the platform before,
+	// AND, if the code hasn't been marked as expired/invalid on the platform enough times to be considered invalid
+	// AND, if the code is for a game the user wants codes for (or the default game, if they haven't picked any)
+	query := "SELECT sc.code, sc.game FROM shift_codes sc WHERE " + validCodesNotRedeemed +
+		" ORDER BY success_unix DESC LIMIT ?" // sort preferentially for the most recently-successful codes
+	args := append(s.validCodesNotRedeemedArgs(userID, platform), limit)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var codes []string
+	var codes []ShiftCode
 	for rows.Next() {
-		var code string
-		err = rows.Scan(&code)
+		var code ShiftCode
+		err = rows.Scan(&code.Code, &code.Game)
 		if err != nil {
 			return nil, err
 		}
@@ -235,6 +443,235 @@ func (s *Sqlite) GetValidCodesNotRedeemedForUser(userID, platform string, limit
 	return codes, nil
 }
 
+// CodeInvalidOnPlatform returns whether a code has had enough expired/not exist results on the platform (since its last
+// success) that it's no longer redeemed there
+func (s *Sqlite) CodeInvalidOnPlatform(code, platform string) (bool, error) {
+	var invalid bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM code_validity WHERE code = ? AND platform = ? AND failures >= ?)",
+		code, platform, s.invalidCodeThreshold).Scan(&invalid)
+	return invalid, err
+}
+
+// CodeFailed returns whether a code has had any expired/not exist results on any platform (since its last success
+// there), even if not enough to stop redeeming it
+func (s *Sqlite) CodeFailed(code string) (bool, error) {
+	var failed bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM code_validity WHERE code = ? AND failures > 0)", code).Scan(&failed)
+	return failed, err
+}
+
+// RestoreCode clears the expired/not exist results recorded for a code, and marks it valid if a canary account found
+// it invalid, so it is redeemed again. If platform is empty,
+// the code is restored on every platform. Returns whether the code was considered invalid anywhere beforehand
+func (s *Sqlite) RestoreCode(code, platform string) (bool, error) {
+	t := time.Now().Unix()
+	query := "UPDATE code_validity SET failures = 0, updated_unix = ? WHERE code = ? AND failures >= ?"
+	args := []any{t, code, s.invalidCodeThreshold}
+	if platform != "" {
+		query += " AND platform = ?"
+		args = append(args, platform)
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	// a canary account finding the code invalid counts on every platform
+	res, err = s.db.Exec("UPDATE shift_codes SET validation = ? WHERE code = ? AND validation = ?", ValidationValid, code, ValidationInvalid)
+	if err != nil {
+		return false, err
+	}
+	validated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0 || validated > 0, nil
+}
+
+// EnqueueRedemptionJobs adds a job for every code that should be redeemed for the user on the platform and doesn't have
+// one yet, and returns how many were added. Pending jobs that no longer need redeeming, like ones for a platform the
+// user dropped, are removed
+func (s *Sqlite) EnqueueRedemptionJobs(userID, platform string) (int, error) {
+	t := time.Now().Unix()
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM redemption_jobs WHERE user_id = ? AND state = ? AND ("+
+		"(platform = ? AND code NOT IN (SELECT sc.code FROM shift_codes sc WHERE "+validCodesNotRedeemed+")) OR "+
+		"platform NOT IN (SELECT platform FROM user_platforms WHERE user_id = ?))",
+		append([]any{userID, JobPending, platform}, append(s.validCodesNotRedeemedArgs(userID, platform), userID)...)...)
+	if err != nil {
+		return 0, err
+	}
+	args := append([]any{userID, platform, t, t, t}, s.validCodesNotRedeemedArgs(userID, platform)...)
+	res, err := tx.Exec("INSERT OR IGNORE INTO redemption_jobs (user_id, code, platform, next_run_unix, created_unix, updated_unix) "+
+		"SELECT ?, sc.code, ?, ?, ?, ? FROM shift_codes sc WHERE "+validCodesNotRedeemed, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// ClaimRedemptionJobs marks up to limit of the user's pending jobs that are due as running, claimed by the worker until
+// the lease expires, and returns them. Jobs are only claimed for platforms the user still has, and the most
+// recently-successful codes come first
+func (s *Sqlite) ClaimRedemptionJobs(workerID, userID string, limit int, lease time.Duration) ([]RedemptionJob, error) {
+	now := time.Now()
+	t := now.Unix()
+	expires := now.Add(lease).Unix()
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(redemptionJobsQuery+"WHERE j.user_id = ? AND j.state = ? AND j.next_run_unix <= ? AND "+
+		"j.platform IN (SELECT platform FROM user_platforms WHERE user_id = ?) "+
+		"ORDER BY sc.success_unix DESC, j.id LIMIT ?", userID, JobPending, t, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanRedemptionJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		_, err = tx.Exec("UPDATE redemption_jobs SET state = ?, claimed_by = ?, lease_expires_unix = ?, updated_unix = ? WHERE id = ?",
+			JobRunning, workerID, expires, t, jobs[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i].State = JobRunning
+		jobs[i].ClaimedBy = workerID
+		jobs[i].LeaseExpiresUnix = expires
+		jobs[i].UpdatedUnix = t
+	}
+	return jobs, tx.Commit()
+}
+
+// FinishRedemptionJob removes a job that reached a final result, which is recorded as a redemption instead
+func (s *Sqlite) FinishRedemptionJob(id int64) error {
+	_, err := s.db.Exec("DELETE FROM redemption_jobs WHERE id = ?", id)
+	return err
+}
+
+// RetryRedemptionJob records a failed attempt at a job, and leaves it pending until nextRun
+func (s *Sqlite) RetryRedemptionJob(id int64, nextRun time.Time, lastError string) error {
+	_, err := s.db.Exec("UPDATE redemption_jobs SET state = ?, attempts = attempts + 1, next_run_unix = ?, last_error = ?, "+
+		"claimed_by = NULL, lease_expires_unix = 0, updated_unix = ? WHERE id = ?", JobPending, nextRun.Unix(), lastError, time.Now().Unix(), id)
+	return err
+}
+
+// ParkRedemptionJob records a failed attempt at a job, and stops it being claimed until it's requeued
+func (s *Sqlite) ParkRedemptionJob(id int64, lastError string) error {
+	_, err := s.db.Exec("UPDATE redemption_jobs SET state = ?, attempts = attempts + 1, last_error = ?, claimed_by = NULL, "+
+		"lease_expires_unix = 0, updated_unix = ? WHERE id = ?", JobParked, lastError, time.Now().Unix(), id)
+	return err
+}
+
+// ReleaseRedemptionJob returns a claimed job that wasn't attempted to pending, without counting an attempt
+func (s *Sqlite) ReleaseRedemptionJob(id int64) error {
+	_, err := s.db.Exec("UPDATE redemption_jobs SET state = ?, claimed_by = NULL, lease_expires_unix = 0, updated_unix = ? "+
+		"WHERE id = ? AND state = ?", JobPending, time.Now().Unix(), id, JobRunning)
+	return err
+}
+
+// ResetRedemptionJobs returns running jobs whose lease has expired to pending, for jobs whose worker stopped without
+// finishing them. Jobs a worker is still redeeming are left alone. Returns how many there were
+func (s *Sqlite) ResetRedemptionJobs() (int, error) {
+	t := time.Now().Unix()
+	res, err := s.db.Exec("UPDATE redemption_jobs SET state = ?, claimed_by = NULL, lease_expires_unix = 0, updated_unix = ? "+
+		"WHERE state = ? AND lease_expires_unix <= ?", JobPending, t, JobRunning, t)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// GetUsersWithDueRedemptionJobs returns the users with pending jobs that are due to be claimed, leaving out users whose
+// redemptions are backing off
+func (s *Sqlite) GetUsersWithDueRedemptionJobs() ([]string, error) {
+	t := time.Now().Unix()
+	rows, err := s.db.Query("SELECT DISTINCT j.user_id FROM redemption_jobs j JOIN users u ON u.id = j.user_id "+
+		"WHERE j.state = ? AND j.next_run_unix <= ? AND u.next_attempt_unix <= ? ORDER BY j.user_id", JobPending, t, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		err = rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+
+// GetRedemptionJobs returns up to limit jobs in a state, most recently updated first. A limit of zero or less returns
+// them all
+func (s *Sqlite) GetRedemptionJobs(state RedemptionJobState, limit int) ([]RedemptionJob, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.Query(redemptionJobsQuery+"WHERE j.state = ? ORDER BY j.updated_unix DESC, j.id DESC LIMIT ?", state, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRedemptionJobs(rows)
+}
+
+// RequeueRedemptionJob returns a parked job to pending with no attempts, due straight away. Returns whether the job
+// was parked
+func (s *Sqlite) RequeueRedemptionJob(id int64) (bool, error) {
+	t := time.Now().Unix()
+	res, err := s.db.Exec("UPDATE redemption_jobs SET state = ?, attempts = 0, next_run_unix = ?, updated_unix = ? WHERE id = ? AND state = ?",
+		JobPending, t, t, id, JobParked)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+const redemptionJobsQuery = "SELECT j.id, j.user_id, j.code, sc.game, j.platform, j.state, j.attempts, j.next_run_unix, " +
+	"j.last_error, j.created_unix, j.updated_unix, j.claimed_by, j.lease_expires_unix FROM redemption_jobs j " +
+	"JOIN shift_codes sc ON sc.code = j.code "
+
+func scanRedemptionJobs(rows *sql.Rows) ([]RedemptionJob, error) {
+	defer rows.Close()
+	var jobs []RedemptionJob
+	for rows.Next() {
+		var job RedemptionJob
+		var lastError, claimedBy sql.NullString
+		err := rows.Scan(&job.ID, &job.UserID, &job.Code, &job.Game, &job.Platform, &job.State, &job.Attempts,
+			&job.NextRunUnix, &lastError, &job.CreatedUnix, &job.UpdatedUnix, &claimedBy, &job.LeaseExpiresUnix)
+		if err != nil {
+			return nil, err
+		}
+		job.LastError = lastError.String
+		job.ClaimedBy = claimedBy.String
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
 func (s *Sqlite) GetAllDecryptedUserCookiesSorted(limit int64) ([]UserCookies, error) {
 	rows, err := s.db.Query("SELECT c.user_id, c.encrypted_cookie_json FROM user_cookies c JOIN users u ON c.user_id = u.id ORDER BY u.redemption_unix LIMIT ?", limit)
 	if err != nil {
@@ -266,6 +703,17 @@ func (s *Sqlite) GetAllDecryptedUserCookiesSorted(limit int64) ([]UserCookies, e
 	return userCookies, nil
 }
 
+// RedemptionExists returns whether a result was recorded for redeeming the code for the user on the platform
+func (s *Sqlite) RedemptionExists(userID, code, platform string) bool {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM redemptions WHERE user_id = ? AND code = ? AND platform = ?)", userID, code, platform).Scan(&exists)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	return exists
+}
+
 func (s *Sqlite) GetRecentRedemptionsForUser(userID string, status string, quantity int) ([]Redemption, error) {
 	if quantity <= 0 {
 		return nil, nil
@@ -314,8 +762,8 @@ func (s *Sqlite) RedemptionSummaryForUser(userID string) (map[string]int64, erro
 		userID, shift.ALREADY_REDEEMED,
 	).Scan(
 		&total,
-		&already,
 		&success,
+		&already,
 	)
 	if err != nil {
 		return nil, err
@@ -327,13 +775,17 @@ func (s *Sqlite) RedemptionSummaryForUser(userID string) (map[string]int64, erro
 	}, nil
 }
 
-func (s *Sqlite) AddRedemption(userID, code, platform string, status string) error {
+func (s *Sqlite) AddRedemption(userID, code string, result shift.RedeemResult) error {
+	status := result.Type.Status()
+	if status == "" {
+		return ErrUnrecognizedRedemption
+	}
 	t := time.Now().Unix()
 	tx, err := s.db.BeginTx(context.Background(), nil)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec("INSERT INTO redemptions (code, user_id, platform, status, created_unix) VALUES (?, ?, ?, ?, ?)", code, userID, platform, status, t)
+	_, err = tx.Exec("INSERT INTO redemptions (code, user_id, platform, status, created_unix) VALUES (?, ?, ?, ?, ?)", code, userID, result.Platform, status, t)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -343,9 +795,141 @@ func (s *Sqlite) AddRedemption(userID, code, platform string, status string) err
 		tx.Rollback()
 		return err
 	}
+	// expired/not exist results count towards the code being invalid on the platform, and a success or already redeemed
+	// result contradicts them
+	var delta int
+	switch result.Type {
+	case shift.Expired, shift.Invalid:
+		delta = 1
+	case shift.Success, shift.AlreadyRedeemed:
+		delta = -1
+	}
+	_, err = tx.Exec("INSERT INTO code_validity (code, platform, failures, updated_unix) VALUES (?, ?, MAX(?, 0), ?) "+
+		"ON CONFLICT (code, platform) DO UPDATE SET failures = CASE WHEN ? < 0 THEN 0 ELSE code_validity.failures + ? END, updated_unix = excluded.updated_unix",
+		code, result.Platform, delta, t, delta, delta)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	return tx.Commit()
 }
 
+// unlockedUnix is how reward dates are stored: NULL when SHiFT's date couldn't be parsed
+func unlockedUnix(date time.Time) *int64 {
+	if date.IsZero() {
+		return nil
+	}
+	unix := date.Unix()
+	return &unix
+}
+
+func (s *Sqlite) AddUserReward(userID, code string, entry shift.RewardEntry) error {
+	t := time.Now().Unix()
+	_, err := s.db.Exec("INSERT INTO user_rewards (user_id, platform, game, title, description, unlocked_unix, code, golden_keys, created_unix) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, string(entry.Platform), string(entry.Game), entry.Title, entry.Description, unlockedUnix(entry.Date), code, shift.GoldenKeys(entry.Title), t)
+	return err
+}
+
+// SyncUserRewards stores the rewards in the snapshot that aren't stored for the user yet, and returns how many there
+// were. Rewards are matched by everything SHiFT shows about them, so a reward unlocked twice is stored twice
+func (s *Sqlite) SyncUserRewards(userID string, snapshot *shift.RewardsSnapshot) (int, error) {
+	type key struct {
+		platform, game, title, description string
+		unlocked                           int64
+	}
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT platform, game, title, description, unlocked_unix FROM user_rewards WHERE user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	stored := map[key]int{}
+	for rows.Next() {
+		var k key
+		var unlocked sql.NullInt64
+		if err = rows.Scan(&k.platform, &k.game, &k.title, &k.description, &unlocked); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		k.unlocked = unlocked.Int64
+		stored[k]++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	t := time.Now().Unix()
+	added := 0
+	for _, entry := range snapshot.Entries {
+		k := key{string(entry.Platform), string(entry.Game), entry.Title, entry.Description, 0}
+		if unlocked := unlockedUnix(entry.Date); unlocked != nil {
+			k.unlocked = *unlocked
+		}
+		if stored[k] > 0 {
+			stored[k]--
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO user_rewards (user_id, platform, game, title, description, unlocked_unix, golden_keys, created_unix) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			userID, k.platform, k.game, k.title, k.description, unlockedUnix(entry.Date), shift.GoldenKeys(entry.Title), t)
+		if err != nil {
+			return 0, err
+		}
+		added++
+	}
+	return added, tx.Commit()
+}
+
+// GetUserRewards returns the user's rewards, most recently unlocked first. A limit of zero or less returns them all
+func (s *Sqlite) GetUserRewards(userID string, limit int) ([]UserReward, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.db.Query("SELECT platform, game, title, description, unlocked_unix, code, created_unix FROM user_rewards WHERE user_id = ? "+
+		"ORDER BY COALESCE(unlocked_unix, created_unix) DESC, id DESC LIMIT ?", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rewards []UserReward
+	for rows.Next() {
+		var reward UserReward
+		var unlocked sql.NullInt64
+		err = rows.Scan(&reward.Platform, &reward.Game, &reward.Title, &reward.Description, &unlocked, &reward.Code, &reward.CreatedUnix)
+		if err != nil {
+			return nil, err
+		}
+		reward.UnlockedUnix = unlocked.Int64
+		rewards = append(rewards, reward)
+	}
+	return rewards, rows.Err()
+}
+
+// GetUserGoldenKeys counts the user's golden keys by platform and the month they were unlocked, newest month first.
+// Rewards without an unlock date count toward the month SlickShift first saw them
+func (s *Sqlite) GetUserGoldenKeys(userID string) ([]GoldenKeys, error) {
+	rows, err := s.db.Query("SELECT platform, strftime('%Y-%m', COALESCE(unlocked_unix, created_unix), 'unixepoch') AS month, "+
+		"SUM(CASE WHEN code IS NOT NULL THEN golden_keys ELSE 0 END), SUM(golden_keys) "+
+		"FROM user_rewards WHERE user_id = ? AND golden_keys > 0 GROUP BY platform, month ORDER BY month DESC, platform", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []GoldenKeys
+	for rows.Next() {
+		var k GoldenKeys
+		if err = rows.Scan(&k.Platform, &k.Month, &k.Earned, &k.Total); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
 func (s *Sqlite) AddShiftError(userID, code, platform, error string) error {
 	t := time.Now().Unix()
 	_, err := s.db.Exec("INSERT INTO shift_errors (user_id, code, platform, error, created_unix) VALUES (?, ?, ?, ?, ?)",
@@ -383,10 +967,10 @@ func (s *Sqlite) GetStatistics(userID string) (Statistics, error) {
 	err := s.db.QueryRow(`
     SELECT 
         (SELECT COUNT(*) FROM users),
-        (SELECT COUNT(*) FROM users WHERE platform = ?),
-        (SELECT COUNT(*) FROM users WHERE platform = ?),
-        (SELECT COUNT(*) FROM users WHERE platform = ?),
-        (SELECT COUNT(*) FROM users WHERE platform = ?),
+        (SELECT COUNT(*) FROM user_platforms WHERE platform = ?),
+        (SELECT COUNT(*) FROM user_platforms WHERE platform = ?),
+        (SELECT COUNT(*) FROM user_platforms WHERE platform = ?),
+        (SELECT COUNT(*) FROM user_platforms WHERE platform = ?),
         (SELECT COUNT(*) FROM shift_codes),
         (SELECT COUNT(*) FROM shift_codes WHERE reward = ?),
         (SELECT COUNT(*) FROM shift_codes WHERE reward IS NOT NULL AND reward != ?),
@@ -0,0 +1,4 @@
+DROP TABLE redemptions;
+DROP TABLE shift_codes;
+DROP TABLE user_cookies;
+DROP TABLE users;
@@ -0,0 +1 @@
+DROP TABLE redemption_jobs;
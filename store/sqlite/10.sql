@@ -0,0 +1,18 @@
+CREATE TABLE redemption_jobs (
+    id INTEGER PRIMARY KEY,
+    user_id UNSIGNED BIG INT NOT NULL,
+    code CHAR(29) NOT NULL,
+    platform TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT 'pending', -- pending, running (claimed by a worker) or parked (failed too many times)
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_run_unix UNSIGNED BIG INT NOT NULL, -- the job isn't claimed before this time, so failures back off
+    last_error TEXT,
+    created_unix UNSIGNED BIG INT NOT NULL,
+    updated_unix UNSIGNED BIG INT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE CASCADE,
+    UNIQUE (user_id, code, platform)
+);
+
+CREATE INDEX redemption_jobs_state ON redemption_jobs (state, next_run_unix);
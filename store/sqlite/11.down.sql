@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN failure_warned;
+ALTER TABLE users DROP COLUMN next_attempt_unix;
+ALTER TABLE users DROP COLUMN consecutive_failures;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0; -- redemptions that failed in a row, for reasons that are likely the user's
+ALTER TABLE users ADD COLUMN next_attempt_unix UNSIGNED BIG INT NOT NULL DEFAULT 0; -- the user's codes aren't redeemed before this time
+ALTER TABLE users ADD COLUMN failure_warned BOOLEAN NOT NULL DEFAULT 0; -- whether the user was told about the current run of failures
+
+-- users who were already skipped for their errors have been told about them
+UPDATE users SET consecutive_failures = (SELECT COUNT(*) FROM shift_errors e WHERE e.user_id = users.id), failure_warned = 1
+WHERE (SELECT COUNT(*) FROM shift_errors e WHERE e.user_id = users.id) > 4;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN canary;
+ALTER TABLE shift_codes DROP COLUMN validation;
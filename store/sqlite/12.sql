@@ -0,0 +1,2 @@
+ALTER TABLE shift_codes ADD COLUMN validation TEXT NOT NULL DEFAULT 'valid'; -- pending until a canary account has tried the code, then valid or invalid
+ALTER TABLE users ADD COLUMN canary BOOLEAN NOT NULL DEFAULT 0; -- whether the user opted in to trying new codes before everyone else
@@ -0,0 +1,2 @@
+ALTER TABLE redemption_jobs DROP COLUMN lease_expires_unix;
+ALTER TABLE redemption_jobs DROP COLUMN claimed_by;
@@ -0,0 +1,2 @@
+ALTER TABLE redemption_jobs ADD COLUMN claimed_by TEXT; -- the worker a running job was claimed by
+ALTER TABLE redemption_jobs ADD COLUMN lease_expires_unix UNSIGNED BIG INT NOT NULL DEFAULT 0; -- a running job is only reset once its lease expires, so it's never taken from a worker still redeeming it
@@ -0,0 +1 @@
+DROP TABLE shift_errors;
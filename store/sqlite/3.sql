@@ -0,0 +1,8 @@
+CREATE TABLE user_games (
+    user_id UNSIGNED BIG INT NOT NULL,
+    game TEXT NOT NULL,
+    created_unix UNSIGNED BIG INT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, game)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN platform TEXT;
+
+-- users only had one platform before, so any others are lost
+UPDATE users SET platform = (SELECT MIN(p.platform) FROM user_platforms p WHERE p.user_id = users.id);
+
+DROP TABLE user_platforms;
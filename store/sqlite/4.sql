@@ -0,0 +1,13 @@
+CREATE TABLE user_platforms (
+    user_id UNSIGNED BIG INT NOT NULL,
+    platform TEXT NOT NULL,
+    created_unix UNSIGNED BIG INT NOT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, platform)
+);
+
+INSERT INTO user_platforms (user_id, platform, created_unix)
+    SELECT id, platform, updated_unix FROM users WHERE platform IS NOT NULL AND platform != '';
+
+ALTER TABLE users DROP COLUMN platform;
@@ -0,0 +1 @@
+DROP TABLE code_validity;
@@ -0,0 +1,18 @@
+CREATE TABLE code_validity (
+    code CHAR(29) NOT NULL,
+    platform TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0, -- expired/not exist results since the last successful redemption
+    updated_unix UNSIGNED BIG INT NOT NULL,
+
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE CASCADE,
+    PRIMARY KEY (code, platform)
+);
+
+INSERT INTO code_validity (code, platform, failures, updated_unix)
+    SELECT r.code, r.platform,
+        SUM(CASE WHEN r.status IN ('This SHiFT code has expired', 'This SHiFT code does not exist') AND r.created_unix >= (
+            SELECT COALESCE(MAX(s.created_unix), 0) FROM redemptions s
+            WHERE s.code = r.code AND s.platform = r.platform AND s.status = 'Your code was successfully redeemed'
+        ) THEN 1 ELSE 0 END),
+        MAX(r.created_unix)
+    FROM redemptions r GROUP BY r.code, r.platform;
@@ -0,0 +1,4 @@
+ALTER TABLE user_cookies DROP COLUMN session_warned;
+ALTER TABLE user_cookies DROP COLUMN session_checked_unix;
+ALTER TABLE user_cookies DROP COLUMN session_expires_unix;
+ALTER TABLE user_cookies DROP COLUMN session_state;
@@ -0,0 +1,4 @@
+ALTER TABLE user_cookies ADD COLUMN session_state TEXT; -- valid, expiring_soon or invalid; NULL until the session is checked
+ALTER TABLE user_cookies ADD COLUMN session_expires_unix UNSIGNED BIG INT; -- when the si cookie expires, if known
+ALTER TABLE user_cookies ADD COLUMN session_checked_unix UNSIGNED BIG INT;
+ALTER TABLE user_cookies ADD COLUMN session_warned BOOLEAN NOT NULL DEFAULT 0; -- whether the user was warned their session is expiring
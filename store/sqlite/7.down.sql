@@ -0,0 +1 @@
+ALTER TABLE user_cookies DROP COLUMN refreshed_unix;
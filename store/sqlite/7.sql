@@ -0,0 +1 @@
+ALTER TABLE user_cookies ADD COLUMN refreshed_unix UNSIGNED BIG INT; -- last time SHiFT rotated the cookies and they were stored again
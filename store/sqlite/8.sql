@@ -0,0 +1,16 @@
+CREATE TABLE user_rewards (
+    id INTEGER PRIMARY KEY,
+    user_id UNSIGNED BIG INT NOT NULL,
+    platform TEXT NOT NULL,
+    game TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    unlocked_unix UNSIGNED BIG INT, -- the day SHiFT says the reward was unlocked (midnight UTC), NULL if it couldn't be parsed
+    code CHAR(29), -- the code SlickShift redeemed to unlock the reward, if it did
+    created_unix UNSIGNED BIG INT NOT NULL, -- when SlickShift first saw the reward
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (code) REFERENCES shift_codes (code) ON DELETE SET NULL
+);
+
+CREATE INDEX user_rewards_user_id ON user_rewards (user_id, unlocked_unix);
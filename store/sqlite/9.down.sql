@@ -0,0 +1 @@
+ALTER TABLE user_rewards DROP COLUMN golden_keys;
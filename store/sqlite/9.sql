@@ -0,0 +1,4 @@
+ALTER TABLE user_rewards ADD COLUMN golden_keys INTEGER NOT NULL DEFAULT 0; -- how many golden keys the reward is worth
+
+-- rewards worth more than one key lead with the count, like "5 Golden Keys"
+UPDATE user_rewards SET golden_keys = MAX(CAST(title AS INTEGER), 1) WHERE title LIKE '%Golden Key%';
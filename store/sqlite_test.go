@@ -1,301 +1,97 @@
-package store
+package store_test
 
 import (
-	"crypto/rand"
-	"log"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
-	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
+	"github.com/denverquane/slickshift/store/storetest"
 )
 
-func newTestDB(t *testing.T) Store {
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+func newSqliteTestDB(t *testing.T, opts ...store.Option) store.Store {
+	st, err := store.NewSqliteStore(":memory:", storetest.NewEncryptor(t), opts...)
 	if err != nil {
 		t.Fatal(err)
 	}
-	encryptor, err := NewEncryptor(key)
-	if err != nil {
-		log.Fatal(err)
-	}
-	store, err := NewSqliteStore(":memory:", encryptor)
-	if err != nil {
-		log.Fatal(err)
-	}
 
 	t.Cleanup(func() {
-		store.Close()
+		st.Close()
 	})
 
-	return store
-}
-
-func TestSqliteStore_AddUser(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-
-	if st.UserExists(userID) {
-		t.Fatal("User exists when db is fresh")
-	}
-
-	err := st.AddUser(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !st.UserExists(userID) {
-		t.Fatal("User does not exist after added to DB")
-	}
+	return st
 }
 
-func TestSqliteStore_SetUserPlatform(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const platform = string(shift.Steam)
-
-	st.AddUser(userID)
-	p, _, err := st.GetUserPlatformAndDM(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if p != "" {
-		t.Fatal("User platform should be empty")
-	}
-
-	err = st.SetUserPlatform(userID, platform)
-	if err != nil {
-		t.Fatal(err)
-	}
-	p, _, err = st.GetUserPlatformAndDM(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if p != platform {
-		t.Fatal("User platform should be " + platform)
-	}
+func TestSqliteStore(t *testing.T) {
+	storetest.Run(t, newSqliteTestDB)
 }
 
-func TestSqliteStore_GetUserPlatformAndDM(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const platform = string(shift.Steam)
-
-	st.AddUser(userID)
-
-	p, dm, err := st.GetUserPlatformAndDM(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if p != "" {
-		t.Fatal("User platform should be empty")
-	}
-	if dm {
-		t.Fatal("DM should be false")
-	}
-	st.SetUserPlatform(userID, platform)
-
-	p, dm, err = st.GetUserPlatformAndDM(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if p != platform {
-		t.Fatal("User platform should be " + platform)
-	}
-	if dm {
-		t.Fatal("DM should be false")
-	}
-
-	st.SetUserDM(userID, true)
-	p, dm, err = st.GetUserPlatformAndDM(userID)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if p != platform {
-		t.Fatal("User platform should be " + platform)
-	}
-	if !dm {
-		t.Fatal("DM should be true")
-	}
-
-}
-
-func TestSqliteStore_AddCode(t *testing.T) {
-	st := newTestDB(t)
-	const code = "AAAAA"
-	const game = string(shift.Borderlands4)
-
-	if st.CodeExists(code) {
-		t.Fatal("Code exists when db is fresh")
-	}
-
-	err := st.AddCode(code, game, nil, nil)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if !st.CodeExists(code) {
-		t.Fatal("Code does not exist after added to DB")
-	}
+func TestSqliteStore_Shared(t *testing.T) {
+	storetest.RunShared(t, func(t *testing.T) (store.Store, store.Store) {
+		path := filepath.Join(t.TempDir(), "slickshift.db")
+		encryptor := storetest.NewEncryptor(t)
+		var stores []store.Store
+		for range 2 {
+			st, err := store.NewSqliteStore(path, encryptor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				st.Close()
+			})
+			stores = append(stores, st)
+		}
+		return stores[0], stores[1]
+	})
 }
 
-func TestNewSqliteStore_AddRedemptionAndGetSuccessStatus(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const code = "AAAAA"
-	const code2 = "BBBBB"
-	const game = string(shift.Borderlands4)
-	const platform = string(shift.Steam)
-	const status = shift.SUCCESS
-	const otherStatus = shift.ALREADY_REDEEMED
-
-	st.AddUser(userID)
-	st.AddCode(code, game, nil, nil)
-	st.AddCode(code2, game, nil, nil)
-
-	err := st.AddRedemption(userID, code, platform, status)
+// latestMigration returns the highest migration version in dir
+func latestMigration(t *testing.T, dir string) int64 {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = st.AddRedemption(userID, code2, platform, otherStatus)
-	if err != nil {
-		t.Fatal(err)
+	var latest int64
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".down.sql") {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".sql"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest = max(latest, version)
 	}
-
-	redemptions, err := st.GetRecentRedemptionsForUser(userID, status, 10)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if len(redemptions) != 1 {
-		t.Fatal("Redemptions should contain 1 item")
-	}
-	r := redemptions[0]
-	if r.Status != status {
-		t.Fatal("Redemption status should be " + status)
-	}
-	if r.Code != code {
-		t.Fatal("Redemption code should be " + code)
-	}
-	if r.Game != game {
-		t.Fatal("Redemption game should be " + game)
-	}
-	if r.Platform != platform {
-		t.Fatal("Redemption platform should be " + platform)
-	}
-	redemptions, err = st.GetRecentRedemptionsForUser(userID, "", 10)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if len(redemptions) != 2 {
-		t.Fatal("Redemptions should contain 2 items when unfiltered")
-	}
-
+	return latest
 }
 
-// when fetching codes for a user to redeem, if other users have marked the codes as expired or invalid, those
-// codes should not be retrieved
-func TestSqliteStore_GetValidCodes(t *testing.T) {
-	// arrange
-	st := newTestDB(t)
-	const userID = "123"
-	const testUserID = "234"
-
-	const goodCode = "ABCDEF"
-	const expiredCode = "BBBBB"
-	const notExistCode = "CCCCC"
-
-	const platform = string(shift.Steam)
-	const game = string(shift.Borderlands4)
-
-	st.AddUser(userID)
-	st.AddUser(testUserID)
-
-	st.SetUserPlatform(userID, platform)
-	st.SetUserPlatform(testUserID, platform)
-
-	st.AddCode(goodCode, game, nil, nil)
-	st.AddCode(expiredCode, game, nil, nil)
-	st.AddCode(notExistCode, game, nil, nil)
-
-	st.AddRedemption(userID, goodCode, platform, shift.SUCCESS)
-	st.AddRedemption(userID, expiredCode, platform, shift.EXPIRED)
-	st.AddRedemption(userID, notExistCode, platform, shift.NOT_EXIST)
-
-	// act
-	codes, err := st.GetValidCodesNotRedeemedForUser(testUserID, platform, 10)
+func TestSqliteStore_MigrationsInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slickshift.db")
+	st, err := store.NewSqliteStore(path, storetest.NewEncryptor(t))
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer st.Close()
 
-	// assert
-	if len(codes) != 1 {
-		t.Fatal("Expected 1 code, got ", len(codes))
-	}
-	if codes[0] != goodCode {
-		t.Fatal("Expected good code, got ", codes[0])
-	}
-}
-
-func TestSqliteStore_AddError(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const code = "XXXXX"
-	const game = string(shift.Borderlands4)
-	const platform = string(shift.Steam)
-	const errText = "234"
-
-	st.AddUser(userID)
-	st.AddCode(code, game, nil, nil)
-
-	err := st.AddShiftError(userID, code, platform, errText)
+	// 10.sql sorts before 2.sql, but has to be applied after it
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		t.Fatal(err)
 	}
-}
-
-func TestSqlite_GetShiftError(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const code = "XXXXX"
-	const game = string(shift.Borderlands4)
-	const platform = string(shift.Steam)
-	const errText = "234"
-
-	st.AddUser(userID)
-	st.AddCode(code, game, nil, nil)
-	st.AddShiftError(userID, code, platform, errText)
-
-	errs, err := st.GetShiftErrors(userID)
+	defer db.Close()
+	var version int64
+	err = db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(errs) != 1 {
-		t.Fatal("Expected 1 code, got ", len(errs))
-	}
-	if errs[0] != errText {
-		t.Fatal("Expected err text, got ", errs[0])
-	}
-}
-
-func TestSqliteStore_ClearShiftErrors(t *testing.T) {
-	st := newTestDB(t)
-	const userID = "123"
-	const code = "XXXXX"
-	const game = string(shift.Borderlands4)
-	const platform = string(shift.Steam)
-	const errText = "234"
-
-	st.AddUser(userID)
-	st.AddCode(code, game, nil, nil)
-	st.AddShiftError(userID, code, platform, errText)
-
-	err := st.ClearShiftErrors(userID)
-	if err != nil {
-		t.Fatal(err)
+	if latest := latestMigration(t, "sqlite"); version != latest {
+		t.Fatalf("Expected version %d, got %d", latest, version)
 	}
-	errs, err := st.GetShiftErrors(userID)
+	_, err = st.GetRedemptionJobs(store.JobPending, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(errs) != 0 {
-		t.Fatal("Expected 0 code, got ", len(errs))
-	}
 }
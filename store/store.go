@@ -2,7 +2,11 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
 )
 
 type UserCookies struct {
@@ -10,6 +14,102 @@ type UserCookies struct {
 	Cookies []*http.Cookie
 }
 
+// Session is what the last health check of a user's stored cookies found
+type Session struct {
+	// State is empty if the session hasn't been checked since the cookies were stored
+	State       shift.SessionState `json:"state"`
+	ExpiresUnix int64              `json:"expires_unix"`
+	CheckedUnix int64              `json:"checked_unix"`
+	Warned      bool               `json:"warned"`
+	// RefreshedUnix is the last time SHiFT rotated the cookies and they were stored again
+	RefreshedUnix int64 `json:"refreshed_unix"`
+}
+
+// UserBackoff is how far a user's redemptions are backing off after failing in a row
+type UserBackoff struct {
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// NextAttemptUnix is when the user's codes are next redeemed, or 0 if they aren't backing off
+	NextAttemptUnix int64 `json:"next_attempt_unix"`
+	// Warned is whether the user was told about this run of failures
+	Warned bool `json:"warned"`
+}
+
+// UserReward is a reward listed on a user's SHiFT rewards page
+type UserReward struct {
+	Platform    string `json:"platform"`
+	Game        string `json:"game"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// UnlockedUnix is the day SHiFT says the reward was unlocked (midnight UTC), or 0 if it isn't known
+	UnlockedUnix int64 `json:"unlocked_unix"`
+	// Code is the code SlickShift redeemed to unlock the reward, if it did
+	Code        sql.NullString `json:"code"`
+	CreatedUnix int64          `json:"created_unix"`
+}
+
+// GoldenKeys is how many golden keys a user unlocked on a platform in a month
+type GoldenKeys struct {
+	Platform string `json:"platform"`
+	Month    string `json:"month"` // like 2025-09
+	// Earned is how many were unlocked by codes SlickShift redeemed
+	Earned int64 `json:"earned"`
+	Total  int64 `json:"total"`
+}
+
+type ShiftCode struct {
+	Code string `json:"code"`
+	Game string `json:"game"`
+}
+
+type CodeValidation string
+
+const (
+	// ValidationPending codes haven't been tried on a canary account yet, so they're only redeemed for canaries
+	ValidationPending CodeValidation = "pending"
+	// ValidationValid codes are redeemed for everyone
+	ValidationValid CodeValidation = "valid"
+	// ValidationInvalid codes were expired or didn't exist when a canary account tried them
+	ValidationInvalid CodeValidation = "invalid"
+)
+
+// PendingCode is a code waiting to be tried on a canary account
+type PendingCode struct {
+	Code string `json:"code"`
+	Game string `json:"game"`
+	// UserID is who added the code, if it was added by a user
+	UserID      sql.NullString `json:"user_id"`
+	CreatedUnix int64          `json:"created_unix"`
+}
+
+type RedemptionJobState string
+
+const (
+	// JobPending jobs are waiting to be claimed, once their next run time has passed
+	JobPending RedemptionJobState = "pending"
+	// JobRunning jobs have been claimed by a worker, until their lease expires
+	JobRunning RedemptionJobState = "running"
+	// JobParked jobs failed too many times, and aren't retried until they're requeued
+	JobParked RedemptionJobState = "parked"
+)
+
+// RedemptionJob is a code waiting to be redeemed for a user on a platform
+type RedemptionJob struct {
+	ID          int64              `json:"id"`
+	UserID      string             `json:"user_id"`
+	Code        string             `json:"code"`
+	Game        string             `json:"game"`
+	Platform    string             `json:"platform"`
+	State       RedemptionJobState `json:"state"`
+	Attempts    int                `json:"attempts"`
+	NextRunUnix int64              `json:"next_run_unix"`
+	LastError   string             `json:"last_error,omitempty"`
+	CreatedUnix int64              `json:"created_unix"`
+	UpdatedUnix int64              `json:"updated_unix"`
+	// ClaimedBy is the worker a running job was claimed by, and LeaseExpiresUnix is when it's considered abandoned
+	ClaimedBy        string `json:"claimed_by,omitempty"`
+	LeaseExpiresUnix int64  `json:"lease_expires_unix,omitempty"`
+}
+
 type Redemption struct {
 	Code     string         `json:"code"`
 	Platform string         `json:"platform"`
@@ -27,27 +127,93 @@ type Statistics struct {
 
 const DiscordSource = "discord"
 
+// DefaultInvalidCodeThreshold is how many expired or not exist results a code needs on a platform before it stops
+// being redeemed on that platform
+const DefaultInvalidCodeThreshold = 2
+
+type options struct {
+	invalidCodeThreshold int
+}
+
+func defaultOptions() options {
+	return options{
+		invalidCodeThreshold: DefaultInvalidCodeThreshold,
+	}
+}
+
+// Option configures optional behavior of a Store
+type Option func(*options)
+
+// WithInvalidCodeThreshold sets how many expired or not exist results (since the last success) a code needs on a
+// platform before it is considered invalid for that platform
+func WithInvalidCodeThreshold(threshold int) Option {
+	return func(o *options) {
+		if threshold > 0 {
+			o.invalidCodeThreshold = threshold
+		}
+	}
+}
+
+// ErrUnrecognizedRedemption is returned when a redemption result without a recognized outcome is added
+var ErrUnrecognizedRedemption = errors.New("redemption result has no recognized status")
+
 type Store interface {
 	UserExists(userID string) bool
 	AddUser(userID string) error
-	GetUserPlatformAndDM(userID string) (string, bool, error)
-	SetUserPlatform(userID, platform string) error
+	GetUserPlatformsAndDM(userID string) ([]string, bool, error)
+	SetUserPlatforms(userID string, platforms []string) error
 	SetUserDM(userID string, dm bool) error
+	GetUserGames(userID string) ([]string, error)
+	SetUserGames(userID string, games []string) error
+
+	GetUserCanary(userID string) (bool, error)
+	SetUserCanary(userID string, canary bool) error
+	GetCanaryUsers() ([]string, error)
+	GetUserBackoff(userID string) (UserBackoff, error)
+	SetUserBackoff(userID string, backoff UserBackoff) error
+	ResetUserBackoff(userID string) error
 
 	UserCookiesExists(userID string) bool
 	EncryptAndSetUserCookies(userID string, cookie []*http.Cookie) error
 	GetDecryptedUserCookies(userID string) ([]*http.Cookie, error)
 	DeleteUserCookies(userID string) error
 	GetAllDecryptedUserCookiesSorted(limit int64) ([]UserCookies, error)
+	GetUserSession(userID string) (Session, error)
+	SetUserSession(userID string, session shift.Session) error
+	SetUserSessionWarned(userID string) error
+	SetUserCookiesRefreshed(userID string) error
 
 	CodeExists(code string) bool
 	AddCode(code, game string, userID *string, source *string) error
+	AddCodeForValidation(code, game string, userID *string, source *string) (bool, error)
+	GetPendingCodes() ([]PendingCode, error)
+	SetCodeValidation(code string, validation CodeValidation) error
 	SetCodeRewardAndSuccess(code, reward string, success bool) (bool, error)
-	GetValidCodesNotRedeemedForUser(userID, platform string, limit int) ([]string, error)
+	GetValidCodesNotRedeemedForUser(userID, platform string, limit int) ([]ShiftCode, error)
+	RestoreCode(code, platform string) (bool, error)
+	CodeInvalidOnPlatform(code, platform string) (bool, error)
+	CodeFailed(code string) (bool, error)
 
+	EnqueueRedemptionJobs(userID, platform string) (int, error)
+	ClaimRedemptionJobs(workerID, userID string, limit int, lease time.Duration) ([]RedemptionJob, error)
+	FinishRedemptionJob(id int64) error
+	RetryRedemptionJob(id int64, nextRun time.Time, lastError string) error
+	ParkRedemptionJob(id int64, lastError string) error
+	ReleaseRedemptionJob(id int64) error
+	ResetRedemptionJobs() (int, error)
+	GetUsersWithDueRedemptionJobs() ([]string, error)
+	GetRedemptionJobs(state RedemptionJobState, limit int) ([]RedemptionJob, error)
+	RequeueRedemptionJob(id int64) (bool, error)
+
+	RedemptionExists(userID, code, platform string) bool
 	GetRecentRedemptionsForUser(userID, status string, quantity int) ([]Redemption, error)
 	RedemptionSummaryForUser(userID string) (map[string]int64, error)
-	AddRedemption(userID, code, platform string, status string) error
+	AddRedemption(userID, code string, result shift.RedeemResult) error
+
+	AddUserReward(userID, code string, entry shift.RewardEntry) error
+	SyncUserRewards(userID string, snapshot *shift.RewardsSnapshot) (int, error)
+	GetUserRewards(userID string, limit int) ([]UserReward, error)
+	GetUserGoldenKeys(userID string) ([]GoldenKeys, error)
 
 	AddShiftError(userID, code, platform, error string) error
 	GetShiftErrors(userID string) ([]string, error)
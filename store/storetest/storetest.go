@@ -0,0 +1,1579 @@
+// Package storetest is a conformance suite for store.Store implementations, so that every store behaves the same
+// whichever database (if any) is behind it.
+package storetest
+
+import (
+	"crypto/rand"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/denverquane/slickshift/shift"
+	"github.com/denverquane/slickshift/store"
+)
+
+// NewEncryptor creates an encryptor with a random key
+func NewEncryptor(t *testing.T) *store.Encryptor {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := store.NewEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryptor
+}
+
+// Factory creates an empty store for a test, with the options given. The store should be closed when the test ends
+type Factory func(t *testing.T, opts ...store.Option) store.Store
+
+// tests are run against every store.Store implementation, so they all behave the same
+var tests = []struct {
+	name string
+	test func(t *testing.T, newTestDB Factory)
+}{
+	{"AddUser", testAddUser},
+	{"SetUserPlatforms", testSetUserPlatforms},
+	{"GetUserPlatformsAndDM", testGetUserPlatformsAndDM},
+	{"AddCode", testAddCode},
+	{"AddRedemptionAndGetSuccessStatus", testAddRedemptionAndGetSuccessStatus},
+	{"AddRedemptionUnrecognized", testAddRedemptionUnrecognized},
+	{"GetValidCodes", testGetValidCodes},
+	{"GetValidCodesThreshold", testGetValidCodesThreshold},
+	{"GetValidCodesSuccessResets", testGetValidCodesSuccessResets},
+	{"GetValidCodesAlreadyRedeemedResets", testGetValidCodesAlreadyRedeemedResets},
+	{"RestoreCode", testRestoreCode},
+	{"SetUserGames", testSetUserGames},
+	{"UserSession", testUserSession},
+	{"UserCookiesRefreshed", testUserCookiesRefreshed},
+	{"UserRewards", testUserRewards},
+	{"GetUserGoldenKeys", testGetUserGoldenKeys},
+	{"GetValidCodesForUserGames", testGetValidCodesForUserGames},
+	{"AddError", testAddError},
+	{"GetShiftError", testGetShiftError},
+	{"ClearShiftErrors", testClearShiftErrors},
+	{"RedemptionJobs", testRedemptionJobs},
+	{"RedemptionJobsReleaseAndReset", testRedemptionJobsReleaseAndReset},
+	{"EnqueueRedemptionJobsRemovesStale", testEnqueueRedemptionJobsRemovesStale},
+	{"UserBackoff", testUserBackoff},
+	{"CodeValidation", testCodeValidation},
+	{"CanaryUsers", testCanaryUsers},
+	{"UserCookies", testUserCookies},
+	{"GetAllDecryptedUserCookiesSorted", testGetAllDecryptedUserCookiesSorted},
+	{"SetCodeRewardAndSuccess", testSetCodeRewardAndSuccess},
+	{"GetRecentRedemptionsQuantity", testGetRecentRedemptionsQuantity},
+	{"RedemptionSummaryForUser", testRedemptionSummaryForUser},
+	{"GetStatistics", testGetStatistics},
+}
+
+// Run runs every test in the suite, each against a new store from newTestDB
+func Run(t *testing.T, newTestDB Factory) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newTestDB)
+		})
+	}
+}
+
+// worker is the worker ID jobs are claimed by, unless a test needs more than one
+const worker = "worker"
+
+// SharedFactory creates two stores on the same empty database for a test, like two processes sharing it. The stores
+// should be closed when the test ends
+type SharedFactory func(t *testing.T) (store.Store, store.Store)
+
+// sharedTests are run against every store.Store implementation that more than one process can share
+var sharedTests = []struct {
+	name string
+	test func(t *testing.T, newTestDBs SharedFactory)
+}{
+	{"ResetRedemptionJobsLeavesClaimed", testResetRedemptionJobsLeavesClaimed},
+}
+
+// RunShared runs every test in the suite for stores sharing a database, each against new stores from newTestDBs
+func RunShared(t *testing.T, newTestDBs SharedFactory) {
+	for _, test := range sharedTests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newTestDBs)
+		})
+	}
+}
+
+func redeemResult(platform string, responseType shift.ResponseType) shift.RedeemResult {
+	return shift.RedeemResult{
+		Type:     responseType,
+		Message:  responseType.Status(),
+		Platform: shift.Platform(platform),
+	}
+}
+
+func testAddUser(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+
+	if st.UserExists(userID) {
+		t.Fatal("User exists when db is fresh")
+	}
+
+	err := st.AddUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.UserExists(userID) {
+		t.Fatal("User does not exist after added to DB")
+	}
+}
+
+func testSetUserPlatforms(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	const otherPlatform = string(shift.PSN)
+
+	st.AddUser(userID)
+	p, _, err := st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 0 {
+		t.Fatal("User platforms should be empty")
+	}
+
+	err = st.SetUserPlatforms(userID, []string{platform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _, err = st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != platform {
+		t.Fatal("User platforms should be " + platform)
+	}
+
+	err = st.SetUserPlatforms(userID, []string{platform, otherPlatform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _, err = st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 2 {
+		t.Fatal("User should have 2 platforms, got ", p)
+	}
+
+	// setting the platforms replaces the old ones
+	err = st.SetUserPlatforms(userID, []string{otherPlatform})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _, err = st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != otherPlatform {
+		t.Fatal("User platforms should be " + otherPlatform)
+	}
+}
+
+func testGetUserPlatformsAndDM(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+
+	p, dm, err := st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 0 {
+		t.Fatal("User platforms should be empty")
+	}
+	if dm {
+		t.Fatal("DM should be false")
+	}
+	st.SetUserPlatforms(userID, []string{platform})
+
+	p, dm, err = st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != platform {
+		t.Fatal("User platforms should be " + platform)
+	}
+	if dm {
+		t.Fatal("DM should be false")
+	}
+
+	st.SetUserDM(userID, true)
+	p, dm, err = st.GetUserPlatformsAndDM(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != platform {
+		t.Fatal("User platforms should be " + platform)
+	}
+	if !dm {
+		t.Fatal("DM should be true")
+	}
+
+}
+
+func testAddCode(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const code = "AAAAA"
+	const game = string(shift.Borderlands4)
+
+	if st.CodeExists(code) {
+		t.Fatal("Code exists when db is fresh")
+	}
+
+	err := st.AddCode(code, game, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !st.CodeExists(code) {
+		t.Fatal("Code does not exist after added to DB")
+	}
+}
+
+func testAddRedemptionAndGetSuccessStatus(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "AAAAA"
+	const code2 = "BBBBB"
+	const game = string(shift.Borderlands4)
+	const platform = string(shift.Steam)
+	const status = shift.SUCCESS
+
+	st.AddUser(userID)
+	st.AddCode(code, game, nil, nil)
+	st.AddCode(code2, game, nil, nil)
+
+	err := st.AddRedemption(userID, code, redeemResult(platform, shift.Success))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.AddRedemption(userID, code2, redeemResult(platform, shift.AlreadyRedeemed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.RedemptionExists(userID, code, platform) || st.RedemptionExists(userID, code, string(shift.Epic)) {
+		t.Fatal("Expected the redemption to exist only on the platform it was redeemed on")
+	}
+
+	redemptions, err := st.GetRecentRedemptionsForUser(userID, status, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 1 {
+		t.Fatal("Redemptions should contain 1 item")
+	}
+	r := redemptions[0]
+	if r.Status != status {
+		t.Fatal("Redemption status should be " + status)
+	}
+	if r.Code != code {
+		t.Fatal("Redemption code should be " + code)
+	}
+	if r.Game != game {
+		t.Fatal("Redemption game should be " + game)
+	}
+	if r.Platform != platform {
+		t.Fatal("Redemption platform should be " + platform)
+	}
+	redemptions, err = st.GetRecentRedemptionsForUser(userID, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 2 {
+		t.Fatal("Redemptions should contain 2 items when unfiltered")
+	}
+
+}
+
+// results that SHiFT didn't give a recognized response for should never be recorded as redemptions
+func testAddRedemptionUnrecognized(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "AAAAA"
+	const game = string(shift.Borderlands4)
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+	st.AddCode(code, game, nil, nil)
+
+	result := shift.RedeemResult{Type: shift.Unrecognized, Message: "Your code is being processed", Platform: shift.Steam}
+	err := st.AddRedemption(userID, code, result)
+	if err != store.ErrUnrecognizedRedemption {
+		t.Fatal("Expected store.ErrUnrecognizedRedemption, got ", err)
+	}
+	redemptions, err := st.GetRecentRedemptionsForUser(userID, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 0 {
+		t.Fatal("Expected no redemptions, got ", len(redemptions))
+	}
+	codes, err := st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected the code to still be redeemable, got ", len(codes))
+	}
+}
+
+// when fetching codes for a user to redeem, if other users have marked the codes as expired or invalid, those
+// codes should not be retrieved
+func testGetValidCodes(t *testing.T, newTestDB Factory) {
+	// arrange
+	st := newTestDB(t, store.WithInvalidCodeThreshold(1))
+	const userID = "123"
+	const testUserID = "234"
+
+	const goodCode = "ABCDEF"
+	const expiredCode = "BBBBB"
+	const notExistCode = "CCCCC"
+
+	const platform = string(shift.Steam)
+	const game = string(shift.Borderlands4)
+
+	st.AddUser(userID)
+	st.AddUser(testUserID)
+
+	st.SetUserPlatforms(userID, []string{platform})
+	st.SetUserPlatforms(testUserID, []string{platform})
+
+	st.AddCode(goodCode, game, nil, nil)
+	st.AddCode(expiredCode, game, nil, nil)
+	st.AddCode(notExistCode, game, nil, nil)
+
+	st.AddRedemption(userID, goodCode, redeemResult(platform, shift.Success))
+	st.AddRedemption(userID, expiredCode, redeemResult(platform, shift.Expired))
+	st.AddRedemption(userID, notExistCode, redeemResult(platform, shift.Invalid))
+
+	// act
+	codes, err := st.GetValidCodesNotRedeemedForUser(testUserID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	if len(codes) != 1 {
+		t.Fatal("Expected 1 code, got ", len(codes))
+	}
+	if codes[0].Code != goodCode {
+		t.Fatal("Expected good code, got ", codes[0].Code)
+	}
+}
+
+// a code is only invalid on a platform once enough users have seen it expire there
+func testGetValidCodesThreshold(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const code = "AAAAA"
+	const platform = string(shift.Steam)
+	const otherPlatform = string(shift.PSN)
+	users := []string{"1", "2", "3"}
+
+	for _, u := range users {
+		st.AddUser(u)
+	}
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	failed, err := st.CodeFailed(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed {
+		t.Fatal("Expected code not to have failed before any results")
+	}
+
+	st.AddRedemption(users[0], code, redeemResult(platform, shift.Expired))
+	codes, err := st.GetValidCodesNotRedeemedForUser(users[2], platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected code to be valid after a single expired result, got ", len(codes))
+	}
+	invalid, err := st.CodeInvalidOnPlatform(code, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid {
+		t.Fatal("Expected code not to be invalid on the platform after a single expired result")
+	}
+	failed, err = st.CodeFailed(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !failed {
+		t.Fatal("Expected code to have failed after a single expired result")
+	}
+
+	st.AddRedemption(users[1], code, redeemResult(platform, shift.Invalid))
+	codes, err = st.GetValidCodesNotRedeemedForUser(users[2], platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 0 {
+		t.Fatal("Expected code to be invalid after reaching the threshold, got ", len(codes))
+	}
+	invalid, err = st.CodeInvalidOnPlatform(code, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !invalid {
+		t.Fatal("Expected code to be invalid on the platform after reaching the threshold")
+	}
+
+	// other platforms are unaffected
+	codes, err = st.GetValidCodesNotRedeemedForUser(users[2], otherPlatform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected code to still be valid on another platform, got ", len(codes))
+	}
+	invalid, err = st.CodeInvalidOnPlatform(code, otherPlatform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invalid {
+		t.Fatal("Expected code not to be invalid on another platform")
+	}
+}
+
+// a success after failures contradicts them, so the failures stop counting towards the threshold
+func testGetValidCodesSuccessResets(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const code = "AAAAA"
+	const platform = string(shift.Steam)
+	users := []string{"1", "2", "3", "4"}
+
+	for _, u := range users {
+		st.AddUser(u)
+	}
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+
+	st.AddRedemption(users[0], code, redeemResult(platform, shift.Expired))
+	st.AddRedemption(users[1], code, redeemResult(platform, shift.Success))
+	failed, err := st.CodeFailed(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed {
+		t.Fatal("Expected the success to reset the code's failures")
+	}
+	st.AddRedemption(users[2], code, redeemResult(platform, shift.Expired))
+
+	codes, err := st.GetValidCodesNotRedeemedForUser(users[3], platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected code to be valid, got ", len(codes))
+	}
+}
+
+// an already redeemed result shows the code was accepted on the platform too, so it resets the failures like a success
+func testGetValidCodesAlreadyRedeemedResets(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const code = "AAAAA"
+	const platform = string(shift.Steam)
+	users := []string{"1", "2", "3", "4"}
+
+	for _, u := range users {
+		st.AddUser(u)
+	}
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+
+	st.AddRedemption(users[0], code, redeemResult(platform, shift.Expired))
+	st.AddRedemption(users[1], code, redeemResult(platform, shift.AlreadyRedeemed))
+	st.AddRedemption(users[2], code, redeemResult(platform, shift.Expired))
+
+	codes, err := st.GetValidCodesNotRedeemedForUser(users[3], platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected code to be valid, got ", len(codes))
+	}
+}
+
+func testRestoreCode(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t, store.WithInvalidCodeThreshold(1))
+	const code = "AAAAA"
+	const platform = string(shift.Steam)
+	const userID = "1"
+	const otherUserID = "2"
+
+	st.AddUser(userID)
+	st.AddUser(otherUserID)
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	st.AddRedemption(userID, code, redeemResult(platform, shift.Expired))
+
+	restored, err := st.RestoreCode(code, string(shift.PSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored {
+		t.Fatal("Code shouldn't be restored on a platform it wasn't invalid on")
+	}
+
+	restored, err = st.RestoreCode(code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Fatal("Expected code to be restored")
+	}
+	codes, err := st.GetValidCodesNotRedeemedForUser(otherUserID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 {
+		t.Fatal("Expected restored code to be valid, got ", len(codes))
+	}
+}
+
+func testSetUserGames(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+
+	st.AddUser(userID)
+	games, err := st.GetUserGames(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 0 {
+		t.Fatal("Expected no games for a new user, got ", len(games))
+	}
+
+	err = st.SetUserGames(userID, []string{string(shift.Borderlands4), string(shift.Borderlands3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetUserGames(userID, []string{string(shift.Borderlands3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	games, err = st.GetUserGames(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 || games[0] != string(shift.Borderlands3) {
+		t.Fatal("Expected only Borderlands 3, got ", games)
+	}
+}
+
+func testUserSession(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	cookies := []*http.Cookie{{Name: "si", Value: "si"}, {Name: "_session_id", Value: "session"}}
+
+	st.AddUser(userID)
+	err := st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := st.GetUserSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.State != "" || session.CheckedUnix != 0 {
+		t.Fatal("Expected an unchecked session, got ", session)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	err = st.SetUserSession(userID, shift.Session{State: shift.SessionExpiringSoon, Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetUserSessionWarned(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err = st.GetUserSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.State != shift.SessionExpiringSoon || session.ExpiresUnix != expires.Unix() || session.CheckedUnix == 0 || !session.Warned {
+		t.Fatal("Unexpected session ", session)
+	}
+
+	// logging in again starts a new session
+	err = st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err = st.GetUserSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.State != "" || session.Warned {
+		t.Fatal("Expected new cookies to reset the session, got ", session)
+	}
+}
+
+// cookies SHiFT rotated keep the warning if the session still expires at the same time
+func testUserCookiesRefreshed(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	expires := time.Now().Add(time.Hour)
+	cookies := []*http.Cookie{{Name: "si", Value: "si", Expires: expires}, {Name: "_session_id", Value: "session"}}
+
+	st.AddUser(userID)
+	err := st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetUserSessionWarned(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies[1].Value = "rotated"
+	err = st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = st.SetUserCookiesRefreshed(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := st.GetUserSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.Warned || session.ExpiresUnix != expires.Unix() || session.RefreshedUnix == 0 {
+		t.Fatal("Unexpected session ", session)
+	}
+	stored, err := st.GetDecryptedUserCookies(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[1].Value != "rotated" {
+		t.Fatal("Expected the rotated cookie to be stored, got ", stored[1].Value)
+	}
+
+	cookies[0].Expires = expires.Add(24 * time.Hour)
+	err = st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err = st.GetUserSession(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Warned {
+		t.Fatal("Expected a new expiry to reset the warning")
+	}
+}
+
+func testUserRewards(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "AAAAA-BBBBB-CCCCC-DDDDD-EEEEE"
+	day := time.Date(2025, time.September, 30, 0, 0, 0, 0, time.UTC)
+	key := shift.RewardEntry{Platform: shift.Steam, Game: shift.Borderlands4, Reward: shift.Reward{Title: shift.GoldenKey, Date: day, Description: "Unlock a golden chest"}}
+	older := key
+	older.Date = day.Add(-24 * time.Hour)
+	undated := shift.RewardEntry{Platform: shift.Steam, Game: shift.Borderlands3, Reward: shift.Reward{Title: "Diamond Key"}}
+
+	st.AddUser(userID)
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+
+	// what the user already had before joining
+	added, err := st.SyncUserRewards(userID, &shift.RewardsSnapshot{Entries: []shift.RewardEntry{older, undated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 {
+		t.Fatal("Expected 2 rewards to be backfilled, got ", added)
+	}
+
+	// a key redeemed by SlickShift is attributed to its code, and not stored again by the next sync
+	err = st.AddUserReward(userID, code, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, err = st.SyncUserRewards(userID, &shift.RewardsSnapshot{Entries: []shift.RewardEntry{key, older, undated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 {
+		t.Fatal("Expected nothing new, got ", added)
+	}
+	// the same reward unlocked again on the same day is a second reward
+	added, err = st.SyncUserRewards(userID, &shift.RewardsSnapshot{Entries: []shift.RewardEntry{key, key, older, undated}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Fatal("Expected the second key to be added, got ", added)
+	}
+
+	rewards, err := st.GetUserRewards(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 4 {
+		t.Fatal("Expected 4 rewards, got ", len(rewards))
+	}
+	if rewards[0].UnlockedUnix != 0 || rewards[0].Title != "Diamond Key" {
+		// undated rewards sort by when SlickShift saw them, which is now
+		t.Fatal("Expected the undated reward first, got ", rewards[0])
+	}
+	if rewards[2].Code.String != code || rewards[2].UnlockedUnix != day.Unix() {
+		t.Fatal("Expected the attributed key, got ", rewards[2])
+	}
+	if rewards[3].UnlockedUnix != older.Date.Unix() || rewards[3].Code.Valid {
+		t.Fatal("Expected the backfilled key last, got ", rewards[3])
+	}
+}
+
+func testGetUserGoldenKeys(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "AAAAA-BBBBB-CCCCC-DDDDD-EEEEE"
+	october := time.Date(2025, time.October, 3, 0, 0, 0, 0, time.UTC)
+	september := time.Date(2025, time.September, 12, 0, 0, 0, 0, time.UTC)
+	entry := func(platform shift.Platform, title string, date time.Time) shift.RewardEntry {
+		return shift.RewardEntry{Platform: platform, Game: shift.Borderlands4, Reward: shift.Reward{Title: title, Date: date}}
+	}
+
+	st.AddUser(userID)
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	err := st.AddUserReward(userID, code, entry(shift.Steam, shift.GoldenKey, october))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = st.SyncUserRewards(userID, &shift.RewardsSnapshot{Entries: []shift.RewardEntry{
+		entry(shift.Steam, shift.GoldenKey, october),
+		entry(shift.Steam, "5 Golden Keys", october),
+		entry(shift.Steam, "Vault Hunter Skin", october),
+		entry(shift.PSN, shift.GoldenKey, september),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := st.GetUserGoldenKeys(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []store.GoldenKeys{
+		{Platform: string(shift.Steam), Month: "2025-10", Earned: 1, Total: 6},
+		{Platform: string(shift.PSN), Month: "2025-09", Earned: 0, Total: 1},
+	}
+	if len(keys) != len(expected) {
+		t.Fatal("Expected 2 months of keys, got ", keys)
+	}
+	for i := range expected {
+		if keys[i] != expected[i] {
+			t.Fatalf("Expected %+v, got %+v", expected[i], keys[i])
+		}
+	}
+}
+
+// users only get codes for the games they picked, or the default game if they haven't picked any
+func testGetValidCodesForUserGames(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const bl4Code = "AAAAA"
+	const bl3Code = "BBBBB"
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+	st.AddCode(bl4Code, string(shift.Borderlands4), nil, nil)
+	st.AddCode(bl3Code, string(shift.Borderlands3), nil, nil)
+
+	codes, err := st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0].Code != bl4Code {
+		t.Fatal("Expected only the default game's code, got ", codes)
+	}
+
+	st.SetUserGames(userID, []string{string(shift.Borderlands3)})
+	codes, err = st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0].Code != bl3Code || codes[0].Game != string(shift.Borderlands3) {
+		t.Fatal("Expected only the Borderlands 3 code, got ", codes)
+	}
+
+	st.SetUserGames(userID, []string{string(shift.Borderlands3), string(shift.Borderlands4)})
+	codes, err = st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 {
+		t.Fatal("Expected codes for both games, got ", codes)
+	}
+}
+
+func testAddError(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "XXXXX"
+	const game = string(shift.Borderlands4)
+	const platform = string(shift.Steam)
+	const errText = "234"
+
+	st.AddUser(userID)
+	st.AddCode(code, game, nil, nil)
+
+	err := st.AddShiftError(userID, code, platform, errText)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testGetShiftError(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "XXXXX"
+	const game = string(shift.Borderlands4)
+	const platform = string(shift.Steam)
+	const errText = "234"
+
+	st.AddUser(userID)
+	st.AddCode(code, game, nil, nil)
+	st.AddShiftError(userID, code, platform, errText)
+
+	errs, err := st.GetShiftErrors(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatal("Expected 1 code, got ", len(errs))
+	}
+	if errs[0] != errText {
+		t.Fatal("Expected err text, got ", errs[0])
+	}
+}
+
+func testClearShiftErrors(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "XXXXX"
+	const game = string(shift.Borderlands4)
+	const platform = string(shift.Steam)
+	const errText = "234"
+
+	st.AddUser(userID)
+	st.AddCode(code, game, nil, nil)
+	st.AddShiftError(userID, code, platform, errText)
+
+	err := st.ClearShiftErrors(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs, err := st.GetShiftErrors(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatal("Expected 0 code, got ", len(errs))
+	}
+}
+
+func testRedemptionJobs(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	const game = string(shift.Borderlands4)
+	const code = "AAAAA-AAAAA-AAAAA-AAAAA-AAAAA"
+	const redeemed = "BBBBB-BBBBB-BBBBB-BBBBB-BBBBB"
+
+	st.AddUser(userID)
+	st.SetUserPlatforms(userID, []string{platform})
+	st.AddCode(code, game, nil, nil)
+	st.AddCode(redeemed, game, nil, nil)
+	st.AddRedemption(userID, redeemed, redeemResult(platform, shift.Success))
+
+	added, err := st.EnqueueRedemptionJobs(userID, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Fatal("Expected a job for the code that wasn't redeemed yet, got ", added)
+	}
+	added, err = st.EnqueueRedemptionJobs(userID, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 {
+		t.Fatal("Expected jobs not to be enqueued twice, got ", added)
+	}
+
+	users, err := st.GetUsersWithDueRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != userID {
+		t.Fatal("Expected the user to have due jobs, got ", users)
+	}
+
+	jobs, err := st.ClaimRedemptionJobs(worker, userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Code != code || jobs[0].Game != game || jobs[0].Platform != platform || jobs[0].State != store.JobRunning {
+		t.Fatalf("Unexpected claimed jobs %+v", jobs)
+	}
+	again, err := st.ClaimRedemptionJobs(worker, userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Fatal("Expected running jobs not to be claimed again, got ", len(again))
+	}
+
+	// a retry isn't due until its next run
+	err = st.RetryRedemptionJob(jobs[0].ID, time.Now().Add(time.Hour), "timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err = st.ClaimRedemptionJobs(worker, userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Fatal("Expected the retry not to be due yet, got ", len(again))
+	}
+	pending, err := st.GetRedemptionJobs(store.JobPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError != "timeout" {
+		t.Fatalf("Unexpected pending jobs %+v", pending)
+	}
+
+	err = st.ParkRedemptionJob(jobs[0].ID, "failed again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parked, err := st.GetRedemptionJobs(store.JobParked, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parked) != 1 || parked[0].Attempts != 2 || parked[0].LastError != "failed again" {
+		t.Fatalf("Unexpected parked jobs %+v", parked)
+	}
+
+	requeued, err := st.RequeueRedemptionJob(jobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !requeued {
+		t.Fatal("Expected the parked job to be requeued")
+	}
+	jobs, err = st.ClaimRedemptionJobs(worker, userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Attempts != 0 {
+		t.Fatalf("Expected the requeued job to be claimed with no attempts, got %+v", jobs)
+	}
+
+	err = st.FinishRedemptionJob(jobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err = st.GetUsersWithDueRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatal("Expected no due jobs once finished, got ", users)
+	}
+}
+
+func testRedemptionJobsReleaseAndReset(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	const game = string(shift.Borderlands4)
+
+	st.AddUser(userID)
+	st.SetUserPlatforms(userID, []string{platform})
+	st.AddCode("AAAAA-AAAAA-AAAAA-AAAAA-AAAAA", game, nil, nil)
+	st.AddCode("BBBBB-BBBBB-BBBBB-BBBBB-BBBBB", game, nil, nil)
+	st.EnqueueRedemptionJobs(userID, platform)
+
+	// claimed by a worker that stopped without finishing them, so their lease has expired
+	jobs, err := st.ClaimRedemptionJobs(worker, userID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatal("Expected 2 jobs, got ", len(jobs))
+	}
+	// a job a worker is still redeeming isn't reset
+	st.AddCode("CCCCC-CCCCC-CCCCC-CCCCC-CCCCC", game, nil, nil)
+	st.EnqueueRedemptionJobs(userID, platform)
+	leased, err := st.ClaimRedemptionJobs(worker, userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 {
+		t.Fatal("Expected 1 job, got ", len(leased))
+	}
+	err = st.ReleaseRedemptionJob(jobs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reset, err := st.ResetRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset != 1 {
+		t.Fatal("Expected 1 job to be reset, got ", reset)
+	}
+	pending, err := st.GetRedemptionJobs(store.JobPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatal("Expected both jobs to be pending, got ", len(pending))
+	}
+	for _, job := range pending {
+		if job.Attempts != 0 || job.ClaimedBy != "" || job.LeaseExpiresUnix != 0 {
+			t.Fatalf("Expected releasing not to count an attempt, and to drop the claim, got %+v", job)
+		}
+	}
+	running, err := st.GetRedemptionJobs(store.JobRunning, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].ID != leased[0].ID || running[0].ClaimedBy != worker || running[0].LeaseExpiresUnix <= time.Now().Unix() {
+		t.Fatalf("Expected the leased job to stay claimed, got %+v", running)
+	}
+}
+
+// a process starting up doesn't take the jobs another process is redeeming, only ones whose lease expired
+func testResetRedemptionJobsLeavesClaimed(t *testing.T, newTestDBs SharedFactory) {
+	first, second := newTestDBs(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	const game = string(shift.Borderlands4)
+
+	first.AddUser(userID)
+	first.SetUserPlatforms(userID, []string{platform})
+	first.AddCode("AAAAA-AAAAA-AAAAA-AAAAA-AAAAA", game, nil, nil)
+	first.EnqueueRedemptionJobs(userID, platform)
+	claimed, err := first.ClaimRedemptionJobs("first", userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatal("Expected 1 job, got ", len(claimed))
+	}
+
+	reset, err := second.ResetRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset != 0 {
+		t.Fatal("Expected no jobs to be reset, got ", reset)
+	}
+	running, err := second.GetRedemptionJobs(store.JobRunning, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].ID != claimed[0].ID || running[0].ClaimedBy != "first" {
+		t.Fatalf("Expected the job to stay claimed by the first store, got %+v", running)
+	}
+	again, err := second.ClaimRedemptionJobs("second", userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Fatal("Expected the claimed job not to be claimed again, got ", len(again))
+	}
+
+	// the first store stopped without finishing a job, so once its lease expires the second store picks it up
+	first.AddCode("BBBBB-BBBBB-BBBBB-BBBBB-BBBBB", game, nil, nil)
+	first.EnqueueRedemptionJobs(userID, platform)
+	abandoned, err := first.ClaimRedemptionJobs("first", userID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(abandoned) != 1 {
+		t.Fatal("Expected 1 job, got ", len(abandoned))
+	}
+	reset, err = second.ResetRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reset != 1 {
+		t.Fatal("Expected the abandoned job to be reset, got ", reset)
+	}
+	again, err = second.ClaimRedemptionJobs("second", userID, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].ID != abandoned[0].ID || again[0].ClaimedBy != "second" {
+		t.Fatalf("Expected the abandoned job to be claimed by the second store, got %+v", again)
+	}
+}
+
+func testEnqueueRedemptionJobsRemovesStale(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const game = string(shift.Borderlands4)
+	const code = "AAAAA-AAAAA-AAAAA-AAAAA-AAAAA"
+
+	st.AddUser(userID)
+	st.SetUserPlatforms(userID, []string{string(shift.Steam), string(shift.Epic)})
+	st.AddCode(code, game, nil, nil)
+	st.EnqueueRedemptionJobs(userID, string(shift.Steam))
+	st.EnqueueRedemptionJobs(userID, string(shift.Epic))
+
+	// the user drops epic, and the code is redeemed on steam some other way
+	st.SetUserPlatforms(userID, []string{string(shift.Steam)})
+	st.AddRedemption(userID, code, redeemResult(string(shift.Steam), shift.Success))
+	_, err := st.EnqueueRedemptionJobs(userID, string(shift.Steam))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := st.GetRedemptionJobs(store.JobPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("Expected stale jobs to be removed, got %+v", pending)
+	}
+}
+
+func testUserBackoff(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	st.AddUser(userID)
+
+	backoff, err := st.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff != (store.UserBackoff{}) {
+		t.Fatalf("Expected a new user not to be backing off, got %+v", backoff)
+	}
+
+	next := time.Now().Add(time.Hour).Unix()
+	err = st.SetUserBackoff(userID, store.UserBackoff{ConsecutiveFailures: 5, NextAttemptUnix: next, Warned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backoff, err = st.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff.ConsecutiveFailures != 5 || backoff.NextAttemptUnix != next || !backoff.Warned {
+		t.Fatalf("Unexpected backoff %+v", backoff)
+	}
+
+	// jobs for users backing off aren't due
+	st.SetUserPlatforms(userID, []string{string(shift.Steam)})
+	st.AddCode("AAAAA-AAAAA-AAAAA-AAAAA-AAAAA", string(shift.Borderlands4), nil, nil)
+	st.EnqueueRedemptionJobs(userID, string(shift.Steam))
+	users, err := st.GetUsersWithDueRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Fatal("Expected no due jobs while the user is backing off, got ", users)
+	}
+
+	err = st.ResetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backoff, err = st.GetUserBackoff(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backoff != (store.UserBackoff{}) {
+		t.Fatalf("Expected the backoff to be reset, got %+v", backoff)
+	}
+	users, err = st.GetUsersWithDueRedemptionJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatal("Expected the user's jobs to be due again, got ", users)
+	}
+}
+
+func testCodeValidation(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+	const game = string(shift.Borderlands4)
+	const code = "AAAAA-AAAAA-AAAAA-AAAAA-AAAAA"
+	const typo = "BBBBB-BBBBB-BBBBB-BBBBB-BBBBB"
+
+	st.AddUser(userID)
+	st.SetUserPlatforms(userID, []string{platform})
+	adder := userID
+	inserted, err := st.AddCodeForValidation(code, game, &adder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inserted {
+		t.Fatal("Expected the code to be added")
+	}
+	st.AddCodeForValidation(typo, game, nil, nil)
+	// adding a code twice leaves the first one as it was
+	inserted, err = st.AddCodeForValidation(code, game, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted {
+		t.Fatal("Expected a code that already exists not to be added again")
+	}
+
+	pending, err := st.GetPendingCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatal("Expected both codes to be pending, got ", len(pending))
+	}
+	if pending[0].Code != code || pending[0].UserID.String != userID {
+		t.Fatalf("Unexpected pending code %+v", pending[0])
+	}
+
+	// pending codes aren't redeemed for anyone yet
+	codes, err := st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 0 {
+		t.Fatal("Expected pending codes not to be valid, got ", len(codes))
+	}
+	added, err := st.EnqueueRedemptionJobs(userID, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 {
+		t.Fatal("Expected no jobs for pending codes, got ", added)
+	}
+
+	st.SetCodeValidation(code, store.ValidationValid)
+	st.SetCodeValidation(typo, store.ValidationInvalid)
+	pending, err = st.GetPendingCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatal("Expected no pending codes, got ", len(pending))
+	}
+	codes, err = st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0].Code != code {
+		t.Fatal("Expected only the validated code to be valid, got ", codes)
+	}
+
+	// restoring a code that failed validation makes it valid again
+	restored, err := st.RestoreCode(typo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Fatal("Expected the invalid code to be restored")
+	}
+	codes, err = st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 {
+		t.Fatal("Expected the restored code to be valid, got ", len(codes))
+	}
+}
+
+func testCanaryUsers(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const canaryID = "1"
+	const noCookiesID = "2"
+	const otherID = "3"
+
+	for _, userID := range []string{canaryID, noCookiesID, otherID} {
+		st.AddUser(userID)
+	}
+	st.EncryptAndSetUserCookies(canaryID, []*http.Cookie{{Name: "a", Value: "b"}})
+	st.EncryptAndSetUserCookies(otherID, []*http.Cookie{{Name: "a", Value: "b"}})
+
+	canary, err := st.GetUserCanary(canaryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canary {
+		t.Fatal("Expected users not to be canaries by default")
+	}
+	st.SetUserCanary(canaryID, true)
+	st.SetUserCanary(noCookiesID, true)
+	canary, err = st.GetUserCanary(canaryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !canary {
+		t.Fatal("Expected user to be a canary")
+	}
+
+	users, err := st.GetCanaryUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0] != canaryID {
+		t.Fatal("Expected only the canary with cookies, got ", users)
+	}
+}
+
+// nextSecond waits for the clock to tick over to the next second, since stores keep times in whole seconds
+func nextSecond() {
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+}
+
+func testUserCookies(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	cookies := []*http.Cookie{{Name: "si", Value: "si"}, {Name: "_session_id", Value: "session"}}
+
+	st.AddUser(userID)
+	if st.UserCookiesExists(userID) {
+		t.Fatal("Cookies exist before they were set")
+	}
+	err := st.EncryptAndSetUserCookies(userID, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.UserCookiesExists(userID) {
+		t.Fatal("Cookies do not exist after they were set")
+	}
+	decrypted, err := st.GetDecryptedUserCookies(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decrypted) != 2 || decrypted[0].Name != "si" || decrypted[1].Value != "session" {
+		t.Fatal("Unexpected cookies ", decrypted)
+	}
+
+	err = st.DeleteUserCookies(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.UserCookiesExists(userID) {
+		t.Fatal("Cookies exist after they were deleted")
+	}
+	_, err = st.GetDecryptedUserCookies(userID)
+	if err == nil {
+		t.Fatal("Expected an error getting deleted cookies")
+	}
+}
+
+// users who have never redeemed anything come first, then whoever redeemed longest ago
+func testGetAllDecryptedUserCookiesSorted(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const code = "AAAAA"
+	const platform = string(shift.Steam)
+	const noCookiesUser = "4"
+	cookies := []*http.Cookie{{Name: "si", Value: "si"}}
+
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	for _, userID := range []string{"1", "2", "3", noCookiesUser} {
+		st.AddUser(userID)
+		if userID != noCookiesUser {
+			st.EncryptAndSetUserCookies(userID, cookies)
+		}
+	}
+	st.AddRedemption("2", code, redeemResult(platform, shift.Success))
+	nextSecond()
+	st.AddRedemption("1", code, redeemResult(platform, shift.Success))
+	st.AddRedemption(noCookiesUser, code, redeemResult(platform, shift.Success))
+
+	userCookies, err := st.GetAllDecryptedUserCookiesSorted(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, u := range userCookies {
+		order = append(order, u.UserID)
+	}
+	if len(order) != 3 || order[0] != "3" || order[1] != "2" || order[2] != "1" {
+		t.Fatal("Expected users 3, 2, 1, got ", order)
+	}
+	if len(userCookies[0].Cookies) != 1 || userCookies[0].Cookies[0].Value != "si" {
+		t.Fatal("Unexpected cookies ", userCookies[0].Cookies)
+	}
+
+	userCookies, err = st.GetAllDecryptedUserCookiesSorted(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userCookies) != 2 || userCookies[0].UserID != "3" || userCookies[1].UserID != "2" {
+		t.Fatal("Expected the first 2 users, got ", userCookies)
+	}
+}
+
+// the first reward stored for a code is kept, but every success counts
+func testSetCodeRewardAndSuccess(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const code = "AAAAA"
+	const successCode = "BBBBB"
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+	st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	st.AddCode(successCode, string(shift.Borderlands4), nil, nil)
+
+	set, err := st.SetCodeRewardAndSuccess(code, shift.GoldenKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set {
+		t.Fatal("Expected the first reward to be set")
+	}
+	set, err = st.SetCodeRewardAndSuccess(code, "Diamond Key", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set {
+		t.Fatal("Expected the second reward not to be set")
+	}
+	set, err = st.SetCodeRewardAndSuccess("XXXXX", shift.GoldenKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set {
+		t.Fatal("Expected no reward to be set for an unknown code")
+	}
+
+	// a code that was redeemed successfully is tried before ones that haven't been
+	_, err = st.SetCodeRewardAndSuccess(successCode, shift.GoldenKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := st.GetValidCodesNotRedeemedForUser(userID, platform, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0].Code != successCode {
+		t.Fatal("Expected the successful code first, got ", codes)
+	}
+
+	st.AddRedemption(userID, code, redeemResult(platform, shift.AlreadyRedeemed))
+	redemptions, err := st.GetRecentRedemptionsForUser(userID, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 1 || redemptions[0].Reward.String != shift.GoldenKey {
+		t.Fatal("Expected the first reward, got ", redemptions)
+	}
+}
+
+func testGetRecentRedemptionsQuantity(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+	for _, code := range []string{"AAAAA", "BBBBB"} {
+		st.AddCode(code, string(shift.Borderlands4), nil, nil)
+		st.AddRedemption(userID, code, redeemResult(platform, shift.Success))
+	}
+
+	for _, quantity := range []int{0, -1} {
+		redemptions, err := st.GetRecentRedemptionsForUser(userID, "", quantity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redemptions != nil {
+			t.Fatal("Expected no redemptions for a quantity of ", quantity, ", got ", redemptions)
+		}
+	}
+	redemptions, err := st.GetRecentRedemptionsForUser(userID, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redemptions) != 1 {
+		t.Fatal("Expected 1 redemption, got ", len(redemptions))
+	}
+}
+
+func testRedemptionSummaryForUser(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const otherUserID = "234"
+	const platform = string(shift.Steam)
+
+	st.AddUser(userID)
+	st.AddUser(otherUserID)
+	results := map[string]shift.ResponseType{
+		"AAAAA": shift.Success,
+		"BBBBB": shift.AlreadyRedeemed,
+		"CCCCC": shift.AlreadyRedeemed,
+		"DDDDD": shift.Expired,
+	}
+	for code, result := range results {
+		st.AddCode(code, string(shift.Borderlands4), nil, nil)
+		st.AddRedemption(userID, code, redeemResult(platform, result))
+	}
+	st.AddRedemption(otherUserID, "AAAAA", redeemResult(platform, shift.Success))
+
+	summary, err := st.RedemptionSummaryForUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary["total"] != 4 || summary["success"] != 1 || summary["already_redeemed"] != 2 {
+		t.Fatal("Unexpected summary ", summary)
+	}
+}
+
+func testGetStatistics(t *testing.T, newTestDB Factory) {
+	st := newTestDB(t)
+	const userID = "123"
+	const otherUserID = "234"
+
+	st.AddUser(userID)
+	st.AddUser(otherUserID)
+	st.SetUserPlatforms(userID, []string{string(shift.Steam), string(shift.Epic)})
+	st.SetUserPlatforms(otherUserID, []string{string(shift.Steam)})
+	for _, code := range []string{"AAAAA", "BBBBB", "CCCCC"} {
+		st.AddCode(code, string(shift.Borderlands4), nil, nil)
+	}
+	st.SetCodeRewardAndSuccess("AAAAA", shift.GoldenKey, true)
+	st.SetCodeRewardAndSuccess("BBBBB", "Vault Hunter Skin", true)
+	st.AddRedemption(userID, "AAAAA", redeemResult(string(shift.Steam), shift.Success))
+	st.AddRedemption(userID, "BBBBB", redeemResult(string(shift.Steam), shift.AlreadyRedeemed))
+	st.AddRedemption(otherUserID, "CCCCC", redeemResult(string(shift.Steam), shift.Expired))
+
+	stats, err := st.GetStatistics(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users["total"] != 2 || stats.Users["steam"] != 2 || stats.Users["epic"] != 1 || stats.Users["psn"] != 0 {
+		t.Fatal("Unexpected user statistics ", stats.Users)
+	}
+	if stats.Codes["total"] != 3 || stats.Codes["golden_key"] != 1 || stats.Codes["other"] != 1 || stats.Codes["unknown"] != 1 {
+		t.Fatal("Unexpected code statistics ", stats.Codes)
+	}
+	if stats.Redemptions["total"] != 3 || stats.Redemptions["success"] != 1 ||
+		stats.Redemptions["already_redeemed"] != 1 || stats.Redemptions["expired"] != 1 {
+		t.Fatal("Unexpected redemption statistics ", stats.Redemptions)
+	}
+}